@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/deadletter"
+	"zms.szuro.net/internal/input"
+	"zms.szuro.net/internal/logger"
+)
+
+const deadLetterUsage = `Usage: zmsd deadletter list [options]
+       zmsd deadletter inject [options] <file|->
+
+list prints lines of export files that could not be parsed. With -json every
+entry is printed as a JSON object, so the output can be edited and passed to inject.
+
+inject sends fixed lines of entries (as printed by list -json) through
+configured filters and targets, then exits with a summary.
+
+Options:
+`
+
+// deadLetter implements the deadletter subcommand and returns the exit code.
+// Exit code is 1 if any target failed to accept values, 2 on usage errors.
+func deadLetter(args []string) int {
+	fs := flag.NewFlagSet("deadletter", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), deadLetterUsage)
+		fs.PrintDefaults()
+	}
+	zmsPath := fs.String("c", "/etc/zmsd.yaml", "Path of config file")
+	dir := fs.String("dir", "", "Dead-letter directory (default dead_letter directory of the config)")
+	asJSON := fs.Bool("json", false, "list: print entries as NDJSON")
+	export := fs.String("export", "", "list: print only entries of this export type: history, trends or events")
+	if len(args) == 0 {
+		fs.Usage()
+		return 2
+	}
+	command := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	switch command {
+	case "list":
+		if *dir == "" {
+			*dir = config.ParseZMSConfig(*zmsPath).DeadLetter.Dir
+		}
+		if err := listDeadLetters(os.Stdout, *dir, *export, *asJSON); err != nil {
+			fmt.Fprintf(os.Stderr, "listing dead letters failed: %v\n", err)
+			return 1
+		}
+		return 0
+	case "inject":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return 2
+		}
+		return injectDeadLetters(*zmsPath, fs.Arg(0))
+	default:
+		fs.Usage()
+		return 2
+	}
+}
+
+func listDeadLetters(w io.Writer, dir, export string, asJSON bool) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return deadletter.ReadAll(dir, func(e deadletter.Entry) error {
+		if export != "" && e.Export != export {
+			return nil
+		}
+		if asJSON {
+			return enc.Encode(e)
+		}
+		_, err := fmt.Fprintf(w, "%s %s %s:%d: %s\n\t%s\n", e.Time.Format(time.RFC3339), e.Export, e.File, e.Offset, e.Error, e.Line)
+		return err
+	})
+}
+
+func injectDeadLetters(zmsPath, path string) int {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "inject failed: %v\n", err)
+			return 2
+		}
+		defer f.Close()
+		r = f
+	}
+	var entries []deadletter.Entry
+	err := deadletter.Decode(r, func(e deadletter.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "inject failed: %v\n", err)
+		return 2
+	}
+
+	zmsConfig := config.ParseZMSConfig(zmsPath)
+	logger.SetLogLevel(zmsConfig.GetLogLevel())
+	loadPlugins(zmsConfig)
+
+	inp, err := input.NewDeadLetterReplay(zmsConfig, entries)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "inject failed: %v\n", err)
+		return 2
+	}
+	return runReplay(inp)
+}
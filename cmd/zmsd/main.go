@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -17,16 +19,49 @@ import (
 
 	"net/http"
 
+	_ "github.com/go-sql-driver/mysql" // MySQL driver for the database HA detector and database mode
+	_ "github.com/lib/pq"              // PostgreSQL driver for the database HA detector and database mode
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// SERVER_SHUTDOWN_TIMEOUT limits waiting for open HTTP connections once the input stopped.
+const SERVER_SHUTDOWN_TIMEOUT = 5 * time.Second
+
 func printVersionInfo() {
 	fmt.Printf("ZMS %s\n", config.Version)
 	fmt.Printf("Git commit: %s\n", config.Commit)
 	fmt.Printf("Compilation time: %s\n", config.BuildDate)
 }
 
+// loadPlugins loads plugins if plugin directory is configured
+func loadPlugins(zmsConfig config.ZMSConf) {
+	if zmsConfig.PluginsDir == "" {
+		return
+	}
+	logger.Info("Loading plugins", slog.String("dir", zmsConfig.PluginsDir))
+
+	// Load new gRPC-based plugins
+	if err := plugin.GetGRPCRegistry().LoadPluginsFromDir(zmsConfig.PluginsDir); err != nil {
+		logger.Error("Failed to load gRPC plugins", slog.Any("error", err))
+		// Continue execution - plugins are optional
+	}
+
+	// List loaded gRPC plugins
+	grpcPlugins := plugin.GetGRPCRegistry().ListPlugins()
+	for _, p := range grpcPlugins {
+		logger.Info("Loaded gRPC plugin",
+			slog.String("name", p.Name),
+			slog.String("version", p.Version))
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "deadletter" {
+		os.Exit(deadLetter(os.Args[2:]))
+	}
 
 	zmsPath := flag.String("c", "/etc/zmsd.yaml", "Path of config file")
 	version := flag.Bool("v", false, "Show version info")
@@ -40,37 +75,40 @@ func main() {
 	zmsConfig := config.ParseZMSConfig(*zmsPath)
 	logger.SetLogLevel(zmsConfig.GetLogLevel())
 
-	// Load plugins if plugin directory is configured
-	if zmsConfig.PluginsDir != "" {
-		logger.Info("Loading plugins", slog.String("dir", zmsConfig.PluginsDir))
-
-		// Load new gRPC-based plugins
-		if err := plugin.GetGRPCRegistry().LoadPluginsFromDir(zmsConfig.PluginsDir); err != nil {
-			logger.Error("Failed to load gRPC plugins", slog.Any("error", err))
-			// Continue execution - plugins are optional
-		}
-
-		// List loaded gRPC plugins
-		grpcPlugins := plugin.GetGRPCRegistry().ListPlugins()
-		for _, p := range grpcPlugins {
-			logger.Info("Loaded gRPC plugin",
-				slog.String("name", p.Name),
-				slog.String("version", p.Version))
-		}
-	}
+	loadPlugins(zmsConfig)
 
 	var inp input.Inputer
 
 	switch zmsConfig.Mode {
 	case config.FILE_MODE:
-		zbxConfig, _ := zbx.ParseZabbixConfig(zmsConfig.ServerConfig)
+		zbxConfig, err := zbx.ParseZabbixConfig(zmsConfig.ServerConfig)
+		if err != nil {
+			logger.Error("Failed to parse Zabbix config. Aborting.", slog.String("path", zmsConfig.ServerConfig), slog.Any("error", err))
+			os.Exit(1)
+		}
 		if zbxConfig.ExportDir == "" {
-			logger.Error("Export not enabled. Aborting.")
+			logger.Error("Export not enabled. Aborting.", slog.String("path", zmsConfig.ServerConfig))
 			return
 		}
-		inp, _ = input.NewFileInput(zbxConfig, zmsConfig)
+		inp, err = input.NewFileInput(zbxConfig, zmsConfig)
+		if err != nil {
+			logger.Error("Failed to create file input. Aborting.", slog.Any("error", err))
+			os.Exit(1)
+		}
 	case config.HTTP_MODE:
 		inp, _ = input.NewHTTPInput(zmsConfig)
+	case config.DB_MODE:
+		// ExportDir is not needed, the config is read for HANodeName
+		zbxConfig, err := zbx.ParseZabbixConfig(zmsConfig.ServerConfig)
+		if err != nil {
+			logger.Error("Failed to parse Zabbix config. Aborting.", slog.String("path", zmsConfig.ServerConfig), slog.Any("error", err))
+			os.Exit(1)
+		}
+		inp, err = input.NewDBInput(zbxConfig, zmsConfig)
+		if err != nil {
+			logger.Error("Failed to create database input. Aborting.", slog.Any("error", err))
+			os.Exit(1)
+		}
 	default:
 		logger.Error("Unknown mode", slog.String("mode", zmsConfig.Mode))
 		os.Exit(1)
@@ -79,31 +117,67 @@ func main() {
 	inp.Prepare()
 	config.ZmsInfo.Set(1)
 
-	http.Handle("/metrics", promhttp.Handler())
-
-	listen := fmt.Sprintf("%s:%d", zmsConfig.Http.ListenAddress, zmsConfig.Http.ListenPort)
-	go http.ListenAndServe(listen, nil)
+	var metrics http.Handler = promhttp.Handler()
+	if zmsConfig.Http.Auth.ProtectMetrics {
+		metrics = input.NewAuthenticator(zmsConfig.Http.Auth).Wrap("metrics", metrics)
+	}
+	http.Handle("/metrics", metrics)
 
-	for isActive := inp.IsReady(); !isActive; {
-		delay := time.Duration(zbx.DEFAULT_DELAY)
-		logger.Info("Input is not active, sleeping for ", slog.Duration("delay", delay))
-		time.Sleep(delay)
-		isActive = inp.IsReady()
+	// targets and filter are reloaded on SIGHUP or POST /admin/reload
+	reload := func() error {
+		return input.ReloadConfig(inp, *zmsPath)
+	}
+	if admin := input.AdminReloadHandler(zmsConfig.Http.Auth, reload); admin != nil {
+		http.Handle("/admin/reload", admin)
+	} else {
+		logger.Info("HTTP authentication is not configured, /admin/reload is disabled, use SIGHUP to reload")
 	}
 
-	logger.Info("Input is active")
+	listen := fmt.Sprintf("%s:%d", zmsConfig.Http.ListenAddress, zmsConfig.Http.ListenPort)
+	server := &http.Server{Addr: listen}
+	if zmsConfig.Http.TLSEnabled() {
+		tlsConfig, err := zmsConfig.Http.NewTLSConfig()
+		if err != nil {
+			logger.Error("Failed to load TLS certificate", slog.Any("error", err))
+			os.Exit(1)
+		}
+		server.TLSConfig = tlsConfig
+		go func() {
+			// Certificates are provided by TLSConfig
+			if err := server.ListenAndServeTLS("", ""); !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("HTTPS server stopped", slog.Any("error", err))
+			}
+		}()
+	} else {
+		go func() {
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("HTTP server stopped", slog.Any("error", err))
+			}
+		}()
+	}
 
 	inp.Start()
 
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sig, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 	for {
 		switch <-sig {
+		case syscall.SIGHUP:
+			logger.Info("Reloading config", slog.String("path", *zmsPath))
+			reload()
 		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+			logger.Info("Stopping...")
+			// the input stops intake itself, metrics stay available while draining
 			err := inp.Stop()
 			if err != nil {
 				logger.Error("stopping failed", slog.Any("error", err))
 			}
+			ctx, cancel := context.WithTimeout(context.Background(), SERVER_SHUTDOWN_TIMEOUT)
+			if err := server.Shutdown(ctx); err != nil {
+				logger.Error("Failed to shut down HTTP server", slog.Any("error", err))
+			}
+			cancel()
+			plugin.GetGRPCRegistry().CleanupAll()
 			logger.Info("Exiting...")
 			return
 		default:
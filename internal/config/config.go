@@ -1,8 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 	"zms.szuro.net/pkg/filter"
@@ -10,6 +12,10 @@ import (
 
 const FILE_MODE = "file"
 const HTTP_MODE = "http"
+const DB_MODE = "database"
+
+const DEFAULT_CHECKPOINT_INTERVAL = 5 * time.Second
+const DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
 
 type ZMSConf struct {
 	ServerConfig string `yaml:"server_config"`
@@ -17,10 +23,18 @@ type ZMSConf struct {
 	Targets      []Target
 	Filter       filter.FilterConfig `yaml:"filter,omitempty"`
 	BufferSize   int                 `yaml:"buffer_size"`
+	Flush        FlushConf           `yaml:"flush"`
+	Shards       int                 `yaml:"shards"` // Number of shards filtering and buffering values of an export in parallel
 	DataDir      string              `yaml:"data_dir"`
 	Http         HTTPConf            `yaml:"http"`
 	LogLevel     string              `yaml:"log_level"`
-	PluginsDir   string              `yaml:"plugins_dir"` // Directory containing plugin .so files
+	PluginsDir   string              `yaml:"plugins_dir"`         // Directory containing plugin .so files
+	Checkpoint   time.Duration       `yaml:"checkpoint_interval"` // How often acknowledged file offsets are saved
+	HA           HAConf              `yaml:"ha"`
+	DeadLetter   DeadLetterConf      `yaml:"dead_letter"`
+	Dedup        DedupConf           `yaml:"dedup"`
+	Database     DatabaseConf        `yaml:"database"`         // Zabbix database polled in database mode
+	Shutdown     time.Duration       `yaml:"shutdown_timeout"` // How long to wait for buffered values to be delivered when stopping
 	slogLevel    slog.Level          `yaml:"omitempty"`
 }
 
@@ -44,11 +58,37 @@ func (zc *ZMSConf) GetLogLevel() slog.Level {
 }
 
 type HTTPConf struct {
-	ListenPort    int    `yaml:"listen_port"`
-	ListenAddress string `yaml:"listen_address"`
+	ListenPort      int          `yaml:"listen_port"`
+	ListenAddress   string       `yaml:"listen_address"`
+	Auth            HTTPAuthConf `yaml:"auth"`
+	TLSCertFile     string       `yaml:"tls_cert_file"`
+	TLSKeyFile      string       `yaml:"tls_key_file"`
+	TLSClientCAFile string       `yaml:"tls_client_ca_file"` // CA verifying client certificates
+	TLSMinVersion   string       `yaml:"tls_min_version"`    // One of 1.0, 1.1, 1.2 or 1.3
+	Limits          HTTPLimits   `yaml:"limits"`
 }
 
+// ParseZMSConfig reads the config file and panics if it is invalid.
 func ParseZMSConfig(path string) (conf ZMSConf) {
+	conf, err := LoadZMSConfig(path)
+	if err != nil {
+		panic(err.Error())
+	}
+	return conf
+}
+
+// LoadZMSConfig reads the config file like ParseZMSConfig, but returns an error
+// instead of panicking, so a running instance can reject an invalid config.
+func LoadZMSConfig(path string) (conf ZMSConf, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return parseZMSConfig(path), nil
+}
+
+func parseZMSConfig(path string) (conf ZMSConf) {
 	file, err := os.ReadFile(path)
 	if err != nil {
 		panic("Cannot read ZMS config file! Reason: " + err.Error())
@@ -62,9 +102,24 @@ func ParseZMSConfig(path string) (conf ZMSConf) {
 
 	conf.setMode()
 	conf.setBuffer()
+	conf.setFlush()
+	conf.setShards()
 	conf.setPort()
+	conf.setHTTPAuth()
+	conf.setHTTPTLS()
+	conf.setHTTPLimits()
 	conf.setWorkDir()
+	conf.setCheckpointInterval()
+	conf.setShutdownTimeout()
+	conf.setTargets()
+	conf.setFilters()
 	conf.setOfflineBuffers()
+	conf.setDelivery()
+	conf.setSampling()
+	conf.setHA()
+	conf.setDeadLetter()
+	conf.setDedup()
+	conf.setDatabase()
 
 	conf.setLogLevel()
 	conf.setZbxConf()
@@ -78,12 +133,32 @@ func (zc *ZMSConf) setBuffer() {
 	}
 }
 
+func (zc *ZMSConf) setFlush() {
+	if zc.Flush.MaxRecords <= 0 {
+		zc.Flush.MaxRecords = zc.BufferSize
+	}
+	if zc.Flush.MaxAge == 0 {
+		zc.Flush.MaxAge = DEFAULT_FLUSH_AGE
+	}
+	if zc.Flush.MaxBytes < 0 {
+		zc.Flush.MaxBytes = 0
+	}
+}
+
+func (zc *ZMSConf) setShards() {
+	if zc.Shards <= 0 {
+		zc.Shards = 1
+	}
+}
+
 func (zc *ZMSConf) setMode() {
 	switch zc.Mode {
 	case FILE_MODE:
 		zc.Mode = FILE_MODE
 	case HTTP_MODE:
 		zc.Mode = HTTP_MODE
+	case DB_MODE:
+		zc.Mode = DB_MODE
 	default:
 		zc.Mode = FILE_MODE
 	}
@@ -95,6 +170,34 @@ func (zc *ZMSConf) setPort() {
 	}
 }
 
+// setTargets makes sure targets can be told apart by name, which is how they are matched on reload.
+// Unnamed targets are named after their type and position in the list, e.g. print_0.
+func (zc *ZMSConf) setTargets() {
+	names := make(map[string]bool, len(zc.Targets))
+	for i := range zc.Targets {
+		t := &zc.Targets[i]
+		if t.UniqueName == "" {
+			t.UniqueName = fmt.Sprintf("%s_%d", t.PluginBinaryName, i)
+		}
+		if names[t.UniqueName] {
+			panic("Invalid target config! Reason: duplicate target name " + t.UniqueName)
+		}
+		names[t.UniqueName] = true
+	}
+}
+
+// setFilters compiles global and target filters, so invalid patterns are rejected on load.
+func (zc *ZMSConf) setFilters() {
+	if err := zc.Filter.Validate(); err != nil {
+		panic("Invalid filter config! Reason: " + err.Error())
+	}
+	for _, t := range zc.Targets {
+		if err := t.Filter.Validate(); err != nil {
+			panic("Invalid filter config! Reason: target " + t.UniqueName + ": " + err.Error())
+		}
+	}
+}
+
 func (zc *ZMSConf) setOfflineBuffers() {
 	for i, _ := range zc.Targets {
 		if zc.Targets[i].OfflineBufferTime < 0 {
@@ -103,12 +206,30 @@ func (zc *ZMSConf) setOfflineBuffers() {
 	}
 }
 
+func (zc *ZMSConf) setDelivery() {
+	for i := range zc.Targets {
+		zc.Targets[i].Delivery.setDefaults(zc.DataDir, zc.Targets[i].UniqueName)
+	}
+}
+
 func (zc *ZMSConf) setWorkDir() {
 	if zc.DataDir == "" {
 		zc.DataDir = "/var/lib/zms/"
 	}
 }
 
+func (zc *ZMSConf) setCheckpointInterval() {
+	if zc.Checkpoint <= 0 {
+		zc.Checkpoint = DEFAULT_CHECKPOINT_INTERVAL
+	}
+}
+
+func (zc *ZMSConf) setShutdownTimeout() {
+	if zc.Shutdown <= 0 {
+		zc.Shutdown = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+}
+
 func (zc *ZMSConf) setZbxConf() {
 	if zc.ServerConfig == "" {
 		zc.ServerConfig = "/etc/zabbix/zabbix_server.conf"
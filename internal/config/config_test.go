@@ -1,9 +1,14 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"zms.szuro.net/pkg/filter"
 )
 
 func TestSetBuffer(t *testing.T) {
@@ -36,6 +41,7 @@ func TestSetMode(t *testing.T) {
 	}{
 		{"Valid Mode - FILE_MODE", FILE_MODE, FILE_MODE},
 		{"Valid Mode - HTTP_MODE", HTTP_MODE, HTTP_MODE},
+		{"Valid Mode - DB_MODE", DB_MODE, DB_MODE},
 		{"Empty Mode", "", FILE_MODE},
 		{"Random Mode", "FNORD", FILE_MODE},
 	}
@@ -52,6 +58,16 @@ func TestSetMode(t *testing.T) {
 	}
 }
 
+func TestSetShards(t *testing.T) {
+	conf := ZMSConf{}
+	conf.setShards()
+	require.Equal(t, 1, conf.Shards)
+
+	conf = ZMSConf{Shards: 8}
+	conf.setShards()
+	require.Equal(t, 8, conf.Shards)
+}
+
 func TestSetPort(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -115,3 +131,345 @@ func TestZMSConf_setOfflineBuffers(t *testing.T) {
 		})
 	}
 }
+
+func TestSetFlush(t *testing.T) {
+	tests := []struct {
+		name     string
+		buffer   int
+		input    FlushPolicy
+		expected FlushPolicy
+	}{
+		{"Defaults", 100, FlushPolicy{}, FlushPolicy{MaxRecords: 100, MaxAge: DEFAULT_FLUSH_AGE}},
+		{"Explicit", 100, FlushPolicy{MaxRecords: 10, MaxAge: time.Minute, MaxBytes: 1024}, FlushPolicy{MaxRecords: 10, MaxAge: time.Minute, MaxBytes: 1024}},
+		{"Negative bytes", 50, FlushPolicy{MaxBytes: -1}, FlushPolicy{MaxRecords: 50, MaxAge: DEFAULT_FLUSH_AGE}},
+		{"Age disabled", 50, FlushPolicy{MaxAge: -1}, FlushPolicy{MaxRecords: 50, MaxAge: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := ZMSConf{BufferSize: tt.buffer, Flush: FlushConf{FlushPolicy: tt.input}}
+			conf.setFlush()
+			require.Equal(t, tt.expected, conf.Flush.FlushPolicy)
+		})
+	}
+}
+
+func TestFlushConf_PolicyFor(t *testing.T) {
+	conf := FlushConf{
+		FlushPolicy: FlushPolicy{MaxRecords: 100, MaxAge: 30 * time.Second, MaxBytes: 4096},
+		Exports: map[string]FlushPolicy{
+			"events":  {MaxRecords: 1},
+			"history": {MaxAge: -1},
+		},
+	}
+
+	require.Equal(t, FlushPolicy{MaxRecords: 1, MaxAge: 30 * time.Second, MaxBytes: 4096}, conf.PolicyFor("events"))
+	require.Equal(t, FlushPolicy{MaxRecords: 100, MaxAge: 0, MaxBytes: 4096}, conf.PolicyFor("history"))
+	require.Equal(t, conf.FlushPolicy, conf.PolicyFor("trends"))
+}
+
+func TestFlushConf_Unmarshal(t *testing.T) {
+	raw := `
+flush:
+  max_records: 500
+  max_age: 10s
+  exports:
+    events:
+      max_records: 1
+`
+	conf := ZMSConf{}
+	require.NoError(t, yaml.Unmarshal([]byte(raw), &conf))
+	require.Equal(t, 500, conf.Flush.MaxRecords)
+	require.Equal(t, 10*time.Second, conf.Flush.MaxAge)
+	require.Equal(t, 1, conf.Flush.Exports["events"].MaxRecords)
+}
+
+func TestSetDelivery(t *testing.T) {
+	conf := ZMSConf{
+		DataDir: "/var/lib/zms",
+		Targets: []Target{
+			{UniqueName: "default"},
+			{UniqueName: "custom", Delivery: DeliveryConf{QueueDepth: 4, Workers: 2, Overflow: OVERFLOW_SPILL, Timeout: time.Second}},
+			{UniqueName: "invalid", Delivery: DeliveryConf{Overflow: "FNORD"}},
+		},
+	}
+	conf.setDelivery()
+
+	require.Equal(t, DeliveryConf{
+		QueueDepth: DEFAULT_QUEUE_DEPTH,
+		Workers:    DEFAULT_WORKERS,
+		Overflow:   OVERFLOW_BLOCK,
+		Timeout:    DEFAULT_DELIVERY_TIMEOUT,
+		SpillDir:   "/var/lib/zms/spill/default",
+	}, conf.Targets[0].Delivery)
+	require.Equal(t, DeliveryConf{
+		QueueDepth: 4,
+		Workers:    2,
+		Overflow:   OVERFLOW_SPILL,
+		Timeout:    time.Second,
+		SpillDir:   "/var/lib/zms/spill/custom",
+	}, conf.Targets[1].Delivery)
+	require.Equal(t, OVERFLOW_BLOCK, conf.Targets[2].Delivery.Overflow)
+}
+
+func TestSetSampling(t *testing.T) {
+	conf := ZMSConf{Targets: []Target{{
+		UniqueName: "longterm",
+		Sampling: []SamplingRule{
+			{Interval: time.Minute},
+			{Name: "cap", MaxPerSecond: 100, Filter: filter.FilterConfig{Accepted: []string{"env:prod*"}}},
+		},
+	}}}
+	conf.setSampling()
+	rules := conf.Targets[0].Sampling
+	require.Equal(t, "0", rules[0].Name)
+	require.Equal(t, SAMPLE_FIRST, rules[0].Keep)
+	require.False(t, rules[0].Holds())
+	require.Equal(t, rules, conf.Targets[0].Delivery.Sampling)
+
+	invalid := map[string]SamplingRule{
+		"nothing":  {Name: "a"},
+		"negative": {Interval: -time.Second},
+		"keep":     {Interval: time.Minute, Keep: "median"},
+		"cap":      {MaxPerSecond: -1},
+		"filter":   {Interval: time.Minute, Filter: filter.FilterConfig{Accepted: []string{"/env"}}},
+	}
+	for name, rule := range invalid {
+		conf := ZMSConf{Targets: []Target{{UniqueName: "a", Sampling: []SamplingRule{rule}}}}
+		require.Panics(t, conf.setSampling, name)
+	}
+
+	duplicate := ZMSConf{Targets: []Target{{UniqueName: "a", Sampling: []SamplingRule{
+		{Name: "a", Interval: time.Minute},
+		{Name: "a", MaxPerSecond: 1},
+	}}}}
+	require.Panics(t, duplicate.setSampling)
+}
+
+func TestSetCheckpointInterval(t *testing.T) {
+	conf := ZMSConf{}
+	conf.setCheckpointInterval()
+	require.Equal(t, DEFAULT_CHECKPOINT_INTERVAL, conf.Checkpoint)
+
+	conf = ZMSConf{Checkpoint: time.Minute}
+	conf.setCheckpointInterval()
+	require.Equal(t, time.Minute, conf.Checkpoint)
+}
+
+func TestSetShutdownTimeout(t *testing.T) {
+	conf := ZMSConf{}
+	conf.setShutdownTimeout()
+	require.Equal(t, DEFAULT_SHUTDOWN_TIMEOUT, conf.Shutdown)
+
+	conf = ZMSConf{Shutdown: time.Minute}
+	conf.setShutdownTimeout()
+	require.Equal(t, time.Minute, conf.Shutdown)
+}
+
+func TestSetHTTPAuth(t *testing.T) {
+	hash := "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+
+	conf := ZMSConf{Http: HTTPConf{Auth: HTTPAuthConf{
+		BearerTokens:    []string{"token"},
+		BasicUsers:      []BasicUser{{Username: "zabbix", PasswordHash: hash}},
+		ClientCertNames: []string{"zabbix.example.com"},
+	}}}
+	conf.setHTTPAuth()
+	require.True(t, conf.Http.Auth.ClientCert)
+	require.True(t, conf.Http.Auth.Enabled())
+	require.False(t, HTTPAuthConf{}.Enabled())
+
+	bad := ZMSConf{Http: HTTPConf{Auth: HTTPAuthConf{BasicUsers: []BasicUser{{Username: "zabbix", PasswordHash: "plain"}}}}}
+	require.Panics(t, bad.setHTTPAuth)
+
+	empty := ZMSConf{Http: HTTPConf{Auth: HTTPAuthConf{BearerTokens: []string{""}}}}
+	require.Panics(t, empty.setHTTPAuth)
+}
+
+func TestSetHTTPLimits(t *testing.T) {
+	conf := ZMSConf{}
+	conf.setHTTPLimits()
+	require.Equal(t, HTTPLimits{
+		MaxBodySize:           DEFAULT_MAX_BODY_SIZE,
+		MaxConcurrentRequests: DEFAULT_MAX_CONCURRENT_REQUESTS,
+		EnqueueTimeout:        DEFAULT_ENQUEUE_TIMEOUT,
+		RetryAfter:            DEFAULT_RETRY_AFTER,
+	}, conf.Http.Limits)
+
+	conf = ZMSConf{Http: HTTPConf{Limits: HTTPLimits{MaxBodySize: -1, MaxConcurrentRequests: -1, EnqueueTimeout: -1, RetryAfter: -1}}}
+	conf.setHTTPLimits()
+	require.Equal(t, HTTPLimits{RetryAfter: DEFAULT_RETRY_AFTER}, conf.Http.Limits)
+}
+
+func TestSetHA(t *testing.T) {
+	conf := ZMSConf{}
+	conf.setHA()
+	require.Equal(t, HAConf{Detector: HA_RUNTIME_CONTROL, Timeout: DEFAULT_HA_TIMEOUT, CheckInterval: DEFAULT_HA_CHECK_INTERVAL}, conf.HA)
+
+	conf = ZMSConf{HA: HAConf{Detector: HA_DATABASE, Database: HADatabaseConf{DSN: "postgres://zabbix@db/zabbix"}}}
+	conf.setHA()
+	require.Equal(t, DEFAULT_HA_DB_DRIVER, conf.HA.Database.Driver)
+
+	unknown := ZMSConf{HA: HAConf{Detector: "magic"}}
+	require.Panics(t, unknown.setHA)
+
+	noDSN := ZMSConf{HA: HAConf{Detector: HA_DATABASE}}
+	require.Panics(t, noDSN.setHA)
+
+	badDriver := ZMSConf{HA: HAConf{Detector: HA_DATABASE, Database: HADatabaseConf{Driver: "oracle", DSN: "dsn"}}}
+	require.Panics(t, badDriver.setHA)
+
+	mysql := ZMSConf{HA: HAConf{Detector: HA_DATABASE, Database: HADatabaseConf{Driver: "mysql", DSN: "zabbix@tcp(db)/zabbix"}}}
+	require.NotPanics(t, mysql.setHA)
+	require.Equal(t, "mysql", mysql.HA.Database.Driver)
+}
+
+func TestSetDeadLetter(t *testing.T) {
+	conf := ZMSConf{DataDir: "/var/lib/zms"}
+	conf.setDeadLetter()
+	require.Equal(t, DeadLetterConf{
+		MaxFileSize: DEFAULT_DEAD_LETTER_FILE_SIZE,
+		MaxSize:     DEFAULT_DEAD_LETTER_SIZE,
+		Dir:         "/var/lib/zms/deadletter",
+	}, conf.DeadLetter)
+
+	conf = ZMSConf{DeadLetter: DeadLetterConf{MaxFileSize: 1 << 20, MaxSize: 1 << 10}}
+	conf.setDeadLetter()
+	require.Equal(t, int64(1<<20), conf.DeadLetter.MaxSize)
+}
+
+func TestDedupConf_PolicyFor(t *testing.T) {
+	conf := ZMSConf{DataDir: "/var/lib/zms"}
+	conf.setDedup()
+	require.Equal(t, "/var/lib/zms/dedup", conf.Dedup.Dir)
+	_, ok := conf.Dedup.PolicyFor("history")
+	require.False(t, ok, "disabled unless enabled")
+
+	conf = ZMSConf{Dedup: DedupConf{
+		Enabled: true,
+		Exports: map[string]DedupPolicy{
+			"events": {Window: 24 * time.Hour},
+			"trends": {Window: -1},
+		},
+	}}
+	conf.setDedup()
+	policy, ok := conf.Dedup.PolicyFor("history")
+	require.True(t, ok)
+	require.Equal(t, DedupPolicy{Window: DEFAULT_DEDUP_WINDOW, MaxEntries: DEFAULT_DEDUP_MAX_ENTRIES}, policy)
+	policy, ok = conf.Dedup.PolicyFor("events")
+	require.True(t, ok)
+	require.Equal(t, DedupPolicy{Window: 24 * time.Hour, MaxEntries: DEFAULT_DEDUP_MAX_ENTRIES}, policy)
+	_, ok = conf.Dedup.PolicyFor("trends")
+	require.False(t, ok)
+}
+
+func TestSetDatabase(t *testing.T) {
+	// not validated outside of database mode
+	conf := ZMSConf{Mode: FILE_MODE}
+	require.NotPanics(t, conf.setDatabase)
+	require.Equal(t, DatabaseConf{}, conf.Database)
+
+	conf = ZMSConf{Mode: DB_MODE, Database: DatabaseConf{DSN: "postgres://zabbix@db/zabbix", Delay: -1}}
+	conf.setDatabase()
+	require.Equal(t, DatabaseConf{
+		Driver:       DEFAULT_DB_DRIVER,
+		DSN:          "postgres://zabbix@db/zabbix",
+		PollInterval: DEFAULT_DB_POLL_INTERVAL,
+		Window:       DEFAULT_DB_WINDOW,
+		BatchSize:    DEFAULT_DB_BATCH_SIZE,
+		Exports:      []string{"history", "trends", "events"},
+	}, conf.Database)
+
+	noDSN := ZMSConf{Mode: DB_MODE}
+	require.Panics(t, noDSN.setDatabase)
+
+	badDriver := ZMSConf{Mode: DB_MODE, Database: DatabaseConf{Driver: "oracle", DSN: "dsn"}}
+	require.Panics(t, badDriver.setDatabase)
+
+	mysql := ZMSConf{Mode: DB_MODE, Database: DatabaseConf{Driver: "mysql", DSN: "zabbix@tcp(db)/zabbix"}}
+	require.NotPanics(t, mysql.setDatabase)
+	require.Equal(t, "mysql", mysql.Database.Driver)
+
+	badExport := ZMSConf{Mode: DB_MODE, Database: DatabaseConf{DSN: "dsn", Exports: []string{"problems"}}}
+	require.Panics(t, badExport.setDatabase)
+}
+
+func TestSetTargets(t *testing.T) {
+	conf := ZMSConf{Targets: []Target{{UniqueName: "a"}, {UniqueName: "b"}}}
+	require.NotPanics(t, conf.setTargets)
+
+	duplicate := ZMSConf{Targets: []Target{{UniqueName: "a"}, {UniqueName: "a"}}}
+	require.Panics(t, duplicate.setTargets)
+
+	unnamed := ZMSConf{Targets: []Target{{PluginBinaryName: "print"}, {UniqueName: "a"}, {PluginBinaryName: "print"}}}
+	require.NotPanics(t, unnamed.setTargets)
+	require.Equal(t, "print_0", unnamed.Targets[0].UniqueName)
+	require.Equal(t, "print_2", unnamed.Targets[2].UniqueName)
+
+	clash := ZMSConf{Targets: []Target{{UniqueName: "print_1"}, {PluginBinaryName: "print"}}}
+	require.PanicsWithValue(t, "Invalid target config! Reason: duplicate target name print_1", clash.setTargets)
+}
+
+func TestSetFilters(t *testing.T) {
+	conf := ZMSConf{
+		Filter:  filter.FilterConfig{Accepted: []string{"env:prod*"}},
+		Targets: []Target{{UniqueName: "a", Filter: filter.FilterConfig{Rejected: []string{"env:/dev-[0-9]+/"}}}},
+	}
+	require.NotPanics(t, conf.setFilters)
+
+	global := ZMSConf{Filter: filter.FilterConfig{Accepted: []string{"env:/prod(/"}}}
+	require.Panics(t, global.setFilters)
+
+	target := ZMSConf{Targets: []Target{{UniqueName: "a", Filter: filter.FilterConfig{Accepted: []string{"/env"}}}}}
+	require.Panics(t, target.setFilters)
+
+	expression := ZMSConf{Filter: filter.FilterConfig{Type: filter.EXPRESSION_FILTER_TYPE, Expression: `severity >= 4 &&`}}
+	require.Panics(t, expression.setFilters)
+	expression.Filter.Expression = `severity >= 4 && "Linux" in groups`
+	require.NotPanics(t, expression.setFilters)
+
+	var chain ZMSConf
+	require.NoError(t, yaml.Unmarshal([]byte(`
+filter:
+  mode: or
+  filters:
+  - type: group
+    accepted: [Linux]
+  - type: expression
+    expression: severity >= 4
+`), &chain))
+	require.Len(t, chain.Filter.Filters, 2)
+	require.Equal(t, filter.GROUP_FILTER_TYPE, chain.Filter.Filters[0].Type)
+	require.NotPanics(t, chain.setFilters)
+	chain.Filter.Filters[1].Expression = "severity >="
+	require.Panics(t, chain.setFilters)
+}
+
+func TestLoadZMSConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "zmsd.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("mode: http\ndata_dir: "+dir+"\ntargets:\n- name: print\n  type: print\n"), 0o600))
+	conf, err := LoadZMSConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, HTTP_MODE, conf.Mode)
+	require.Len(t, conf.Targets, 1)
+
+	require.NoError(t, os.WriteFile(path, []byte("targets:\n- name: print\n- name: print\n"), 0o600))
+	_, err = LoadZMSConfig(path)
+	require.ErrorContains(t, err, "duplicate target name")
+
+	_, err = LoadZMSConfig(filepath.Join(dir, "missing.yaml"))
+	require.ErrorContains(t, err, "Cannot read ZMS config file")
+}
+
+func TestTarget_ForExport(t *testing.T) {
+	target := Target{UniqueName: "psql", Source: []string{"history", "events"}}
+
+	history := target.ForExport("history")
+	events := target.ForExport("events")
+	require.Equal(t, []string{"history"}, history.Source)
+	require.Equal(t, []string{"history", "events"}, target.Source)
+	// observers of every export type get a buffer of their own
+	require.Equal(t, "/var/lib/zms/buffer/psql/history", history.offlineBufferPath("/var/lib/zms"))
+	require.Equal(t, "/var/lib/zms/buffer/psql/events", events.offlineBufferPath("/var/lib/zms"))
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"slices"
+	"time"
+
+	"zms.szuro.net/pkg/zbx"
+)
+
+const (
+	DEFAULT_DB_DRIVER        = "postgres"
+	DEFAULT_DB_POLL_INTERVAL = 10 * time.Second
+	DEFAULT_DB_DELAY         = 10 * time.Second
+	DEFAULT_DB_WINDOW        = time.Minute
+	DEFAULT_DB_BATCH_SIZE    = 1000
+)
+
+// DatabaseConf configures polling the Zabbix database in database mode,
+// for servers that cannot write export files.
+type DatabaseConf struct {
+	Driver string `yaml:"driver"` // postgres or mysql
+	DSN    string `yaml:"dsn"`
+	// PollInterval is how often new rows are read once ZMS caught up.
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// Delay leaves rows newer than it for the next poll, so values
+	// committed late by history syncers are not skipped.
+	Delay time.Duration `yaml:"delay"`
+	// Window is the clock range of history read by a single query.
+	Window time.Duration `yaml:"window"`
+	// BatchSize is the number of events read by a single query.
+	BatchSize int `yaml:"batch_size"`
+	// Backfill is how far back reading starts when there is no saved position.
+	Backfill time.Duration `yaml:"backfill"`
+	// Exports lists polled export types, all by default.
+	Exports []string `yaml:"exports"`
+}
+
+func (zc *ZMSConf) setDatabase() {
+	if zc.Mode != DB_MODE {
+		return
+	}
+	db := &zc.Database
+	if db.Driver == "" {
+		db.Driver = DEFAULT_DB_DRIVER
+	}
+	if !slices.Contains([]string{"postgres", "mysql"}, db.Driver) {
+		panic("Invalid database config! Reason: unsupported database driver " + db.Driver)
+	}
+	if db.DSN == "" {
+		panic("Invalid database config! Reason: database mode requires dsn")
+	}
+	if db.PollInterval <= 0 {
+		db.PollInterval = DEFAULT_DB_POLL_INTERVAL
+	}
+	if db.Delay < 0 {
+		db.Delay = 0
+	} else if db.Delay == 0 {
+		db.Delay = DEFAULT_DB_DELAY
+	}
+	if db.Window < time.Second {
+		db.Window = DEFAULT_DB_WINDOW
+	}
+	if db.BatchSize <= 0 {
+		db.BatchSize = DEFAULT_DB_BATCH_SIZE
+	}
+	if db.Backfill < 0 {
+		db.Backfill = 0
+	}
+	if len(db.Exports) == 0 {
+		db.Exports = []string{zbx.HISTORY, zbx.TREND, zbx.EVENT}
+	}
+	for _, export := range db.Exports {
+		if !slices.Contains([]string{zbx.HISTORY, zbx.TREND, zbx.EVENT}, export) {
+			panic("Invalid database config! Reason: unknown export " + export)
+		}
+	}
+}
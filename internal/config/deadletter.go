@@ -0,0 +1,34 @@
+package config
+
+import "path"
+
+const (
+	DEFAULT_DEAD_LETTER_FILE_SIZE = 8 << 20
+	DEFAULT_DEAD_LETTER_SIZE      = 64 << 20
+)
+
+// DeadLetterConf configures keeping lines of export files that could not be parsed.
+// Lines are stored in gzip compressed NDJSON files under DataDir.
+type DeadLetterConf struct {
+	Disabled bool `yaml:"disabled"`
+	// MaxFileSize is the size of a compressed file in bytes before a new one is started.
+	MaxFileSize int64 `yaml:"max_file_size"`
+	// MaxSize limits the size of all files in bytes, the oldest files are removed above it.
+	MaxSize int64 `yaml:"max_size"`
+	// Dir is where files are stored.
+	Dir string `yaml:"-"`
+}
+
+func (zc *ZMSConf) setDeadLetter() {
+	dl := &zc.DeadLetter
+	if dl.MaxFileSize <= 0 {
+		dl.MaxFileSize = DEFAULT_DEAD_LETTER_FILE_SIZE
+	}
+	if dl.MaxSize <= 0 {
+		dl.MaxSize = DEFAULT_DEAD_LETTER_SIZE
+	}
+	if dl.MaxSize < dl.MaxFileSize {
+		dl.MaxSize = dl.MaxFileSize
+	}
+	dl.Dir = path.Join(zc.DataDir, "deadletter")
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"path"
+	"time"
+)
+
+const (
+	DEFAULT_DEDUP_WINDOW      = 10 * time.Minute
+	DEFAULT_DEDUP_MAX_ENTRIES = 1000000
+)
+
+// DedupPolicy limits how long and how many hashes of values are remembered for an export type.
+type DedupPolicy struct {
+	// Window is how long a hash is remembered after the value was seen.
+	// Negative value disables deduplication of the export type.
+	Window time.Duration `yaml:"window"`
+	// MaxEntries limits remembered hashes, the oldest are forgotten first.
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// DedupConf configures dropping values already seen, e.g. read again after an HA failover.
+// Values are told apart by Export.Hash.
+type DedupConf struct {
+	Enabled     bool `yaml:"enabled"`
+	DedupPolicy `yaml:",inline"`
+	// Persist keeps hashes of delivered values in BadgerDB under DataDir, so they survive restarts.
+	Persist bool                   `yaml:"persist"`
+	Exports map[string]DedupPolicy `yaml:"exports"`
+	// Dir is where persisted hashes are stored.
+	Dir string `yaml:"-"`
+}
+
+// PolicyFor returns the policy for the given export type, ok is false if
+// deduplication is disabled for it. Limits not set for the export type are
+// inherited from the global policy.
+func (dc DedupConf) PolicyFor(export string) (policy DedupPolicy, ok bool) {
+	if !dc.Enabled {
+		return policy, false
+	}
+	policy = dc.DedupPolicy
+	if override, ok := dc.Exports[export]; ok {
+		if override.Window != 0 {
+			policy.Window = override.Window
+		}
+		if override.MaxEntries > 0 {
+			policy.MaxEntries = override.MaxEntries
+		}
+	}
+	return policy, policy.Window > 0
+}
+
+func (zc *ZMSConf) setDedup() {
+	dc := &zc.Dedup
+	if dc.Window == 0 {
+		dc.Window = DEFAULT_DEDUP_WINDOW
+	}
+	if dc.MaxEntries <= 0 {
+		dc.MaxEntries = DEFAULT_DEDUP_MAX_ENTRIES
+	}
+	dc.Dir = path.Join(zc.DataDir, "dedup")
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"log/slog"
+	"path"
+	"time"
+
+	"zms.szuro.net/internal/logger"
+)
+
+// Policies applied when a target delivery queue is full
+const (
+	OVERFLOW_BLOCK       = "block"
+	OVERFLOW_DROP_OLDEST = "drop_oldest"
+	OVERFLOW_SPILL       = "spill"
+)
+
+const (
+	DEFAULT_QUEUE_DEPTH      = 16
+	DEFAULT_WORKERS          = 1
+	DEFAULT_DELIVERY_TIMEOUT = 30 * time.Second
+)
+
+// DeliveryConf configures how batches are handed over to a single target.
+// Each target gets its own bounded queue served by Workers goroutines.
+// With a single worker batches are delivered strictly in order.
+type DeliveryConf struct {
+	QueueDepth int    `yaml:"queue_depth"`
+	Workers    int    `yaml:"workers"`
+	Overflow   string `yaml:"overflow"`
+	// Timeout limits every call to the plugin of the target.
+	Timeout time.Duration `yaml:"timeout"`
+	// SpillDir is where batches are stored with the spill overflow policy.
+	SpillDir string `yaml:"-"`
+	// Sampling are rules of the target applied before batches are queued.
+	Sampling []SamplingRule `yaml:"-"`
+}
+
+func (dc *DeliveryConf) setDefaults(dataDir, targetName string) {
+	if dc.QueueDepth <= 0 {
+		dc.QueueDepth = DEFAULT_QUEUE_DEPTH
+	}
+	if dc.Workers <= 0 {
+		dc.Workers = DEFAULT_WORKERS
+	}
+	if dc.Timeout <= 0 {
+		dc.Timeout = DEFAULT_DELIVERY_TIMEOUT
+	}
+	switch dc.Overflow {
+	case OVERFLOW_BLOCK, OVERFLOW_DROP_OLDEST, OVERFLOW_SPILL:
+	case "":
+		dc.Overflow = OVERFLOW_BLOCK
+	default:
+		logger.Warn("Unknown overflow policy, using default",
+			slog.String("target", targetName),
+			slog.String("overflow", dc.Overflow),
+			slog.String("default", OVERFLOW_BLOCK))
+		dc.Overflow = OVERFLOW_BLOCK
+	}
+	dc.SpillDir = path.Join(dataDir, "spill", targetName)
+}
@@ -0,0 +1,41 @@
+package config
+
+import "time"
+
+const DEFAULT_FLUSH_AGE = 30 * time.Second
+
+// FlushPolicy decides when buffered values are sent to targets.
+// A flush happens on whichever limit is reached first. Zero disables a limit,
+// except for MaxAge which must be negative to be disabled.
+type FlushPolicy struct {
+	MaxRecords int           `yaml:"max_records"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	MaxBytes   int           `yaml:"max_bytes"`
+}
+
+// FlushConf holds the global flush policy and optional per export type overrides.
+type FlushConf struct {
+	FlushPolicy `yaml:",inline"`
+	Exports     map[string]FlushPolicy `yaml:"exports"`
+}
+
+// PolicyFor returns the flush policy for the given export type.
+// Limits not set for the export type are inherited from the global policy.
+func (fc FlushConf) PolicyFor(export string) FlushPolicy {
+	policy := fc.FlushPolicy
+	if override, ok := fc.Exports[export]; ok {
+		if override.MaxRecords > 0 {
+			policy.MaxRecords = override.MaxRecords
+		}
+		if override.MaxAge != 0 {
+			policy.MaxAge = override.MaxAge
+		}
+		if override.MaxBytes > 0 {
+			policy.MaxBytes = override.MaxBytes
+		}
+	}
+	if policy.MaxAge < 0 {
+		policy.MaxAge = 0
+	}
+	return policy
+}
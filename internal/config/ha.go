@@ -0,0 +1,63 @@
+package config
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	HA_RUNTIME_CONTROL = "runtime_control"
+	HA_DATABASE        = "database"
+	HA_STATIC          = "static"
+
+	DEFAULT_HA_TIMEOUT        = 10 * time.Second
+	DEFAULT_HA_CHECK_INTERVAL = 10 * time.Second
+	DEFAULT_HA_DB_DRIVER      = "postgres"
+)
+
+// HAConf selects how ZMS learns whether the Zabbix server node it runs on is active.
+type HAConf struct {
+	// Detector is one of runtime_control (zabbix_server -R ha_status),
+	// database (ha_node table) or static (always active).
+	Detector string         `yaml:"detector"`
+	Database HADatabaseConf `yaml:"database"`
+	// Timeout limits a single status check.
+	Timeout time.Duration `yaml:"timeout"`
+	// CheckInterval is how often the status is checked while running.
+	CheckInterval time.Duration `yaml:"check_interval"`
+}
+
+// HADatabaseConf is the connection to the Zabbix database used by the database detector.
+type HADatabaseConf struct {
+	Driver string `yaml:"driver"` // postgres or mysql
+	DSN    string `yaml:"dsn"`
+}
+
+func (zc *ZMSConf) setHA() {
+	ha := &zc.HA
+	if ha.Detector == "" {
+		ha.Detector = HA_RUNTIME_CONTROL
+	}
+	if !slices.Contains([]string{HA_RUNTIME_CONTROL, HA_DATABASE, HA_STATIC}, ha.Detector) {
+		panic("Invalid HA config! Reason: unknown detector " + ha.Detector)
+	}
+	if ha.Timeout <= 0 {
+		ha.Timeout = DEFAULT_HA_TIMEOUT
+	}
+	if ha.CheckInterval <= 0 {
+		ha.CheckInterval = DEFAULT_HA_CHECK_INTERVAL
+	}
+
+	if ha.Detector != HA_DATABASE {
+		return
+	}
+	if ha.Database.Driver == "" {
+		ha.Database.Driver = DEFAULT_HA_DB_DRIVER
+	}
+	if !slices.Contains([]string{"postgres", "mysql"}, ha.Database.Driver) {
+		panic("Invalid HA config! Reason: unsupported database driver " + ha.Database.Driver)
+	}
+	if ha.Database.DSN == "" {
+		panic("Invalid HA config! Reason: database detector requires dsn")
+	}
+}
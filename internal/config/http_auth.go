@@ -0,0 +1,57 @@
+package config
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HTTPAuthConf configures authentication of HTTP ingestion endpoints.
+// A request is accepted if it passes any of the configured methods.
+// No methods configured means authentication is disabled.
+type HTTPAuthConf struct {
+	// BearerTokens are static tokens accepted in the Authorization header,
+	// as sent by Zabbix connectors.
+	BearerTokens []string `yaml:"bearer_tokens"`
+	// BasicUsers are HTTP basic auth users with bcrypt hashed passwords.
+	BasicUsers []BasicUser `yaml:"basic_users"`
+	// ClientCert accepts requests with a verified TLS client certificate.
+	ClientCert bool `yaml:"client_cert"`
+	// ClientCertNames limits accepted client certificates to given common names or DNS SANs.
+	ClientCertNames []string `yaml:"client_cert_names"`
+	// ProtectMetrics requires authentication for /metrics as well.
+	ProtectMetrics bool `yaml:"protect_metrics"`
+}
+
+type BasicUser struct {
+	Username     string `yaml:"username"`
+	PasswordHash string `yaml:"password_hash"`
+}
+
+// Enabled returns true if at least one authentication method is configured.
+func (ac HTTPAuthConf) Enabled() bool {
+	return len(ac.BearerTokens) > 0 || len(ac.BasicUsers) > 0 || ac.ClientCert
+}
+
+// setHTTPAuth validates credentials. Misconfigured authentication must not
+// silently open the endpoints, so invalid entries are fatal.
+func (zc *ZMSConf) setHTTPAuth() {
+	auth := &zc.Http.Auth
+
+	for _, u := range auth.BasicUsers {
+		if u.Username == "" {
+			panic("Invalid HTTP auth config! Reason: basic auth user without username")
+		}
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			panic("Invalid HTTP auth config! Reason: bad bcrypt hash for user " + u.Username + ": " + err.Error())
+		}
+	}
+
+	for _, t := range auth.BearerTokens {
+		if t == "" {
+			panic("Invalid HTTP auth config! Reason: empty bearer token")
+		}
+	}
+
+	if len(auth.ClientCertNames) > 0 {
+		auth.ClientCert = true
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import "time"
+
+const (
+	DEFAULT_MAX_BODY_SIZE           = 16 << 20 // 16 MiB
+	DEFAULT_MAX_CONCURRENT_REQUESTS = 64
+	DEFAULT_ENQUEUE_TIMEOUT         = 5 * time.Second
+	DEFAULT_RETRY_AFTER             = 10 * time.Second
+)
+
+// HTTPLimits protect HTTP input from overload. Requests over the limits are rejected
+// quickly instead of blocking until the client times out and resends the whole payload.
+type HTTPLimits struct {
+	// MaxBodySize limits size of request body in bytes, before and after decompression.
+	MaxBodySize int64 `yaml:"max_body_size"`
+	// MaxConcurrentRequests limits number of requests processed at once.
+	MaxConcurrentRequests int `yaml:"max_concurrent_requests"`
+	// EnqueueTimeout is how long a request may wait for space in the buffer.
+	EnqueueTimeout time.Duration `yaml:"enqueue_timeout"`
+	// RetryAfter is suggested to clients in the Retry-After header of rejected requests.
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+// setHTTPLimits applies defaults to unset limits. Negative values disable a limit,
+// negative enqueue_timeout rejects requests as soon as the buffer is full.
+func (zc *ZMSConf) setHTTPLimits() {
+	l := &zc.Http.Limits
+	switch {
+	case l.MaxBodySize == 0:
+		l.MaxBodySize = DEFAULT_MAX_BODY_SIZE
+	case l.MaxBodySize < 0:
+		l.MaxBodySize = 0
+	}
+	switch {
+	case l.MaxConcurrentRequests == 0:
+		l.MaxConcurrentRequests = DEFAULT_MAX_CONCURRENT_REQUESTS
+	case l.MaxConcurrentRequests < 0:
+		l.MaxConcurrentRequests = 0
+	}
+	switch {
+	case l.EnqueueTimeout == 0:
+		l.EnqueueTimeout = DEFAULT_ENQUEUE_TIMEOUT
+	case l.EnqueueTimeout < 0:
+		l.EnqueueTimeout = 0
+	}
+	if l.RetryAfter <= 0 {
+		l.RetryAfter = DEFAULT_RETRY_AFTER
+	}
+}
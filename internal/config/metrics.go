@@ -20,3 +20,72 @@ var (
 		},
 	})
 )
+
+// Metrics of observers are vectors, as an observer is created per export type of a target
+// and created again when the target is reloaded.
+var (
+	pluginInfo = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "zms_plugin_info",
+			Help: "Information about gRPC observer plugins",
+		},
+		[]string{"unique_name", "type", "plugin_name", "plugin_author", "plugin_version"},
+	)
+
+	shippingOperations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zms_shipping_operations_total",
+			Help: "Total number of shipping operations",
+		},
+		[]string{"target_name", "plugin_name", "export_type"},
+	)
+
+	shippingErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zms_shipping_errors_total",
+			Help: "Total number of shipping errors",
+		},
+		[]string{"target_name", "plugin_name", "export_type"},
+	)
+)
+
+var (
+	offlineBufferRecords = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "zms_offline_buffer_records",
+			Help: "Number of records waiting in the offline buffer of a target",
+		},
+		[]string{"target_name", "export_type"},
+	)
+
+	offlineBufferReplayed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zms_offline_buffer_replayed_total",
+			Help: "Number of records replayed from the offline buffer of a target",
+		},
+		[]string{"target_name", "export_type"},
+	)
+
+	offlineBufferExpired = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zms_offline_buffer_expired_total",
+			Help: "Number of records dropped from the offline buffer of a target after offline_buffer_time",
+		},
+		[]string{"target_name", "export_type"},
+	)
+)
+
+var (
+	tlsReloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zms_tls_reloads_total",
+			Help: "Number of TLS certificate reloads of the HTTP listener",
+		},
+		[]string{"result"},
+	)
+
+	tlsCertExpiry = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zms_tls_certificate_expiry_timestamp_seconds",
+		Help: "Expiry time of the TLS certificate served by the HTTP listener",
+	})
+)
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"zms.szuro.net/internal/logger"
+	pluginPkg "zms.szuro.net/pkg/plugin"
+	"zms.szuro.net/pkg/zbx"
+)
+
+const (
+	MIN_REPLAY_BACKOFF = 1 * time.Second
+	MAX_REPLAY_BACKOFF = 5 * time.Minute
+)
+
+// offlineBuffer stores batches a target failed to accept and replays them
+// in FIFO order once the target works again. While records of an export type
+// are buffered, new batches of it are buffered behind them to keep the order.
+type offlineBuffer struct {
+	buffer     *pluginPkg.ZMSDefaultBuffer
+	batchSize  int
+	pending    map[string]*atomic.Int64 // buffered records per export type
+	backingOff atomic.Bool              // replay failed and waits for its retry
+	wake       chan struct{}
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// add accounts for count records of the export type stored in or removed from the buffer.
+func (ob *offlineBuffer) add(export string, count int64) {
+	if p, ok := ob.pending[export]; ok {
+		p.Add(count)
+	}
+}
+
+// total returns the number of buffered records of all export types.
+func (ob *offlineBuffer) total() (n int64) {
+	for _, p := range ob.pending {
+		n += p.Load()
+	}
+	return
+}
+
+// offlineBufferConf is where the offline buffer of an observer is stored and for how long.
+type offlineBufferConf struct {
+	path      string
+	ttl       int64 // in hours, buffering is disabled when 0
+	batchSize int
+}
+
+// initOfflineBuffer opens the buffer and starts the replay loop.
+func (o *GRPCObserver) initOfflineBuffer(conf offlineBufferConf) {
+	if conf.ttl <= 0 {
+		return
+	}
+	ob := &offlineBuffer{
+		buffer:    &pluginPkg.ZMSDefaultBuffer{},
+		batchSize: conf.batchSize,
+		pending:   make(map[string]*atomic.Int64, len(o.enabledExports)),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, export := range o.enabledExports {
+		ob.pending[export] = &atomic.Int64{}
+	}
+	ob.buffer.OnExpire = func(exportName string, count int) {
+		ob.add(exportName, -int64(count))
+		offlineBufferRecords.WithLabelValues(o.name, exportName).Sub(float64(count))
+		offlineBufferExpired.WithLabelValues(o.name, exportName).Add(float64(count))
+		logger.Warn("Offline buffer records expired",
+			slog.String("target", o.name),
+			slog.String("export", exportName),
+			slog.Int("count", count))
+	}
+	ob.buffer.InitBuffer(conf.path, conf.ttl)
+	if !ob.buffer.Enabled() {
+		logger.Error("Offline buffer disabled", slog.String("target", o.name))
+		return
+	}
+
+	for _, export := range o.enabledExports {
+		count, err := ob.buffer.Count(export)
+		if err != nil {
+			logger.Error("Failed to count offline buffer", slog.String("target", o.name), slog.Any("error", err))
+		}
+		ob.add(export, int64(count))
+		offlineBufferRecords.WithLabelValues(o.name, export).Set(float64(count))
+		offlineBufferReplayed.WithLabelValues(o.name, export).Add(0)
+		offlineBufferExpired.WithLabelValues(o.name, export).Add(0)
+	}
+
+	o.offline = ob
+	go o.replay()
+	if ob.total() > 0 {
+		logger.Info("Replaying offline buffer", slog.String("target", o.name), slog.Int64("records", ob.total()))
+		o.signalReplay()
+	}
+}
+
+// closeOfflineBuffer stops the replay loop and closes the buffer.
+func (o *GRPCObserver) closeOfflineBuffer() {
+	if o.offline == nil {
+		return
+	}
+	close(o.offline.stop)
+	<-o.offline.done
+	o.offline.buffer.Cleanup()
+	o.offline = nil
+}
+
+// signalReplay wakes up the replay loop if there is anything to replay.
+func (o *GRPCObserver) signalReplay() {
+	if o.offline == nil || o.offline.total() <= 0 {
+		return
+	}
+	select {
+	case o.offline.wake <- struct{}{}:
+	default:
+	}
+}
+
+// replaying reports whether records of the export type wait in the offline buffer,
+// so new batches of it must be buffered behind them instead of being sent.
+func (o *GRPCObserver) replaying(export string) bool {
+	if o.offline == nil {
+		return false
+	}
+	p, ok := o.offline.pending[export]
+	return ok && p.Load() > 0
+}
+
+func (o *GRPCObserver) bufferHistory(h []zbx.History) bool {
+	if o.offline == nil {
+		return false
+	}
+	return o.buffered(zbx.HISTORY, len(h), o.offline.buffer.BufferHistory(h))
+}
+
+func (o *GRPCObserver) bufferTrends(t []zbx.Trend) bool {
+	if o.offline == nil {
+		return false
+	}
+	return o.buffered(zbx.TREND, len(t), o.offline.buffer.BufferTrends(t))
+}
+
+func (o *GRPCObserver) bufferEvents(e []zbx.Event) bool {
+	if o.offline == nil {
+		return false
+	}
+	return o.buffered(zbx.EVENT, len(e), o.offline.buffer.BufferEvents(e))
+}
+
+// buffered accounts for a batch stored in the offline buffer and reports whether it was stored.
+// The replay loop is woken up, unless it is backing off after a failed replay.
+func (o *GRPCObserver) buffered(export string, count int, err error) bool {
+	if err != nil {
+		logger.Error("Failed to store batch in offline buffer",
+			slog.String("target", o.name),
+			slog.String("export", export),
+			slog.Any("error", err))
+		return false
+	}
+	o.offline.add(export, int64(count))
+	offlineBufferRecords.WithLabelValues(o.name, export).Add(float64(count))
+	if !o.offline.backingOff.Load() {
+		o.signalReplay()
+	}
+	return true
+}
+
+// replay sends buffered records back to the plugin.
+// After a failure it waits with exponential backoff, unless a live batch
+// of another export type goes through in the meantime.
+func (o *GRPCObserver) replay() {
+	ob := o.offline
+	defer close(ob.done)
+
+	backoff := MIN_REPLAY_BACKOFF
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-ob.stop:
+			return
+		case <-ob.wake:
+		case <-retry:
+		}
+		retry = nil
+		ob.backingOff.Store(false)
+
+		for ob.total() > 0 {
+			select {
+			case <-ob.stop:
+				return
+			default:
+			}
+			progressed, ok := o.replayOnce()
+			if !ok {
+				logger.Debug("Offline buffer replay failed, backing off",
+					slog.String("target", o.name),
+					slog.Duration("backoff", backoff))
+				retry = time.After(backoff)
+				ob.backingOff.Store(true)
+				backoff = min(backoff*2, MAX_REPLAY_BACKOFF)
+				break
+			}
+			backoff = MIN_REPLAY_BACKOFF
+			if !progressed {
+				break
+			}
+		}
+	}
+}
+
+// replayOnce sends a single batch of every export type.
+// Returns whether anything was sent and whether all sends succeeded.
+func (o *GRPCObserver) replayOnce() (progressed bool, ok bool) {
+	for _, export := range o.enabledExports {
+		var sent int
+		var err error
+		switch export {
+		case zbx.HISTORY:
+			sent, err = replayBatch(o.offline.buffer.FetchHistory, o.sendHistory, o.offline.buffer.DeleteHistory, o.offline.batchSize)
+		case zbx.TREND:
+			sent, err = replayBatch(o.offline.buffer.FetchTrends, o.sendTrends, o.offline.buffer.DeleteTrends, o.offline.batchSize)
+		case zbx.EVENT:
+			sent, err = replayBatch(o.offline.buffer.FetchEvents, o.sendEvents, o.offline.buffer.DeleteEvents, o.offline.batchSize)
+		}
+		if err != nil {
+			logger.Error("Failed to replay offline buffer",
+				slog.String("target", o.name),
+				slog.String("export", export),
+				slog.Any("error", err))
+			return progressed, false
+		}
+		if sent < 0 {
+			return progressed, false
+		}
+		if sent > 0 {
+			progressed = true
+			o.offline.add(export, -int64(sent))
+			offlineBufferRecords.WithLabelValues(o.name, export).Sub(float64(sent))
+			offlineBufferReplayed.WithLabelValues(o.name, export).Add(float64(sent))
+		}
+	}
+	return progressed, true
+}
+
+// replayBatch fetches a batch, sends it and removes it from the buffer on success.
+// Returns the number of records sent, or -1 if the target rejected them.
+func replayBatch[T zbx.Export](fetch func(int) ([]T, error), send func([]T) bool, remove func([]T) error, size int) (int, error) {
+	batch, err := fetch(size)
+	if err != nil || len(batch) == 0 {
+		return 0, err
+	}
+	if !send(batch) {
+		return -1, nil
+	}
+	return len(batch), remove(batch)
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"zms.szuro.net/pkg/filter"
+)
+
+// Values of an item kept per sampling interval
+const (
+	SAMPLE_FIRST = "first"
+	SAMPLE_LAST  = "last"
+	SAMPLE_MIN   = "min"
+	SAMPLE_MAX   = "max"
+)
+
+// SamplingRule thins out values delivered to a target. Interval keeps one history value
+// per item and interval, MaxPerSecond caps values per host and second.
+// Rules apply to values accepted by Filter, or to every value without it.
+type SamplingRule struct {
+	Name         string              `yaml:"name"`
+	Filter       filter.FilterConfig `yaml:"filter"`
+	Interval     time.Duration       `yaml:"interval"`
+	Keep         string              `yaml:"keep"`
+	MaxPerSecond int                 `yaml:"max_per_second"`
+}
+
+// Holds reports whether values are held back until the end of their interval.
+func (r SamplingRule) Holds() bool {
+	return r.Interval > 0 && r.Keep != SAMPLE_FIRST
+}
+
+func (r *SamplingRule) setDefaults(index int) error {
+	if r.Name == "" {
+		r.Name = strconv.Itoa(index)
+	}
+	if r.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	if r.MaxPerSecond < 0 {
+		return fmt.Errorf("max_per_second must not be negative")
+	}
+	if r.Interval == 0 && r.MaxPerSecond == 0 {
+		return fmt.Errorf("interval or max_per_second must be set")
+	}
+	switch r.Keep {
+	case SAMPLE_FIRST, SAMPLE_LAST, SAMPLE_MIN, SAMPLE_MAX:
+	case "":
+		r.Keep = SAMPLE_FIRST
+	default:
+		return fmt.Errorf("unknown keep %q", r.Keep)
+	}
+	return r.Filter.Validate()
+}
+
+// setSampling validates sampling rules of targets and passes them on to their delivery queues.
+func (zc *ZMSConf) setSampling() {
+	for i := range zc.Targets {
+		t := &zc.Targets[i]
+		names := make(map[string]bool, len(t.Sampling))
+		for j := range t.Sampling {
+			rule := &t.Sampling[j]
+			if err := rule.setDefaults(j); err != nil {
+				panic(fmt.Sprintf("Invalid sampling config! Reason: target %s rule %s: %s", t.UniqueName, rule.Name, err))
+			}
+			if names[rule.Name] {
+				panic(fmt.Sprintf("Invalid sampling config! Reason: target %s has duplicate rule %s", t.UniqueName, rule.Name))
+			}
+			names[rule.Name] = true
+		}
+		t.Delivery.Sampling = t.Sampling
+	}
+}
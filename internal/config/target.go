@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"path"
+	"strings"
 
 	"zms.szuro.net/internal/plugin"
 	"zms.szuro.net/pkg/filter"
@@ -15,6 +17,20 @@ type Target struct {
 	Filter            filter.FilterConfig `yaml:"filter"`
 	Source            []string
 	Options           map[string]string
+	Delivery          DeliveryConf   `yaml:"delivery"`
+	Sampling          []SamplingRule `yaml:"sampling"`
+}
+
+// ForExport returns the target as it is registered for a single export type.
+// An observer is created per export type, each with a plugin instance and offline buffer of its own.
+func (t Target) ForExport(export string) Target {
+	t.Source = []string{export}
+	return t
+}
+
+// offlineBufferPath returns the directory of the offline buffer of an observer of the target.
+func (t *Target) offlineBufferPath(dataDir string) string {
+	return path.Join(dataDir, "buffer", t.UniqueName, strings.Join(t.Source, "_"))
 }
 
 func (t *Target) ToObserver(config ZMSConf) (obs Observer, err error) {
@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"log/slog"
 	"slices"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"zms.szuro.net/internal/logger"
 	"zms.szuro.net/internal/plugin"
 	pluginPkg "zms.szuro.net/pkg/plugin"
@@ -38,6 +38,13 @@ type Observer interface {
 	SaveEvents(e []zbx.Event) bool
 }
 
+// Starter is implemented by observers opening resources only once they are registered,
+// as the observer they replace on reload holds them until it is cleaned up.
+type Starter interface {
+	// Start is called once, before the observer is sent any values.
+	Start()
+}
+
 // GRPCObserver wraps a gRPC plugin observer for use in ZMS.
 type GRPCObserver struct {
 	client     proto.ObserverServiceClient
@@ -47,6 +54,12 @@ type GRPCObserver struct {
 	// Plugins should use these counters to report success/failure statistics.
 	monitor        observerMetrics
 	enabledExports []string
+	// timeout limits every call to the plugin, so a hung plugin cannot block delivery forever.
+	timeout time.Duration
+	// offline keeps batches that could not be delivered until the target recovers.
+	// It is opened by Start with offlineConf.
+	offline     *offlineBuffer
+	offlineConf offlineBufferConf
 }
 
 // ToGRPCObserver creates a gRPC observer from the target configuration.
@@ -59,12 +72,7 @@ func (t *Target) ToGRPCObserver(config ZMSConf) (*GRPCObserver, error) {
 	}
 
 	// Prepare filter config
-	// var filters []*proto.Filter
-	filterConfig := &proto.Filter{
-		Type:     proto.FilterType(proto.FilterType_TAG),
-		Accepted: t.Filter.Accepted,
-		Rejected: t.Filter.Rejected,
-	}
+	filterConfig := pluginPkg.FilterConfigToProto(t.Filter)
 
 	// Convert export types
 	exports := make([]proto.ExportType, 0, len(t.Source))
@@ -81,25 +89,32 @@ func (t *Target) ToGRPCObserver(config ZMSConf) (*GRPCObserver, error) {
 		Filter:     filterConfig,
 	}
 
-	resp, err := client.Initialize(context.Background(), initReq)
+	obs := &GRPCObserver{
+		client:         client,
+		pluginName:     t.PluginBinaryName,
+		name:           t.UniqueName,
+		enabledExports: t.Source,
+		timeout:        t.Delivery.Timeout,
+	}
+	if obs.timeout <= 0 {
+		obs.timeout = DEFAULT_DELIVERY_TIMEOUT
+	}
+
+	ctx, cancel := obs.callContext()
+	defer cancel()
+	resp, err := client.Initialize(ctx, initReq)
 	if err != nil {
-		client.Cleanup(context.Background(), &proto.CleanupRequest{})
+		obs.cleanupPlugin()
 		return nil, fmt.Errorf("failed to initialize gRPC plugin observer %s: %w", t.PluginBinaryName, err)
 	}
 
 	if !resp.Success {
-		client.Cleanup(context.Background(), &proto.CleanupRequest{})
+		obs.cleanupPlugin()
 		return nil, fmt.Errorf("plugin initialization failed: %s", resp.Error)
 	}
 
-	obs := &GRPCObserver{
-		client:         client,
-		pluginName:     t.PluginBinaryName,
-		name:           t.UniqueName,
-		enabledExports: t.Source,
-	}
-
 	obs.initObserverMetrics()
+	obs.offlineConf = offlineBufferConf{path: t.offlineBufferPath(config.DataDir), ttl: t.OfflineBufferTime, batchSize: config.BufferSize}
 	if resp.PluginInfo != nil {
 		obs.initPluginInfo(resp.PluginInfo.Author, resp.PluginInfo.Name, resp.PluginInfo.Version)
 	}
@@ -121,20 +136,35 @@ func (o *GRPCObserver) GetPluginName() string {
 	return o.pluginName
 }
 
+// Start opens the offline buffer and replays batches left in it.
+func (o *GRPCObserver) Start() {
+	o.initOfflineBuffer(o.offlineConf)
+}
+
 // Cleanup releases resources by calling the gRPC plugin's Cleanup method.
 func (o *GRPCObserver) Cleanup() {
 	if o != nil {
-		ctx := context.Background()
-		req := &proto.CleanupRequest{}
-		_, err := o.client.Cleanup(ctx, req)
-		if err != nil {
-			logger.Error("Failed to cleanup gRPC plugin",
-				slog.String("plugin", o.pluginName),
-				slog.Any("error", err))
-		}
+		o.closeOfflineBuffer()
+		o.cleanupPlugin()
 	}
 }
 
+func (o *GRPCObserver) cleanupPlugin() {
+	ctx, cancel := o.callContext()
+	defer cancel()
+	_, err := o.client.Cleanup(ctx, &proto.CleanupRequest{})
+	if err != nil {
+		logger.Error("Failed to cleanup gRPC plugin",
+			slog.String("plugin", o.pluginName),
+			slog.Any("error", err))
+	}
+}
+
+// callContext returns the context of a single call to the plugin.
+func (o *GRPCObserver) callContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), o.timeout)
+}
+
 // interfaceSliceToStringSlice converts []interface{} to []string.
 func interfaceSliceToStringSlice(slice []any) []string {
 	result := make([]string, 0, len(slice))
@@ -147,8 +177,22 @@ func interfaceSliceToStringSlice(slice []any) []string {
 	return result
 }
 
+// SaveHistory sends history data to the plugin.
+// Batches that cannot be delivered are kept in the offline buffer, if enabled,
+// and so are new batches while earlier ones wait there, to keep their order.
+// Returns true once the batch was sent or stored in the offline buffer.
 func (o *GRPCObserver) SaveHistory(h []zbx.History) bool {
-	ctx := context.Background()
+	if o.replaying(zbx.HISTORY) || !o.sendHistory(h) {
+		return o.bufferHistory(h)
+	}
+	o.signalReplay()
+	return true
+}
+
+// sendHistory processes history data by converting to proto format and calling the gRPC method.
+func (o *GRPCObserver) sendHistory(h []zbx.History) bool {
+	ctx, cancel := o.callContext()
+	defer cancel()
 
 	// Convert zbx.History to proto.History
 	protoHistory := make([]*proto.History, 0, len(h))
@@ -180,9 +224,22 @@ func (o *GRPCObserver) SaveHistory(h []zbx.History) bool {
 	return true
 }
 
-// SaveTrends processes trend data by converting to proto format and calling the gRPC method.
+// SaveTrends sends trend data to the plugin.
+// Batches that cannot be delivered are kept in the offline buffer, if enabled,
+// and so are new batches while earlier ones wait there, to keep their order.
+// Returns true once the batch was sent or stored in the offline buffer.
 func (o *GRPCObserver) SaveTrends(t []zbx.Trend) bool {
-	ctx := context.Background()
+	if o.replaying(zbx.TREND) || !o.sendTrends(t) {
+		return o.bufferTrends(t)
+	}
+	o.signalReplay()
+	return true
+}
+
+// sendTrends processes trend data by converting to proto format and calling the gRPC method.
+func (o *GRPCObserver) sendTrends(t []zbx.Trend) bool {
+	ctx, cancel := o.callContext()
+	defer cancel()
 
 	// Convert zbx.Trend to proto.Trend
 	protoTrends := make([]*proto.Trend, 0, len(t))
@@ -214,9 +271,22 @@ func (o *GRPCObserver) SaveTrends(t []zbx.Trend) bool {
 	return true
 }
 
-// SaveEvents processes event data by converting to proto format and calling the gRPC method.
+// SaveEvents sends event data to the plugin.
+// Batches that cannot be delivered are kept in the offline buffer, if enabled,
+// and so are new batches while earlier ones wait there, to keep their order.
+// Returns true once the batch was sent or stored in the offline buffer.
 func (o *GRPCObserver) SaveEvents(e []zbx.Event) bool {
-	ctx := context.Background()
+	if o.replaying(zbx.EVENT) || !o.sendEvents(e) {
+		return o.bufferEvents(e)
+	}
+	o.signalReplay()
+	return true
+}
+
+// sendEvents processes event data by converting to proto format and calling the gRPC method.
+func (o *GRPCObserver) sendEvents(e []zbx.Event) bool {
+	ctx, cancel := o.callContext()
+	defer cancel()
 
 	// Convert zbx.Event to proto.Event
 	protoEvents := make([]*proto.Event, 0, len(e))
@@ -272,57 +342,20 @@ type observerMetrics struct {
 }
 
 func (o *GRPCObserver) initPluginInfo(author, name, version string) {
-	promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "zms_plugin_info",
-		Help: "Information about gRPC observer plugins",
-		ConstLabels: prometheus.Labels{
-			"unique_name":    o.name,
-			"type":           o.pluginName,
-			"plugin_name":    name,
-			"plugin_author":  author,
-			"plugin_version": version,
-		},
-	}).Set(1)
+	pluginInfo.WithLabelValues(o.name, o.pluginName, name, author, version).Set(1)
 }
 
 func (o *GRPCObserver) initObserverMetrics() {
 	if slices.Contains(o.enabledExports, zbx.HISTORY) {
-		o.monitor.HistoryValuesSent = promauto.NewCounter(prometheus.CounterOpts{
-			Name:        "zms_shipping_operations_total",
-			Help:        "Total number of shipping operations",
-			ConstLabels: prometheus.Labels{"target_name": o.name, "plugin_name": o.pluginName, "export_type": zbx.HISTORY},
-		})
-
-		o.monitor.HistoryValuesFailed = promauto.NewCounter(prometheus.CounterOpts{
-			Name:        "zms_shipping_errors_total",
-			Help:        "Total number of shipping errors",
-			ConstLabels: prometheus.Labels{"target_name": o.name, "plugin_name": o.pluginName, "export_type": zbx.HISTORY},
-		})
+		o.monitor.HistoryValuesSent = shippingOperations.WithLabelValues(o.name, o.pluginName, zbx.HISTORY)
+		o.monitor.HistoryValuesFailed = shippingErrors.WithLabelValues(o.name, o.pluginName, zbx.HISTORY)
 	}
 	if slices.Contains(o.enabledExports, zbx.TREND) {
-		o.monitor.TrendsValuesSent = promauto.NewCounter(prometheus.CounterOpts{
-			Name:        "zms_shipping_operations_total",
-			Help:        "Total number of shipping operations",
-			ConstLabels: prometheus.Labels{"target_name": o.name, "plugin_name": o.pluginName, "export_type": zbx.TREND},
-		})
-
-		o.monitor.TrendsValuesFailed = promauto.NewCounter(prometheus.CounterOpts{
-			Name:        "zms_shipping_errors_total",
-			Help:        "Total number of shipping errors",
-			ConstLabels: prometheus.Labels{"target_name": o.name, "plugin_name": o.pluginName, "export_type": zbx.TREND},
-		})
+		o.monitor.TrendsValuesSent = shippingOperations.WithLabelValues(o.name, o.pluginName, zbx.TREND)
+		o.monitor.TrendsValuesFailed = shippingErrors.WithLabelValues(o.name, o.pluginName, zbx.TREND)
 	}
 	if slices.Contains(o.enabledExports, zbx.EVENT) {
-		o.monitor.EventsValuesSent = promauto.NewCounter(prometheus.CounterOpts{
-			Name:        "zms_shipping_operations_total",
-			Help:        "Total number of shipping operations",
-			ConstLabels: prometheus.Labels{"target_name": o.name, "plugin_name": o.pluginName, "export_type": zbx.EVENT},
-		})
-
-		o.monitor.EventsValuesFailed = promauto.NewCounter(prometheus.CounterOpts{
-			Name:        "zms_shipping_errors_total",
-			Help:        "Total number of shipping errors",
-			ConstLabels: prometheus.Labels{"target_name": o.name, "plugin_name": o.pluginName, "export_type": zbx.EVENT},
-		})
+		o.monitor.EventsValuesSent = shippingOperations.WithLabelValues(o.name, o.pluginName, zbx.EVENT)
+		o.monitor.EventsValuesFailed = shippingErrors.WithLabelValues(o.name, o.pluginName, zbx.EVENT)
 	}
 }
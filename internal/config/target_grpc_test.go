@@ -0,0 +1,107 @@
+package config
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"zms.szuro.net/pkg/proto"
+	"zms.szuro.net/pkg/zbx"
+)
+
+// hungClient is a plugin that never answers, until the call is cancelled.
+type hungClient struct {
+	proto.ObserverServiceClient
+}
+
+func (c hungClient) SaveHistory(ctx context.Context, in *proto.SaveHistoryRequest, opts ...grpc.CallOption) (*proto.SaveResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c hungClient) Cleanup(ctx context.Context, in *proto.CleanupRequest, opts ...grpc.CallOption) (*proto.CleanupResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGRPCObserver_Timeout(t *testing.T) {
+	obs := &GRPCObserver{client: hungClient{}, name: "hung", timeout: 20 * time.Millisecond}
+
+	saved := make(chan bool)
+	go func() {
+		saved <- obs.SaveHistory([]zbx.History{{ItemID: 1}})
+	}()
+	select {
+	case ok := <-saved:
+		require.False(t, ok, "a call that timed out failed")
+	case <-time.After(time.Second):
+		t.Fatal("call to a hung plugin did not time out")
+	}
+
+	cleaned := make(chan struct{})
+	go func() {
+		defer close(cleaned)
+		obs.Cleanup()
+	}()
+	select {
+	case <-cleaned:
+	case <-time.After(time.Second):
+		t.Fatal("cleanup of a hung plugin did not time out")
+	}
+}
+
+// recordingClient is a plugin recording history it accepted, failing while down is set.
+type recordingClient struct {
+	proto.ObserverServiceClient
+	down  atomic.Bool
+	mu    sync.Mutex
+	items []int64
+}
+
+func (c *recordingClient) SaveHistory(ctx context.Context, in *proto.SaveHistoryRequest, opts ...grpc.CallOption) (*proto.SaveResponse, error) {
+	if c.down.Load() {
+		return &proto.SaveResponse{Success: false, Error: "down"}, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, h := range in.History {
+		c.items = append(c.items, h.Itemid)
+	}
+	return &proto.SaveResponse{Success: true, RecordsProcessed: int64(len(in.History))}, nil
+}
+
+func (c *recordingClient) Cleanup(ctx context.Context, in *proto.CleanupRequest, opts ...grpc.CallOption) (*proto.CleanupResponse, error) {
+	return &proto.CleanupResponse{}, nil
+}
+
+func (c *recordingClient) accepted() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int64(nil), c.items...)
+}
+
+func TestGRPCObserver_OfflineBufferKeepsOrder(t *testing.T) {
+	client := &recordingClient{}
+	obs := &GRPCObserver{client: client, name: "ordered", timeout: time.Second, enabledExports: []string{zbx.HISTORY}}
+	obs.initObserverMetrics()
+	obs.offlineConf = offlineBufferConf{path: t.TempDir(), ttl: 1, batchSize: 10}
+	obs.Start()
+	defer obs.Cleanup()
+
+	client.down.Store(true)
+	require.True(t, obs.SaveHistory([]zbx.History{{ItemID: 1}}), "batch is stored in the offline buffer")
+
+	// the target recovered, but the buffered batch goes first
+	client.down.Store(false)
+	require.True(t, obs.SaveHistory([]zbx.History{{ItemID: 2}}))
+	require.Eventually(t, func() bool { return !obs.replaying(zbx.HISTORY) }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []int64{1, 2}, client.accepted())
+
+	// once the buffer is empty, batches are sent right away
+	require.True(t, obs.SaveHistory([]zbx.History{{ItemID: 3}}))
+	require.Equal(t, []int64{1, 2, 3}, client.accepted())
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"zms.szuro.net/internal/logger"
+)
+
+// How often certificate files are checked for changes. Checks happen lazily on TLS handshakes.
+const TLS_RELOAD_CHECK = 10 * time.Second
+
+const DEFAULT_TLS_MIN_VERSION = "1.2"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSEnabled returns true if the HTTP listener should serve TLS.
+func (hc HTTPConf) TLSEnabled() bool {
+	return hc.TLSCertFile != ""
+}
+
+func (zc *ZMSConf) setHTTPTLS() {
+	hc := &zc.Http
+
+	if hc.TLSMinVersion == "" {
+		hc.TLSMinVersion = DEFAULT_TLS_MIN_VERSION
+	}
+	if _, ok := tlsVersions[hc.TLSMinVersion]; !ok {
+		panic("Invalid HTTP TLS config! Reason: unknown tls_min_version " + hc.TLSMinVersion)
+	}
+
+	if (hc.TLSCertFile == "") != (hc.TLSKeyFile == "") {
+		panic("Invalid HTTP TLS config! Reason: tls_cert_file and tls_key_file must be set together")
+	}
+	if hc.TLSClientCAFile != "" && !hc.TLSEnabled() {
+		panic("Invalid HTTP TLS config! Reason: tls_client_ca_file requires tls_cert_file")
+	}
+	if hc.Auth.ClientCert && hc.TLSClientCAFile == "" {
+		panic("Invalid HTTP auth config! Reason: client_cert requires tls_client_ca_file")
+	}
+}
+
+// NewTLSConfig returns a server TLS config for the HTTP listener.
+// Certificate, key and client CA files are reloaded when they change on disk,
+// so rotated certificates are picked up without a restart.
+func (hc HTTPConf) NewTLSConfig() (*tls.Config, error) {
+	r, err := newCertReloader(hc)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:         r.minVersion,
+		GetConfigForClient: r.getConfigForClient,
+	}, nil
+}
+
+// certReloader keeps the current TLS config and rebuilds it when any of the files change.
+type certReloader struct {
+	certFile, keyFile, caFile string
+	minVersion                uint16
+
+	mu        sync.RWMutex
+	current   *tls.Config
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(hc HTTPConf) (*certReloader, error) {
+	r := &certReloader{
+		certFile:   hc.TLSCertFile,
+		keyFile:    hc.TLSKeyFile,
+		caFile:     hc.TLSClientCAFile,
+		minVersion: tlsVersions[hc.TLSMinVersion],
+	}
+	if r.minVersion == 0 {
+		r.minVersion = tlsVersions[DEFAULT_TLS_MIN_VERSION]
+	}
+	r.modTimes = r.stat()
+	r.lastCheck = time.Now()
+	return r, r.load()
+}
+
+func (r *certReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.maybeReload()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+func (r *certReloader) stat() []time.Time {
+	files := r.files()
+	times := make([]time.Time, len(files))
+	for i, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+	return times
+}
+
+func (r *certReloader) maybeReload() {
+	r.mu.Lock()
+	if time.Since(r.lastCheck) < TLS_RELOAD_CHECK {
+		r.mu.Unlock()
+		return
+	}
+	r.lastCheck = time.Now()
+	times := r.stat()
+	changed := false
+	for i := range times {
+		if !times[i].Equal(r.modTimes[i]) {
+			changed = true
+		}
+	}
+	// Remember the new times even if loading fails, a finished rotation changes them again
+	r.modTimes = times
+	r.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if err := r.load(); err != nil {
+		tlsReloads.WithLabelValues("failure").Inc()
+		logger.Error("Failed to reload TLS certificate, keeping the previous one",
+			slog.String("cert", r.certFile),
+			slog.Any("error", err))
+		return
+	}
+	tlsReloads.WithLabelValues("success").Inc()
+	logger.Info("Reloaded TLS certificate", slog.String("cert", r.certFile))
+}
+
+// load reads all files and replaces the current config.
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	conf := &tls.Config{
+		MinVersion:   r.minVersion,
+		Certificates: []tls.Certificate{cert},
+	}
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("no certificates found in " + r.caFile)
+		}
+		conf.ClientCAs = pool
+		// Requests without a certificate may still authenticate with a token or password
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if cert.Leaf != nil {
+		tlsCertExpiry.Set(float64(cert.Leaf.NotAfter.Unix()))
+	}
+
+	r.mu.Lock()
+	r.current = conf
+	r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self signed certificate with the given common name.
+func writeCert(t *testing.T, certFile, keyFile, cn string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+}
+
+func servedName(t *testing.T, conf *tls.Config) string {
+	t.Helper()
+	current, err := conf.GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.Len(t, current.Certificates, 1)
+	return current.Certificates[0].Leaf.Subject.CommonName
+}
+
+func TestNewTLSConfig_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "first")
+
+	hc := HTTPConf{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSMinVersion: "1.3"}
+	conf, err := hc.NewTLSConfig()
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), conf.MinVersion)
+
+	r, err := newCertReloader(hc)
+	require.NoError(t, err)
+	conf = &tls.Config{GetConfigForClient: r.getConfigForClient}
+	require.Equal(t, "first", servedName(t, conf))
+
+	writeCert(t, certFile, keyFile, "second")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+
+	// Within the check interval the old certificate is still served
+	require.Equal(t, "first", servedName(t, conf))
+
+	r.lastCheck = time.Time{}
+	require.Equal(t, "second", servedName(t, conf))
+
+	// A broken file keeps the previous certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	require.NoError(t, os.Chtimes(certFile, future.Add(time.Minute), future.Add(time.Minute)))
+	r.lastCheck = time.Time{}
+	require.Equal(t, "second", servedName(t, conf))
+}
+
+func TestNewTLSConfig_Errors(t *testing.T) {
+	dir := t.TempDir()
+	_, err := HTTPConf{TLSCertFile: filepath.Join(dir, "missing.pem"), TLSKeyFile: filepath.Join(dir, "missing.key")}.NewTLSConfig()
+	require.Error(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "zms")
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0o600))
+	_, err = HTTPConf{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientCAFile: caFile}.NewTLSConfig()
+	require.Error(t, err)
+
+	_, err = HTTPConf{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientCAFile: certFile}.NewTLSConfig()
+	require.NoError(t, err)
+}
+
+func TestSetHTTPTLS(t *testing.T) {
+	conf := ZMSConf{}
+	conf.setHTTPTLS()
+	require.Equal(t, DEFAULT_TLS_MIN_VERSION, conf.Http.TLSMinVersion)
+	require.False(t, conf.Http.TLSEnabled())
+
+	conf = ZMSConf{Http: HTTPConf{TLSMinVersion: "1.3"}}
+	conf.setHTTPTLS()
+	require.Equal(t, "1.3", conf.Http.TLSMinVersion)
+
+	for _, version := range []string{"1.4", "TLS1.3"} {
+		unknown := ZMSConf{Http: HTTPConf{TLSMinVersion: version}}
+		require.PanicsWithValue(t, "Invalid HTTP TLS config! Reason: unknown tls_min_version "+version, unknown.setHTTPTLS)
+	}
+
+	noKey := ZMSConf{Http: HTTPConf{TLSCertFile: "cert.pem"}}
+	require.Panics(t, noKey.setHTTPTLS)
+
+	noCert := ZMSConf{Http: HTTPConf{TLSClientCAFile: "ca.pem"}}
+	require.Panics(t, noCert.setHTTPTLS)
+
+	noCA := ZMSConf{Http: HTTPConf{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem", Auth: HTTPAuthConf{ClientCert: true}}}
+	require.Panics(t, noCA.setHTTPTLS)
+}
@@ -0,0 +1,263 @@
+package deadletter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/logger"
+)
+
+const (
+	FILE_PREFIX = "deadletter-"
+	FILE_SUFFIX = ".ndjson.gz"
+	// FILE_TIME_FORMAT keeps file names in the order they were created
+	FILE_TIME_FORMAT = "20060102T150405.000000000"
+)
+
+var (
+	deadLetterEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zms_deadletter_entries_total",
+		Help: "The total number of unparseable lines stored in the dead-letter store",
+	}, []string{"export_type"})
+
+	deadLetterErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zms_deadletter_errors_total",
+		Help: "The total number of lines that could not be stored in the dead-letter store",
+	})
+
+	deadLetterSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zms_deadletter_size_bytes",
+		Help: "Size of files in the dead-letter store",
+	})
+)
+
+// Entry is a line of an export file that could not be parsed.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Export string    `json:"export_type"`
+	File   string    `json:"file"`
+	Offset int64     `json:"offset"` // where the line starts in File
+	Error  string    `json:"error"`
+	Line   string    `json:"line"`
+}
+
+// Store appends entries to gzip compressed NDJSON files.
+// A new file is started once the current one reaches MaxFileSize,
+// and the oldest files are removed when all of them exceed MaxSize.
+// Every entry is flushed, so files can be read while they are written.
+type Store struct {
+	conf config.DeadLetterConf
+
+	mu     sync.Mutex
+	f      *os.File // current file, created with the first entry
+	gz     *gzip.Writer
+	closed bool
+}
+
+// Open creates the store directory. Files are created once there is something to store.
+func Open(conf config.DeadLetterConf) (*Store, error) {
+	if err := os.MkdirAll(conf.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("cannot create dead-letter directory %s: %w", conf.Dir, err)
+	}
+	s := &Store{conf: conf}
+	s.removeOldest()
+	return s, nil
+}
+
+// Add stores the entry.
+func (s *Store) Add(e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		deadLetterErrors.Inc()
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		deadLetterErrors.Inc()
+		return errors.New("dead-letter store is closed")
+	}
+	if s.gz == nil {
+		if err := s.create(); err != nil {
+			deadLetterErrors.Inc()
+			return err
+		}
+	}
+	if _, err := s.gz.Write(append(data, '\n')); err != nil {
+		deadLetterErrors.Inc()
+		return err
+	}
+	if err := s.gz.Flush(); err != nil {
+		deadLetterErrors.Inc()
+		return err
+	}
+	deadLetterEntries.WithLabelValues(e.Export).Inc()
+
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() >= s.conf.MaxFileSize {
+		// the next entry starts a new file
+		if err := s.closeFile(); err != nil {
+			logger.Error("Failed to close dead-letter file", slog.Any("error", err))
+		}
+	}
+	return nil
+}
+
+// Close finishes the current file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.closeFile()
+}
+
+func (s *Store) closeFile() error {
+	if s.gz == nil {
+		return nil
+	}
+	err := errors.Join(s.gz.Close(), s.f.Close())
+	s.gz, s.f = nil, nil
+	return err
+}
+
+// create starts a new file and removes the oldest files over the size limit.
+// Must be called with the lock held.
+func (s *Store) create() error {
+	name := FILE_PREFIX + time.Now().UTC().Format(FILE_TIME_FORMAT) + FILE_SUFFIX
+	f, err := os.OpenFile(filepath.Join(s.conf.Dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("cannot create dead-letter file: %w", err)
+	}
+	s.f = f
+	s.gz = gzip.NewWriter(f)
+
+	s.removeOldest()
+	return nil
+}
+
+// removeOldest removes files, oldest first, until all of them fit in MaxSize.
+// The newest file is never removed.
+func (s *Store) removeOldest() {
+	files, err := Files(s.conf.Dir)
+	if err != nil {
+		logger.Error("Failed to list dead-letter files", slog.Any("error", err))
+		return
+	}
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, path := range files {
+		if info, err := os.Stat(path); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	for i, path := range files[:max(len(files)-1, 0)] {
+		if total <= s.conf.MaxSize {
+			break
+		}
+		if err := os.Remove(path); err != nil {
+			logger.Error("Failed to remove dead-letter file", slog.String("file", path), slog.Any("error", err))
+			continue
+		}
+		logger.Info("Removed dead-letter file over size limit", slog.String("file", path))
+		total -= sizes[i]
+	}
+	deadLetterSize.Set(float64(total))
+}
+
+// Files lists dead-letter files in dir, oldest first.
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, FILE_PREFIX) && strings.HasSuffix(name, FILE_SUFFIX) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// ReadAll calls fn for every entry stored in dir, oldest first.
+func ReadAll(dir string, fn func(Entry) error) error {
+	files, err := Files(dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if err := ReadFile(path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFile calls fn for every entry in a dead-letter file.
+// A file that is still written, or was not closed properly, is read up to its last complete entry.
+func ReadFile(path string, fn func(Entry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if errors.Is(err, io.EOF) {
+		// nothing written yet
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer gz.Close()
+	return Decode(gz, fn)
+}
+
+// Decode calls fn for every entry in NDJSON read from r.
+// Reading stops quietly at a truncated entry at the end of a compressed file.
+func Decode(r io.Reader, fn func(Entry) error) error {
+	buf := bufio.NewReader(r)
+	for {
+		line, err := buf.ReadBytes('\n')
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			var e Entry
+			if err := json.Unmarshal(line, &e); err != nil {
+				return fmt.Errorf("invalid dead-letter entry: %w", err)
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package deadletter
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+)
+
+func testEntry(n int) Entry {
+	return Entry{
+		Time:   time.Unix(1700000000, 0).UTC(),
+		Export: "history",
+		File:   "/var/lib/zabbix/export/history-history-syncer-1.ndjson",
+		Offset: int64(n * 100),
+		Error:  "unexpected end of JSON input",
+		Line:   fmt.Sprintf(`{"itemid":%d,"clock":`, n),
+	}
+}
+
+func readEntries(t *testing.T, dir string) (entries []Entry) {
+	t.Helper()
+	require.NoError(t, ReadAll(dir, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	return entries
+}
+
+func TestStore_AddRead(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(config.DeadLetterConf{Dir: dir, MaxFileSize: 1 << 20, MaxSize: 1 << 20})
+	require.NoError(t, err)
+
+	// nothing is created until there is something to store
+	files, err := Files(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	require.NoError(t, s.Add(testEntry(1)))
+	require.NoError(t, s.Add(testEntry(2)))
+
+	// entries are readable before the file is closed
+	require.Equal(t, []Entry{testEntry(1), testEntry(2)}, readEntries(t, dir))
+
+	require.NoError(t, s.Close())
+	require.Equal(t, []Entry{testEntry(1), testEntry(2)}, readEntries(t, dir))
+	require.Error(t, s.Add(testEntry(3)))
+}
+
+func TestStore_Retention(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.DeadLetterConf{Dir: dir, MaxFileSize: 1, MaxSize: 200}
+	s, err := Open(conf)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// every entry goes to a separate file
+	for n := range 20 {
+		require.NoError(t, s.Add(testEntry(n)))
+	}
+	files, err := Files(dir)
+	require.NoError(t, err)
+	require.Less(t, len(files), 20)
+
+	var total int64
+	for _, f := range files {
+		info, err := os.Stat(f)
+		require.NoError(t, err)
+		total += info.Size()
+	}
+	require.LessOrEqual(t, total, conf.MaxSize+fileSize(t, files[len(files)-1]))
+
+	// the newest entries are kept
+	entries := readEntries(t, dir)
+	require.Equal(t, testEntry(19), entries[len(entries)-1])
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	return fi.Size()
+}
+
+func TestDecode(t *testing.T) {
+	var entries []Entry
+	input := `{"export_type":"history","line":"{\"itemid\":1}"}` + "\n\n" + `{"export_type":"trends","line":"{}"}`
+	require.NoError(t, Decode(strings.NewReader(input), func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	require.Len(t, entries, 2)
+	require.Equal(t, "trends", entries[1].Export)
+
+	require.Error(t, Decode(strings.NewReader("not json\n"), func(Entry) error { return nil }))
+}
@@ -0,0 +1,141 @@
+package input
+
+import (
+	"errors"
+	"log/slog"
+	"maps"
+	"sync"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"zms.szuro.net/internal/logger"
+	"zms.szuro.net/internal/zbx"
+)
+
+var committedOffset = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "zms_file_committed_offset",
+		Help: "Offset of export file acknowledged by all targets",
+	},
+	[]string{"file"},
+)
+
+// checkpoint covers all records read up to positions and waits for
+// the given number of acknowledgements before it can be committed.
+type checkpoint struct {
+	positions map[string]zbx.Position
+	remaining int
+}
+
+// Checkpointer commits file offsets once every target acknowledged records up to them.
+// Checkpoints are committed in the order they were created, so a slow target holds
+// back offsets even if faster targets have already acknowledged later batches.
+type Checkpointer struct {
+	mu        sync.Mutex
+	pending   []*checkpoint
+	committed map[string]zbx.Position
+	dirty     map[string]bool
+}
+
+func NewCheckpointer() *Checkpointer {
+	return &Checkpointer{
+		committed: make(map[string]zbx.Position),
+		dirty:     make(map[string]bool),
+	}
+}
+
+// Track registers positions waiting for acks acknowledgements.
+// The returned function must be called once per acknowledgement.
+func (c *Checkpointer) Track(positions map[string]zbx.Position, acks int) (ack func()) {
+	cp := &checkpoint{positions: maps.Clone(positions), remaining: acks}
+
+	c.mu.Lock()
+	c.pending = append(c.pending, cp)
+	c.advance()
+	c.mu.Unlock()
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		cp.remaining--
+		c.advance()
+	}
+}
+
+// advance commits fully acknowledged checkpoints from the head of the queue.
+// Must be called with the lock held.
+func (c *Checkpointer) advance() {
+	for len(c.pending) > 0 && c.pending[0].remaining <= 0 {
+		for file, pos := range c.pending[0].positions {
+			c.committed[file] = pos
+			c.dirty[file] = true
+		}
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+	}
+}
+
+// Committed returns the last committed position in a file.
+func (c *Checkpointer) Committed(file string) (pos zbx.Position, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pos, ok = c.committed[file]
+	return
+}
+
+// Persist writes offsets committed since the last call to the index.
+func (c *Checkpointer) Persist(index *badger.DB) error {
+	c.mu.Lock()
+	changed := make(map[string]zbx.Position, len(c.dirty))
+	for file := range c.dirty {
+		changed[file] = c.committed[file]
+	}
+	clear(c.dirty)
+	c.mu.Unlock()
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	err := index.Update(func(txn *badger.Txn) error {
+		for file, pos := range changed {
+			val, err := pos.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			if err := txn.Set([]byte(file), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// retry with the next checkpoint
+		c.mu.Lock()
+		for file := range changed {
+			c.dirty[file] = true
+		}
+		c.mu.Unlock()
+		return err
+	}
+
+	for file, pos := range changed {
+		committedOffset.WithLabelValues(file).Set(float64(pos.Offset))
+		logger.Debug("Checkpointed file offset", slog.String("file", file), slog.Int64("offset", pos.Offset), slog.Uint64("inode", pos.Inode))
+	}
+	return nil
+}
+
+// Checkpointers holds a Checkpointer for every subject, keyed by export type,
+// so a slow target of one export type does not hold back offsets of the others.
+type Checkpointers map[string]*Checkpointer
+
+// Persist writes offsets committed by every checkpointer since the last call to the index.
+func (cs Checkpointers) Persist(index *badger.DB) error {
+	var errs []error
+	for _, c := range cs {
+		errs = append(errs, c.Persist(index))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,174 @@
+package input
+
+import (
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/zbx"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func TestCheckpointer_CommitsInOrder(t *testing.T) {
+	c := NewCheckpointer()
+
+	ackFirst := c.Track(map[string]zbx.Position{"a": {File: "a", Offset: 10}}, 2)
+	ackSecond := c.Track(map[string]zbx.Position{"a": {File: "a", Offset: 20}, "b": {File: "b", Offset: 5}}, 2)
+
+	// second batch acknowledged by all targets, first one still pending
+	ackSecond()
+	ackSecond()
+	_, ok := c.Committed("a")
+	require.False(t, ok)
+
+	ackFirst()
+	_, ok = c.Committed("a")
+	require.False(t, ok)
+
+	ackFirst()
+	pos, ok := c.Committed("a")
+	require.True(t, ok)
+	require.Equal(t, int64(20), pos.Offset)
+	pos, _ = c.Committed("b")
+	require.Equal(t, int64(5), pos.Offset)
+}
+
+func TestCheckpointer_NoTargets(t *testing.T) {
+	c := NewCheckpointer()
+	c.Track(map[string]zbx.Position{"a": {File: "a", Offset: 42}}, 0)
+
+	pos, ok := c.Committed("a")
+	require.True(t, ok)
+	require.Equal(t, int64(42), pos.Offset)
+}
+
+func TestCheckpointer_Persist(t *testing.T) {
+	db, err := badger.Open(badger.DefaultOptions(t.TempDir()).WithLogger(nil))
+	require.NoError(t, err)
+	defer db.Close()
+
+	c := NewCheckpointer()
+	c.Track(map[string]zbx.Position{"/tmp/export.ndjson": {File: "/tmp/export.ndjson", Offset: 1337, Inode: 42, Fingerprint: 7}}, 0)
+	require.NoError(t, c.Persist(db))
+
+	err = db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("/tmp/export.ndjson"))
+		require.NoError(t, err)
+		return item.Value(func(val []byte) error {
+			var pos zbx.Position
+			require.NoError(t, pos.UnmarshalBinary(val))
+			require.Equal(t, zbx.Position{Offset: 1337, Inode: 42, Fingerprint: 7}, pos)
+			return nil
+		})
+	})
+	require.NoError(t, err)
+}
+
+func TestCheckpointer_FailingTarget(t *testing.T) {
+	observer := &fakeObserver{name: "target", fail: true}
+	c := NewCheckpointer()
+	q := NewDeliveryQueue[zbxpkg.History](observer, config.DeliveryConf{Workers: 1, QueueDepth: 1})
+
+	q.Enqueue([]zbxpkg.History{{ItemID: 1}}, c.Track(map[string]zbx.Position{"f": {File: "f", Offset: 10}}, 1))
+	// the batch is retried until the queue is closed, without being acknowledged
+	require.Eventually(t, func() bool {
+		observer.mu.Lock()
+		defer observer.mu.Unlock()
+		return len(observer.history) >= 2
+	}, time.Second, time.Millisecond)
+	q.Close()
+
+	_, ok := c.Committed("f")
+	require.False(t, ok, "offset committed for a batch the target did not accept")
+}
+
+func TestCheckpointer_TargetRecovers(t *testing.T) {
+	observer := &fakeObserver{name: "target", fail: true}
+	c := NewCheckpointer()
+	q := NewDeliveryQueue[zbxpkg.History](observer, config.DeliveryConf{Workers: 1, QueueDepth: 1})
+	defer q.Close()
+
+	q.Enqueue([]zbxpkg.History{{ItemID: 1}}, c.Track(map[string]zbx.Position{"f": {File: "f", Offset: 10}}, 1))
+	require.Eventually(t, func() bool {
+		observer.mu.Lock()
+		defer observer.mu.Unlock()
+		return len(observer.history) >= 1
+	}, time.Second, time.Millisecond)
+	_, ok := c.Committed("f")
+	require.False(t, ok)
+
+	observer.mu.Lock()
+	observer.fail = false
+	observer.mu.Unlock()
+	require.Eventually(t, func() bool {
+		pos, ok := c.Committed("f")
+		return ok && pos.Offset == 10
+	}, time.Second, time.Millisecond)
+}
+
+func TestCheckpointer_ReloadRemovesFailingTarget(t *testing.T) {
+	healthy := &fakeObserver{name: "healthy"}
+	failing := &fakeObserver{name: "failing", fail: true}
+	c := NewCheckpointer()
+	s, done := startTestSubject(t, healthy, 1, 1, c)
+	s.Register(failing, config.DeliveryConf{Workers: 1, QueueDepth: 10})
+	target := func(name string) config.Target {
+		return config.Target{UniqueName: name, PluginBinaryName: "print", Source: []string{zbxpkg.HISTORY}}
+	}
+	bs := &baseInput{
+		config:   config.ZMSConf{Targets: []config.Target{target("healthy"), target("failing")}},
+		subjects: map[string]Subjecter{zbxpkg.HISTORY: s},
+	}
+	committed := func(offset int64) func() bool {
+		return func() bool {
+			pos, ok := c.Committed("f")
+			return ok && pos.Offset == offset
+		}
+	}
+
+	// the failing target holds back the offset delivered to the healthy one
+	s.Funnel <- zbx.Record{Value: zbxpkg.History{ItemID: 1}, Position: zbx.Position{File: "f", Offset: 10}}
+	waitHistory(t, healthy, 1)
+	require.Eventually(t, func() bool {
+		failing.mu.Lock()
+		defer failing.mu.Unlock()
+		return len(failing.history) >= 2
+	}, time.Second, time.Millisecond)
+	_, ok := c.Committed("f")
+	require.False(t, ok)
+
+	// once it is removed, batches it did not accept are dropped and offsets are committed again
+	require.NoError(t, bs.Reload(config.ZMSConf{Targets: []config.Target{target("healthy")}}))
+	require.Eventually(t, committed(10), time.Second, time.Millisecond)
+
+	s.Funnel <- zbx.Record{Value: zbxpkg.History{ItemID: 2}, Position: zbx.Position{File: "f", Offset: 20}}
+	require.Eventually(t, committed(20), time.Second, time.Millisecond)
+
+	close(s.Funnel)
+	<-done
+	s.Drain()
+}
+
+func TestFileInput_CheckpointerPerSubject(t *testing.T) {
+	fi := &FileInput{zbxConf: zbx.ZabbixConf{ExportTypes: []string{zbxpkg.HISTORY, zbxpkg.TREND}}}
+	fi.subjects = make(map[string]Subjecter)
+	fi.mkSubjects()
+
+	require.Len(t, fi.checkpointers, 2)
+	history, trends := fi.checkpointers[zbxpkg.HISTORY], fi.checkpointers[zbxpkg.TREND]
+	require.NotSame(t, history, trends)
+	require.Same(t, history, fi.subjects[zbxpkg.HISTORY].(*Subject[zbxpkg.History]).checkpointer)
+	require.Same(t, trends, fi.subjects[zbxpkg.TREND].(*Subject[zbxpkg.Trend]).checkpointer)
+
+	// history waiting for a slow target does not hold back trends
+	history.Track(map[string]zbx.Position{"history": {File: "history", Offset: 10}}, 1)
+	ack := trends.Track(map[string]zbx.Position{"trends": {File: "trends", Offset: 20}}, 1)
+	ack()
+	_, ok := history.Committed("history")
+	require.False(t, ok)
+	pos, ok := trends.Committed("trends")
+	require.True(t, ok)
+	require.Equal(t, int64(20), pos.Offset)
+}
@@ -0,0 +1,80 @@
+package input
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"zms.szuro.net/internal/logger"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// CONNECTOR_ENDPOINT accepts both item values and events sent by Zabbix connectors.
+const CONNECTOR_ENDPOINT = "connector"
+
+// errorResponse is the body of failed requests. Zabbix connectors log the error field
+// and retry the request, other fields are meant for humans.
+type errorResponse struct {
+	Error    string      `json:"error"`
+	Rejected []lineError `json:"rejected,omitempty"`
+}
+
+// acceptedResponse is the body of requests accepted without some of their lines.
+type acceptedResponse struct {
+	Accepted int         `json:"accepted"`
+	Rejected []lineError `json:"rejected"`
+}
+
+type lineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string, rejected []lineError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(errorResponse{Error: message, Rejected: rejected}); err != nil {
+		logger.Debug("Failed to write error response")
+	}
+}
+
+// writeAccepted answers with 200 and the lines that were not accepted.
+func writeAccepted(w http.ResponseWriter, accepted int, rejected []lineError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(acceptedResponse{Accepted: accepted, Rejected: rejected}); err != nil {
+		logger.Debug("Failed to write response")
+	}
+}
+
+// connectorRecord holds fields telling item values and events apart.
+// Log item values may carry eventid as well, so itemid decides first.
+type connectorRecord struct {
+	ItemID  *json.RawMessage `json:"itemid"`
+	EventID *json.RawMessage `json:"eventid"`
+}
+
+// routeConnector parses a line sent by a Zabbix connector as history or event.
+func routeConnector(line []byte) (string, any, error) {
+	var rec connectorRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return "", nil, err
+	}
+	var name string
+	switch {
+	case rec.ItemID != nil:
+		name = zbxpkg.HISTORY
+	case rec.EventID != nil:
+		name = zbxpkg.EVENT
+	default:
+		return "", nil, errors.New("neither itemid nor eventid present")
+	}
+	value, err := exportTypes[name].parse(line)
+	return name, value, err
+}
+
+// handleConnector returns a handler for the single endpoint of Zabbix connectors,
+// accepting item values and events in the same request.
+func (hi *HTTPInput) handleConnector() http.HandlerFunc {
+	return hi.handleLines(CONNECTOR_ENDPOINT, []string{zbxpkg.HISTORY, zbxpkg.EVENT}, routeConnector)
+}
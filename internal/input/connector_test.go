@@ -0,0 +1,139 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// postConnector replays a captured connector payload the way Zabbix sends it.
+func postConnector(t *testing.T, hi *HTTPInput, file string, compress bool) *httptest.ResponseRecorder {
+	t.Helper()
+	payload, err := os.ReadFile(filepath.Join("testdata", "connector", file))
+	require.NoError(t, err)
+
+	if compress {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err = gz.Write(payload)
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		payload = buf.Bytes()
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/"+CONNECTOR_ENDPOINT, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Authorization", "Bearer token")
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	rec := httptest.NewRecorder()
+	hi.handleConnector()(rec, req)
+	return rec
+}
+
+func TestConnector_Payloads(t *testing.T) {
+	tests := []struct {
+		file    string
+		history int
+		events  int
+	}{
+		{"item_values.ndjson", 5, 0},
+		{"events.ndjson", 0, 2},
+		{"mixed.ndjson", 5, 2},
+	}
+
+	for _, tt := range tests {
+		for _, compress := range []bool{false, true} {
+			hi, err := NewHTTPInput(config.ZMSConf{BufferSize: 10})
+			require.NoError(t, err)
+
+			rec := postConnector(t, hi, tt.file, compress)
+			require.Equal(t, http.StatusOK, rec.Code, tt.file)
+			require.Len(t, hi.GetSubjects()[zbxpkg.HISTORY].GetFunnel(), tt.history, tt.file)
+			require.Len(t, hi.GetSubjects()[zbxpkg.EVENT].GetFunnel(), tt.events, tt.file)
+		}
+	}
+}
+
+func TestConnector_Records(t *testing.T) {
+	hi, err := NewHTTPInput(config.ZMSConf{BufferSize: 10})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, postConnector(t, hi, "mixed.ndjson", false).Code)
+
+	history := hi.GetSubjects()[zbxpkg.HISTORY].GetFunnel()
+	first := (<-history).(zbxpkg.History)
+	require.Equal(t, int64(44457), first.ItemID)
+	require.Equal(t, "Zabbix server", first.Host.Host)
+	require.Equal(t, []zbxpkg.Tag{{Tag: "foo", Value: "test"}}, first.Tags)
+	require.Equal(t, int64(800155804), first.Ns)
+
+	events := hi.GetSubjects()[zbxpkg.EVENT].GetFunnel()
+	problem := (<-events).(zbxpkg.Event)
+	require.Equal(t, int64(5), problem.EventID)
+	require.Equal(t, int32(1), problem.Value)
+	recovery := (<-events).(zbxpkg.Event)
+	require.Equal(t, int64(5), recovery.PEventID)
+}
+
+func TestConnector_PartialFailure(t *testing.T) {
+	hi, err := NewHTTPInput(config.ZMSConf{BufferSize: 10})
+	require.NoError(t, err)
+
+	rec := postConnector(t, hi, "invalid.ndjson", false)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var resp acceptedResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, 2, resp.Accepted)
+	require.Len(t, resp.Rejected, 2)
+	require.Equal(t, 2, resp.Rejected[0].Line)
+	require.Equal(t, 5, resp.Rejected[1].Line)
+
+	// valid lines are buffered despite invalid ones
+	require.Len(t, hi.GetSubjects()[zbxpkg.HISTORY].GetFunnel(), 1)
+	require.Len(t, hi.GetSubjects()[zbxpkg.EVENT].GetFunnel(), 1)
+}
+
+func TestConnector_AllLinesInvalid(t *testing.T) {
+	hi, err := NewHTTPInput(config.ZMSConf{BufferSize: 10})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/"+CONNECTOR_ENDPOINT, strings.NewReader("not json\n{\"host\":\"Zabbix server\"}\n"))
+	rec := httptest.NewRecorder()
+	hi.handleConnector()(rec, req)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	var resp errorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, "2 of 2 lines rejected", resp.Error)
+	require.Len(t, resp.Rejected, 2)
+}
+
+func TestExportEndpoint_RejectsOtherRecords(t *testing.T) {
+	hi, err := NewHTTPInput(config.ZMSConf{BufferSize: 10})
+	require.NoError(t, err)
+
+	payload, err := os.ReadFile(filepath.Join("testdata", "connector", "item_values.ndjson"))
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/"+zbxpkg.HISTORY, bytes.NewReader(append(payload, "not json\n"...)))
+	rec := httptest.NewRecorder()
+	hi.handleExport(zbxpkg.HISTORY)(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp acceptedResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Rejected, 1)
+	require.Len(t, hi.GetSubjects()[zbxpkg.HISTORY].GetFunnel(), resp.Accepted)
+}
@@ -0,0 +1,169 @@
+package input
+
+import (
+	"log/slog"
+	"path"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/logger"
+	"zms.szuro.net/internal/zbx"
+)
+
+// DBInput polls the Zabbix database instead of following export files.
+// Like FileInput, positions read up to are saved in the index once targets
+// acknowledged values, and polling runs only while the Zabbix server node is active.
+type DBInput struct {
+	baseInput
+	mu              sync.Mutex
+	poller          *zbx.DBPoller
+	polling         bool
+	funnels         map[string]chan any
+	ha              *HAWatcher
+	index           *badger.DB
+	detector        zbx.HADetector
+	checkpointers   Checkpointers
+	stopCheckpoints chan struct{}
+	checkpointsDone chan struct{}
+}
+
+func NewDBInput(zbxConf zbx.ZabbixConf, zmsConf config.ZMSConf) (di *DBInput, err error) {
+	di = &DBInput{}
+	di.config = zmsConf
+	di.subjects = make(map[string]Subjecter)
+
+	dbPath := path.Join(zmsConf.DataDir, "index.db")
+	di.index, err = badger.Open(badger.DefaultOptions(dbPath).WithLogger(logger.Default()))
+	if err != nil {
+		logger.Error("Failed to open BadgerDB for database positions", slog.Any("error", err))
+		return
+	}
+	logger.Debug("Initialized BadgerDB for database positions", slog.String("path", dbPath))
+
+	di.detector, err = zbx.NewHADetector(zmsConf.HA, zbxConf)
+	return
+}
+
+func (di *DBInput) Prepare() {
+	di.mkSubjects()
+	di.baseInput.Prepare()
+}
+
+// Start starts processing values. The database is polled only while the
+// Zabbix server node is active, the HA status is checked in the background.
+func (di *DBInput) Start() {
+	di.baseInput.Start()
+	di.stopCheckpoints = make(chan struct{})
+	di.checkpointsDone = make(chan struct{})
+	go di.checkpoint()
+
+	di.ha = NewHAWatcher(di.detector, di, di.config.HA)
+	di.ha.Start()
+}
+
+// Pause stops polling and saves positions acknowledged so far.
+// Values already read are still delivered to targets.
+func (di *DBInput) Pause() {
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	if !di.polling {
+		return
+	}
+	di.poller.Stop()
+	di.polling = false
+	if err := di.checkpointers.Persist(di.index); err != nil {
+		logger.Error("error when saving database positions", slog.Any("error", err))
+	}
+}
+
+// Resume polls the database again from the last acknowledged positions.
+func (di *DBInput) Resume() error {
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	if di.polling {
+		return nil
+	}
+	// positions acknowledged since pausing are where polling continues
+	if err := di.checkpointers.Persist(di.index); err != nil {
+		return err
+	}
+	if di.poller == nil {
+		poller, err := zbx.NewDBPoller(di.config.Database, di.index, di.funnels)
+		if err != nil {
+			return err
+		}
+		di.poller = poller
+	}
+	di.poller.Start()
+	di.polling = true
+	return nil
+}
+
+// Stop stops polling, delivers values read so far within the shutdown timeout
+// and saves positions acknowledged by all targets before observers are cleaned up.
+// Values that were read but not delivered in time are read again after restart.
+func (di *DBInput) Stop() error {
+	if di.ha != nil {
+		di.ha.Stop()
+	}
+	di.Pause()
+	if di.checkpointsDone != nil {
+		close(di.stopCheckpoints)
+		<-di.checkpointsDone
+	}
+
+	err := di.drainWithTimeout()
+
+	if err := di.checkpointers.Persist(di.index); err != nil {
+		logger.Error("error when saving database positions", slog.Any("error", err))
+	}
+	di.cleanup()
+	if di.poller != nil {
+		di.poller.Close()
+	}
+	di.index.Close()
+	return err
+}
+
+// checkpoint periodically saves acknowledged positions, so a crash does not lose track of them.
+func (di *DBInput) checkpoint() {
+	defer close(di.checkpointsDone)
+	ticker := time.NewTicker(di.config.Checkpoint)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-di.stopCheckpoints:
+			return
+		case <-ticker.C:
+			if err := di.checkpointers.Persist(di.index); err != nil {
+				logger.Error("error when saving database positions", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+func (di *DBInput) mkSubjects() {
+	di.funnels = make(map[string]chan any)
+	di.checkpointers = make(Checkpointers)
+	for _, v := range di.config.Database.Exports {
+		export, ok := exportTypes[v]
+		if !ok {
+			logger.Error("Export not supported", slog.String("export", v))
+			continue
+		}
+		subject := export.newSubject()
+		di.funnels[v] = make(chan any, di.config.BufferSize*2)
+		subject.SetFunnel(di.funnels[v])
+		di.subjects[v] = subject
+	}
+
+	for name, subject := range di.subjects {
+		subject.SetBuffer(di.config.BufferSize)
+		subject.SetFlushPolicy(di.config.Flush.PolicyFor(name))
+		subject.SetShards(di.config.Shards)
+		di.checkpointers[name] = NewCheckpointer()
+		subject.SetCheckpointer(di.checkpointers[name])
+	}
+}
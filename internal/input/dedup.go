@@ -0,0 +1,255 @@
+package input
+
+import (
+	"bytes"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/logger"
+)
+
+// DEDUP_PERSIST_INTERVAL is how often hashes of delivered values are written to the store.
+const DEDUP_PERSIST_INTERVAL = time.Second
+
+var (
+	duplicatesDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zms_duplicates_dropped_total",
+			Help: "Number of values dropped because they were seen within the deduplication window",
+		},
+		[]string{"export_type"},
+	)
+
+	dedupEntries = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "zms_dedup_entries",
+			Help: "Number of hashes of recently seen values remembered for deduplication",
+		},
+		[]string{"export_type"},
+	)
+)
+
+// Deduplicator hands out sets of recently seen hashes to subjects.
+// With persistence enabled, hashes of delivered values are written to BadgerDB
+// with the window as TTL and loaded back into sets after restart.
+type Deduplicator struct {
+	conf config.DedupConf
+	db   *badger.DB // nil unless persisted
+
+	mu      sync.Mutex
+	pending []*badger.Entry
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// OpenDeduplicator opens the store of hashes if persistence is enabled.
+func OpenDeduplicator(conf config.DedupConf) (*Deduplicator, error) {
+	d := &Deduplicator{conf: conf}
+	if !conf.Enabled || !conf.Persist {
+		return d, nil
+	}
+	db, err := badger.Open(badger.DefaultOptions(conf.Dir).WithLogger(logger.Default()))
+	if err != nil {
+		return nil, err
+	}
+	d.db = db
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	go d.persistLoop()
+	return d, nil
+}
+
+// Set returns the set of hashes for the export type, or nil if deduplication is disabled for it.
+func (d *Deduplicator) Set(export string) *dedupSet {
+	if d == nil {
+		return nil
+	}
+	policy, ok := d.conf.PolicyFor(export)
+	if !ok {
+		return nil
+	}
+	s := newDedupSet(export, policy)
+	if d.db != nil {
+		s.store = d
+		if err := d.load(s); err != nil {
+			logger.Error("Failed to load hashes for deduplication", slog.String("export", export), slog.Any("error", err))
+		}
+	}
+	return s
+}
+
+// Close writes pending hashes and closes the store.
+func (d *Deduplicator) Close() error {
+	if d == nil || d.db == nil {
+		return nil
+	}
+	close(d.stop)
+	<-d.done
+	return d.db.Close()
+}
+
+// load fills the set with hashes not expired yet.
+func (d *Deduplicator) load(s *dedupSet) error {
+	prefix := []byte(s.export + "/")
+	var loaded []dedupEntry
+	err := d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			hash := bytes.TrimPrefix(item.Key(), prefix)
+			loaded = append(loaded, dedupEntry{hash: string(hash), expires: time.Unix(int64(item.ExpiresAt()), 0)})
+		}
+		return nil
+	})
+	slices.SortFunc(loaded, func(a, b dedupEntry) int { return a.expires.Compare(b.expires) })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range loaded {
+		s.add(e)
+	}
+	s.evict(s.now())
+	return err
+}
+
+// persist queues hashes of the export type to be written with the window as TTL.
+func (d *Deduplicator) persist(export string, window time.Duration, hashes [][]byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, h := range hashes {
+		key := append([]byte(export+"/"), h...)
+		d.pending = append(d.pending, badger.NewEntry(key, nil).WithTTL(window))
+	}
+}
+
+func (d *Deduplicator) persistLoop() {
+	defer close(d.done)
+	ticker := time.NewTicker(DEDUP_PERSIST_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			d.write()
+			return
+		case <-ticker.C:
+			d.write()
+		}
+	}
+}
+
+// write stores queued hashes. Hashes failing to be written are only kept in memory.
+func (d *Deduplicator) write() {
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = nil
+	d.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	wb := d.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, e := range pending {
+		if err := wb.SetEntry(e); err != nil {
+			logger.Error("Failed to persist hashes for deduplication", slog.Any("error", err))
+			return
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		logger.Error("Failed to persist hashes for deduplication", slog.Any("error", err))
+	}
+}
+
+type dedupEntry struct {
+	hash    string
+	expires time.Time
+}
+
+// dedupSet remembers hashes of values of an export type seen within the window,
+// up to MaxEntries. It is shared by shards of a subject.
+type dedupSet struct {
+	export  string
+	policy  config.DedupPolicy
+	store   *Deduplicator // nil unless persisted
+	now     func() time.Time
+	dropped prometheus.Counter
+	entries prometheus.Gauge
+
+	mu    sync.Mutex
+	seen  map[string]time.Time // expiry by hash
+	order []dedupEntry         // in the order hashes were added, expiring first at the front
+}
+
+func newDedupSet(export string, policy config.DedupPolicy) *dedupSet {
+	s := &dedupSet{
+		export:  export,
+		policy:  policy,
+		now:     time.Now,
+		dropped: duplicatesDropped.WithLabelValues(export),
+		entries: dedupEntries.WithLabelValues(export),
+		seen:    make(map[string]time.Time),
+	}
+	s.entries.Set(0)
+	return s
+}
+
+// Seen reports whether the hash was seen within the window, and remembers it if it was not.
+func (s *dedupSet) Seen(hash []byte) bool {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if expires, ok := s.seen[string(hash)]; ok && now.Before(expires) {
+		s.dropped.Inc()
+		return true
+	}
+	s.add(dedupEntry{hash: string(hash), expires: now.Add(s.policy.Window)})
+	s.evict(now)
+	return false
+}
+
+// Persist stores hashes of delivered values, if persistence is enabled.
+// Values not delivered are not persisted, so they are not dropped when read again after restart.
+func (s *dedupSet) Persist(hashes [][]byte) {
+	if s.store == nil || len(hashes) == 0 {
+		return
+	}
+	s.store.persist(s.export, s.policy.Window, hashes)
+}
+
+// Persisted reports whether hashes need to be passed to Persist.
+func (s *dedupSet) Persisted() bool {
+	return s.store != nil
+}
+
+// add remembers the hash. Must be called with the lock held.
+func (s *dedupSet) add(e dedupEntry) {
+	s.seen[e.hash] = e.expires
+	s.order = append(s.order, e)
+}
+
+// evict forgets expired hashes and the oldest ones over the limit.
+// Must be called with the lock held.
+func (s *dedupSet) evict(now time.Time) {
+	n := 0
+	for n < len(s.order) && (!now.Before(s.order[n].expires) || len(s.seen) > s.policy.MaxEntries) {
+		e := s.order[n]
+		// the hash may have been added again since
+		if s.seen[e.hash].Equal(e.expires) {
+			delete(s.seen, e.hash)
+		}
+		s.order[n] = dedupEntry{}
+		n++
+	}
+	// the front is released once append moves entries to a new array
+	s.order = s.order[n:]
+	s.entries.Set(float64(len(s.seen)))
+}
@@ -0,0 +1,89 @@
+package input
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/pkg/filter"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func TestDedupSet_Window(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := newDedupSet(zbxpkg.HISTORY, config.DedupPolicy{Window: time.Minute, MaxEntries: 2})
+	s.now = func() time.Time { return now }
+	dropped := counterValue(t, s.dropped)
+
+	require.False(t, s.Seen([]byte("a")))
+	require.True(t, s.Seen([]byte("a")))
+	require.Equal(t, dropped+1, counterValue(t, s.dropped))
+
+	// forgotten once the window passed
+	now = now.Add(time.Minute)
+	require.False(t, s.Seen([]byte("a")))
+
+	// the oldest hash is forgotten over the limit
+	require.False(t, s.Seen([]byte("b")))
+	require.False(t, s.Seen([]byte("c")))
+	require.Len(t, s.seen, 2)
+	require.False(t, s.Seen([]byte("a")))
+	require.True(t, s.Seen([]byte("c")))
+}
+
+func TestDeduplicator_Persist(t *testing.T) {
+	conf := config.DedupConf{
+		Enabled:     true,
+		Persist:     true,
+		DedupPolicy: config.DedupPolicy{Window: time.Hour, MaxEntries: 100},
+		Dir:         t.TempDir(),
+	}
+	d, err := OpenDeduplicator(conf)
+	require.NoError(t, err)
+	s := d.Set(zbxpkg.HISTORY)
+	require.True(t, s.Persisted())
+	require.False(t, s.Seen([]byte("delivered")))
+	require.False(t, s.Seen([]byte("lost")))
+	s.Persist([][]byte{[]byte("delivered")})
+	require.NoError(t, d.Close())
+
+	d, err = OpenDeduplicator(conf)
+	require.NoError(t, err)
+	defer d.Close()
+	s = d.Set(zbxpkg.HISTORY)
+	require.True(t, s.Seen([]byte("delivered")))
+	// not delivered before restart, so not a duplicate when read again
+	require.False(t, s.Seen([]byte("lost")))
+	// other export types have sets of their own
+	require.False(t, d.Set(zbxpkg.EVENT).Seen([]byte("delivered")))
+}
+
+func TestSubject_DropsDuplicates(t *testing.T) {
+	observer := &fakeObserver{name: "target"}
+	d, err := OpenDeduplicator(config.DedupConf{Enabled: true, DedupPolicy: config.DedupPolicy{Window: time.Hour, MaxEntries: 100}})
+	require.NoError(t, err)
+
+	s := NewSubject[zbxpkg.History]()
+	s.SetDedup(d)
+	s.SetFunnel(make(chan any, 10))
+	s.SetBuffer(10)
+	s.SetShards(2)
+	s.SetFilter(filter.NewTagFilter(filter.FilterConfig{}))
+	s.Register(observer, config.DeliveryConf{Workers: 1, QueueDepth: 10})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.AcceptValues()
+	}()
+
+	s.Funnel <- zbxpkg.History{ItemID: 1, Clock: 1}
+	s.Funnel <- zbxpkg.History{ItemID: 2, Clock: 1}
+	s.Funnel <- zbxpkg.History{ItemID: 1, Clock: 1}
+	s.Funnel <- zbxpkg.History{ItemID: 1, Clock: 2}
+	close(s.Funnel)
+	<-done
+	s.Drain()
+
+	require.Len(t, observer.history, 3)
+}
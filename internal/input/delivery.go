@@ -0,0 +1,424 @@
+package input
+
+import (
+	"log/slog"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/logger"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+const (
+	// MIN_DELIVERY_BACKOFF is how long a worker waits before retrying a batch the target failed to accept.
+	MIN_DELIVERY_BACKOFF = 100 * time.Millisecond
+	// MAX_DELIVERY_BACKOFF caps the exponential backoff between retries.
+	MAX_DELIVERY_BACKOFF = 30 * time.Second
+)
+
+var (
+	deliveryQueueDepth = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "zms_delivery_queue_depth",
+			Help: "Number of batches waiting in memory for delivery to a target",
+		},
+		[]string{"target_name", "export_type"},
+	)
+
+	deliverySpilled = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "zms_delivery_spilled_batches",
+			Help: "Number of batches spilled to disk waiting for delivery to a target",
+		},
+		[]string{"target_name", "export_type"},
+	)
+
+	deliveryInFlight = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "zms_delivery_inflight_batches",
+			Help: "Number of batches currently being delivered to a target",
+		},
+		[]string{"target_name", "export_type"},
+	)
+
+	deliveryDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zms_delivery_dropped_total",
+			Help: "Number of values dropped because a target delivery queue was full",
+		},
+		[]string{"target_name", "export_type"},
+	)
+
+	deliveryRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zms_delivery_retries_total",
+			Help: "Number of failed attempts to deliver a batch to a target",
+		},
+		[]string{"target_name", "export_type"},
+	)
+)
+
+// delivery is a batch along with the acknowledgement to call once it was handled.
+type delivery[T zbxpkg.Export] struct {
+	batch   []T
+	ack     func()
+	spilled bool   // read back from the spill store
+	seq     uint64 // sequence number in the spill store
+}
+
+func (d delivery[T]) done() {
+	if d.ack != nil {
+		d.ack()
+	}
+}
+
+// DeliveryQueue hands batches over to a single observer.
+// Batches are queued in memory up to the configured depth and delivered by a fixed
+// number of workers. What happens when the queue is full depends on the overflow policy.
+// A batch is acknowledged once the observer handled it, or when it was spilled to disk or dropped.
+// Batches the observer fails to accept are retried with backoff and not acknowledged before they go through.
+type DeliveryQueue[T zbxpkg.Export] struct {
+	observer config.Observer
+	conf     config.DeliveryConf
+	labels   prometheus.Labels
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	batches  []delivery[T]
+	spill    *spillStore[T]
+	closed   bool
+	aborted  bool          // closed without delivering batches left in memory
+	removed  bool          // closed because the target was removed, nothing delivers its batches later
+	stop     chan struct{} // closed along with the queue to stop retrying
+	workers  sync.WaitGroup
+
+	sampler      *sampler[T] // nil without sampling rules
+	stopSampling chan struct{}
+	samplingDone chan struct{}
+	flushSamples sync.Once
+}
+
+// NewDeliveryQueue creates a queue for the observer and starts its workers.
+func NewDeliveryQueue[T zbxpkg.Export](observer config.Observer, conf config.DeliveryConf) *DeliveryQueue[T] {
+	var t T
+	exportType := t.GetExportName()
+
+	q := &DeliveryQueue[T]{
+		observer: observer,
+		conf:     conf,
+		labels:   prometheus.Labels{"target_name": observer.GetName(), "export_type": exportType},
+		stop:     make(chan struct{}),
+	}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+
+	if conf.Overflow == config.OVERFLOW_SPILL {
+		spill, err := openSpillStore[T](path.Join(conf.SpillDir, exportType))
+		if err != nil {
+			logger.Error("Failed to open spill store, falling back to blocking",
+				slog.String("target", observer.GetName()),
+				slog.Any("error", err))
+		}
+		q.spill = spill
+	}
+
+	deliveryQueueDepth.With(q.labels).Set(0)
+	deliveryInFlight.With(q.labels).Set(0)
+	deliveryDropped.With(q.labels).Add(0)
+	deliveryRetries.With(q.labels).Add(0)
+	if q.spill != nil {
+		deliverySpilled.With(q.labels).Set(float64(q.spill.Len()))
+	}
+
+	for i := 0; i < conf.Workers; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+
+	q.sampler = newSampler[T](conf.Sampling, q.labels)
+	if q.sampler != nil && q.sampler.holds() {
+		q.stopSampling = make(chan struct{})
+		q.samplingDone = make(chan struct{})
+		go q.releaseSamples()
+	}
+	return q
+}
+
+// releaseSamples queues values held by sampling rules once their interval ended.
+// Batches they came in are acknowledged once they were delivered.
+func (q *DeliveryQueue[T]) releaseSamples() {
+	defer close(q.samplingDone)
+	ticker := time.NewTicker(SAMPLING_RELEASE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stopSampling:
+			return
+		case now := <-ticker.C:
+			released := q.sampler.release(now)
+			q.enqueue(released.values, released.ack)
+		}
+	}
+}
+
+// Enqueue adds a batch to the queue, applying the overflow policy when it is full.
+// Sampling rules of the target are applied first.
+// ack is called once the batch and values of it held by sampling rules were handled, and may be nil.
+func (q *DeliveryQueue[T]) Enqueue(batch []T, ack func()) {
+	if q.sampler != nil {
+		sampled := q.sampler.sample(batch, newBatchAck(ack))
+		batch, ack = sampled.values, sampled.ack
+	}
+	q.enqueue(batch, ack)
+}
+
+func (q *DeliveryQueue[T]) enqueue(batch []T, ack func()) {
+	d := delivery[T]{batch: batch, ack: ack}
+	if len(batch) == 0 {
+		d.done()
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Once something was spilled, keep spilling until the backlog is gone to preserve order
+	if q.spill != nil && q.spill.Len() > 0 {
+		q.spillBatch(d)
+		return
+	}
+
+	for len(q.batches) >= q.conf.QueueDepth && !q.closed {
+		switch {
+		case q.spill != nil:
+			q.spillBatch(d)
+			return
+		case q.conf.Overflow == config.OVERFLOW_DROP_OLDEST:
+			dropped := q.batches[0]
+			q.batches = q.batches[1:]
+			deliveryDropped.With(q.labels).Add(float64(len(dropped.batch)))
+			dropped.done()
+		default:
+			q.notFull.Wait()
+		}
+	}
+
+	if q.aborted {
+		return
+	}
+	if q.closed {
+		logger.Warn("Delivery queue closed, dropping batch",
+			slog.String("target", q.observer.GetName()),
+			slog.Int("values", len(batch)))
+		deliveryDropped.With(q.labels).Add(float64(len(batch)))
+		d.done()
+		return
+	}
+
+	q.batches = append(q.batches, d)
+	deliveryQueueDepth.With(q.labels).Set(float64(len(q.batches)))
+	q.notEmpty.Signal()
+}
+
+// spillBatch writes the batch to disk. Must be called with the lock held.
+func (q *DeliveryQueue[T]) spillBatch(d delivery[T]) {
+	defer d.done()
+	if err := q.spill.Push(d.batch); err != nil {
+		logger.Error("Failed to spill batch to disk, dropping",
+			slog.String("target", q.observer.GetName()),
+			slog.Any("error", err))
+		deliveryDropped.With(q.labels).Add(float64(len(d.batch)))
+		return
+	}
+	deliverySpilled.With(q.labels).Set(float64(q.spill.Len()))
+	q.notEmpty.Signal()
+}
+
+// next blocks until a batch is available. Returns false when the queue is closed and empty.
+// Spilled batches are always newer than the ones in memory, so memory goes first.
+func (q *DeliveryQueue[T]) next() (d delivery[T], ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if len(q.batches) > 0 {
+			d = q.batches[0]
+			q.batches = q.batches[1:]
+			deliveryQueueDepth.With(q.labels).Set(float64(len(q.batches)))
+			q.notFull.Signal()
+			return d, true
+		}
+		if q.spill != nil && q.spill.Len() > 0 && !q.closed {
+			seq, batch, err := q.spill.Pop()
+			deliverySpilled.With(q.labels).Set(float64(q.spill.Len()))
+			if err != nil {
+				logger.Error("Failed to read spilled batch",
+					slog.String("target", q.observer.GetName()),
+					slog.Any("error", err))
+				continue
+			}
+			return delivery[T]{batch: batch, spilled: true, seq: seq}, true
+		}
+		if q.closed {
+			return d, false
+		}
+		q.notEmpty.Wait()
+	}
+}
+
+func (q *DeliveryQueue[T]) work() {
+	defer q.workers.Done()
+	for {
+		d, ok := q.next()
+		if !ok {
+			return
+		}
+		deliveryInFlight.With(q.labels).Inc()
+		delivered := q.deliver(d.batch)
+		deliveryInFlight.With(q.labels).Dec()
+		if !delivered {
+			q.abandon(d)
+			continue
+		}
+		if d.spilled {
+			q.removeSpilled(d)
+		}
+		d.done()
+	}
+}
+
+// deliver saves the batch, retrying with exponential backoff until the observer accepts it.
+// Once the queue is closed, a failed batch is not retried anymore and false is returned.
+func (q *DeliveryQueue[T]) deliver(batch []T) bool {
+	backoff := MIN_DELIVERY_BACKOFF
+	for !q.save(batch) {
+		deliveryRetries.With(q.labels).Inc()
+		select {
+		case <-q.stop:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, MAX_DELIVERY_BACKOFF)
+	}
+	return true
+}
+
+// removeSpilled deletes a batch read back from the spill store once the observer accepted it.
+func (q *DeliveryQueue[T]) removeSpilled(d delivery[T]) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.spill.Remove(d.seq); err != nil {
+		logger.Error("Failed to remove delivered batch from spill store",
+			slog.String("target", q.observer.GetName()),
+			slog.Any("error", err))
+	}
+}
+
+// abandon leaves a batch the observer did not accept before the queue was closed unacknowledged,
+// so offsets covering it are not committed and it is read again after restart.
+// A batch read back from the spill store stays on disk and is delivered after the next start.
+// If the target was removed, the batch is dropped and acknowledged instead.
+func (q *DeliveryQueue[T]) abandon(d delivery[T]) {
+	if d.spilled {
+		logger.Warn("Target did not accept spilled batch before closing, keeping it on disk",
+			slog.String("target", q.observer.GetName()),
+			slog.Int("values", len(d.batch)))
+		return
+	}
+	q.mu.Lock()
+	removed := q.removed
+	q.mu.Unlock()
+	if removed {
+		logger.Warn("Removed target did not accept batch, dropping it",
+			slog.String("target", q.observer.GetName()),
+			slog.Int("values", len(d.batch)))
+		deliveryDropped.With(q.labels).Add(float64(len(d.batch)))
+		d.done()
+		return
+	}
+	logger.Warn("Target did not accept batch before closing, leaving it unacknowledged",
+		slog.String("target", q.observer.GetName()),
+		slog.Int("values", len(d.batch)))
+}
+
+func (q *DeliveryQueue[T]) save(batch []T) bool {
+	switch b := any(batch).(type) {
+	case []zbxpkg.History:
+		return q.observer.SaveHistory(b)
+	case []zbxpkg.Trend:
+		return q.observer.SaveTrends(b)
+	case []zbxpkg.Event:
+		return q.observer.SaveEvents(b)
+	}
+	return false
+}
+
+// Close stops accepting new batches and waits until the ones in memory are delivered.
+// Values held by sampling rules are queued first.
+// Batches are not retried anymore, the ones the observer fails to accept are left unacknowledged.
+// Spilled batches stay on disk and are delivered after the next start.
+func (q *DeliveryQueue[T]) Close() {
+	q.flushSamples.Do(func() {
+		if q.stopSampling != nil {
+			close(q.stopSampling)
+			<-q.samplingDone
+		}
+		if q.sampler != nil {
+			flushed := q.sampler.flush()
+			q.enqueue(flushed.values, flushed.ack)
+		}
+	})
+
+	q.mu.Lock()
+	if !q.closed {
+		close(q.stop)
+	}
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+	q.mu.Unlock()
+
+	q.workers.Wait()
+}
+
+// Remove closes the queue of a target removed from the config. Unlike with Close, batches
+// the observer fails to accept are dropped and acknowledged: no target delivers them after
+// restart, and leaving them unacknowledged would hold back offsets of every other target.
+func (q *DeliveryQueue[T]) Remove() {
+	q.mu.Lock()
+	q.removed = true
+	q.mu.Unlock()
+	q.Close()
+}
+
+// Abort closes the queue without delivering batches left in memory, e.g. when the shutdown
+// timeout is exceeded. They and batches enqueued from now on are not acknowledged, so values
+// read from files are read again after restart. Batches being delivered are finished,
+// Close waits for them.
+func (q *DeliveryQueue[T]) Abort() {
+	q.mu.Lock()
+	if !q.closed {
+		close(q.stop)
+	}
+	q.closed = true
+	q.aborted = true
+	abandoned := 0
+	for _, d := range q.batches {
+		abandoned += len(d.batch)
+	}
+	q.batches = nil
+	deliveryQueueDepth.With(q.labels).Set(0)
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+	q.mu.Unlock()
+
+	if abandoned > 0 {
+		logger.Warn("Delivery queue aborted, leaving batches unacknowledged",
+			slog.String("target", q.observer.GetName()),
+			slog.Int("values", abandoned))
+	}
+}
@@ -0,0 +1,204 @@
+package input
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// ackRecorder records the order batches were acknowledged in.
+type ackRecorder struct {
+	mu    sync.Mutex
+	order []int64
+}
+
+func (a *ackRecorder) ack(item int64) func() {
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.order = append(a.order, item)
+	}
+}
+
+func (a *ackRecorder) acked() []int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]int64(nil), a.order...)
+}
+
+func items(history []zbxpkg.History) []int64 {
+	var ids []int64
+	for _, h := range history {
+		ids = append(ids, h.ItemID)
+	}
+	return ids
+}
+
+func newBlockingObserver(name string) *blockingObserver {
+	return &blockingObserver{fakeObserver: fakeObserver{name: name}, release: make(chan struct{})}
+}
+
+// fillQueue enqueues a batch the only worker is stuck saving and a batch filling the queue of depth 1.
+func fillQueue(t *testing.T, q *DeliveryQueue[zbxpkg.History], observer *blockingObserver, acks *ackRecorder) {
+	t.Helper()
+	q.Enqueue([]zbxpkg.History{{ItemID: 1}}, acks.ack(1))
+	require.Eventually(t, func() bool { return observer.saving.Load() == 1 }, time.Second, time.Millisecond)
+	q.Enqueue([]zbxpkg.History{{ItemID: 2}}, acks.ack(2))
+}
+
+func TestDeliveryQueue_Block(t *testing.T) {
+	observer := newBlockingObserver("block")
+	q := NewDeliveryQueue[zbxpkg.History](observer, config.DeliveryConf{Workers: 1, QueueDepth: 1, Overflow: config.OVERFLOW_BLOCK})
+	acks := &ackRecorder{}
+	fillQueue(t, q, observer, acks)
+
+	enqueued := make(chan struct{})
+	go func() {
+		defer close(enqueued)
+		q.Enqueue([]zbxpkg.History{{ItemID: 3}}, acks.ack(3))
+	}()
+	select {
+	case <-enqueued:
+		t.Fatal("enqueue did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.Empty(t, acks.acked(), "nothing is acknowledged before it was delivered")
+
+	close(observer.release)
+	<-enqueued
+	q.Close()
+
+	require.Equal(t, []int64{1, 2, 3}, items(observer.history))
+	require.Equal(t, []int64{1, 2, 3}, acks.acked())
+}
+
+func TestDeliveryQueue_DropOldest(t *testing.T) {
+	observer := newBlockingObserver("drop_oldest")
+	conf := config.DeliveryConf{Workers: 1, QueueDepth: 1, Overflow: config.OVERFLOW_DROP_OLDEST}
+	q := NewDeliveryQueue[zbxpkg.History](observer, conf)
+	dropped := deliveryDropped.With(prometheus.Labels{"target_name": observer.name, "export_type": zbxpkg.HISTORY})
+	before := counterValue(t, dropped)
+	acks := &ackRecorder{}
+	fillQueue(t, q, observer, acks)
+
+	// the queued batch makes room and is acknowledged, as it will never be delivered
+	q.Enqueue([]zbxpkg.History{{ItemID: 3}, {ItemID: 4}}, acks.ack(3))
+	require.Equal(t, []int64{2}, acks.acked())
+	require.Equal(t, before+1, counterValue(t, dropped))
+
+	close(observer.release)
+	q.Close()
+
+	require.Equal(t, []int64{1, 3, 4}, items(observer.history))
+	require.Equal(t, []int64{2, 1, 3}, acks.acked())
+}
+
+func TestDeliveryQueue_Spill(t *testing.T) {
+	observer := newBlockingObserver("spill")
+	conf := config.DeliveryConf{Workers: 1, QueueDepth: 1, Overflow: config.OVERFLOW_SPILL, SpillDir: t.TempDir()}
+	q := NewDeliveryQueue[zbxpkg.History](observer, conf)
+	acks := &ackRecorder{}
+	fillQueue(t, q, observer, acks)
+
+	// batches over the depth are acknowledged once they are on disk
+	q.Enqueue([]zbxpkg.History{{ItemID: 3}}, acks.ack(3))
+	q.Enqueue([]zbxpkg.History{{ItemID: 4}}, acks.ack(4))
+	require.Equal(t, []int64{3, 4}, acks.acked())
+	require.Equal(t, 2, q.spill.Len())
+
+	close(observer.release)
+	require.Eventually(t, func() bool {
+		observer.mu.Lock()
+		defer observer.mu.Unlock()
+		return len(observer.history) == 4
+	}, time.Second, time.Millisecond)
+	q.Close()
+
+	// memory first, then spilled batches in the order they were spilled
+	require.Equal(t, []int64{1, 2, 3, 4}, items(observer.history))
+	require.Equal(t, []int64{3, 4, 1, 2}, acks.acked())
+	require.Zero(t, q.spill.Len())
+}
+
+func TestDeliveryQueue_SpillSurvivesRestart(t *testing.T) {
+	observer := newBlockingObserver("spill_restart")
+	conf := config.DeliveryConf{Workers: 1, QueueDepth: 1, Overflow: config.OVERFLOW_SPILL, SpillDir: t.TempDir()}
+	q := NewDeliveryQueue[zbxpkg.History](observer, conf)
+	acks := &ackRecorder{}
+	fillQueue(t, q, observer, acks)
+	q.Enqueue([]zbxpkg.History{{ItemID: 3}}, acks.ack(3))
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		q.Close()
+	}()
+	require.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.closed
+	}, time.Second, time.Millisecond)
+	close(observer.release)
+	<-closed
+
+	// batches in memory are delivered on close, spilled ones stay on disk
+	require.Equal(t, []int64{1, 2}, items(observer.history))
+
+	restarted := &fakeObserver{name: "spill_restart"}
+	q = NewDeliveryQueue[zbxpkg.History](restarted, conf)
+	require.Eventually(t, func() bool {
+		restarted.mu.Lock()
+		defer restarted.mu.Unlock()
+		return len(restarted.history) == 1
+	}, time.Second, time.Millisecond)
+	q.Close()
+	require.Equal(t, []int64{3}, items(restarted.history))
+}
+
+func TestDeliveryQueue_SpilledBatchKeptUntilAccepted(t *testing.T) {
+	observer := newBlockingObserver("spill_kept")
+	conf := config.DeliveryConf{Workers: 1, QueueDepth: 1, Overflow: config.OVERFLOW_SPILL, SpillDir: t.TempDir()}
+	q := NewDeliveryQueue[zbxpkg.History](observer, conf)
+	acks := &ackRecorder{}
+	fillQueue(t, q, observer, acks)
+	q.Enqueue([]zbxpkg.History{{ItemID: 3}}, acks.ack(3))
+	spilled := func() int {
+		entries, err := os.ReadDir(filepath.Join(conf.SpillDir, zbxpkg.HISTORY))
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	// batches in memory go through, the spilled one is being saved
+	observer.release <- struct{}{}
+	observer.release <- struct{}{}
+	require.Eventually(t, func() bool {
+		observer.mu.Lock()
+		defer observer.mu.Unlock()
+		return len(observer.history) == 2 && observer.saving.Load() == 1
+	}, time.Second, time.Millisecond)
+	require.Zero(t, q.spill.Len())
+	require.Equal(t, 1, spilled(), "spilled batch removed before the target accepted it")
+
+	observer.release <- struct{}{}
+	require.Eventually(t, func() bool { return spilled() == 0 }, time.Second, time.Millisecond)
+	q.Close()
+	require.Equal(t, []int64{1, 2, 3}, items(observer.history))
+}
+
+func TestDeliveryQueue_EmptyBatchAcknowledged(t *testing.T) {
+	observer := &fakeObserver{name: "empty"}
+	q := NewDeliveryQueue[zbxpkg.History](observer, config.DeliveryConf{Workers: 1, QueueDepth: 1})
+	acks := &ackRecorder{}
+
+	q.Enqueue(nil, acks.ack(1))
+	require.Equal(t, []int64{1}, acks.acked())
+	q.Close()
+	require.Empty(t, observer.history)
+}
@@ -0,0 +1,34 @@
+package input
+
+import (
+	"encoding/json"
+
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// exportType bundles generic functions handling a single zbxpkg.Export type,
+// so every input supports the same set of exports.
+type exportType struct {
+	newSubject func() Subjecter
+	parse      func(line []byte) (any, error)
+}
+
+func newExportType[T zbxpkg.Export]() exportType {
+	return exportType{
+		newSubject: func() Subjecter {
+			return NewSubject[T]()
+		},
+		parse: func(line []byte) (any, error) {
+			var t T
+			err := json.Unmarshal(line, &t)
+			return t, err
+		},
+	}
+}
+
+// exportTypes lists all supported exports by name.
+var exportTypes = map[string]exportType{
+	zbxpkg.HISTORY: newExportType[zbxpkg.History](),
+	zbxpkg.TREND:   newExportType[zbxpkg.Trend](),
+	zbxpkg.EVENT:   newExportType[zbxpkg.Event](),
+}
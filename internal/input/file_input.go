@@ -1,23 +1,32 @@
 package input
 
 import (
-	"encoding/binary"
+	"fmt"
 	"log/slog"
 	"path"
+	"sync"
+	"time"
 
 	badger "github.com/dgraph-io/badger/v4"
-	"github.com/nxadm/tail"
 	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/deadletter"
 	"zms.szuro.net/internal/logger"
 	"zms.szuro.net/internal/zbx"
-	zbxpkg "zms.szuro.net/pkg/zbx"
 )
 
 type FileInput struct {
 	baseInput
-	activeTails []*tail.Tail
-	fileIndex   *badger.DB // BadgerDB instance for offline buffering
-	zbxConf     zbx.ZabbixConf
+	mu              sync.Mutex
+	watcher         *zbx.ExportWatcher // nil while paused
+	funnels         map[string]chan any
+	ha              *HAWatcher
+	fileIndex       *badger.DB        // BadgerDB instance for offline buffering
+	deadLetters     *deadletter.Store // nil if disabled
+	zbxConf         zbx.ZabbixConf
+	detector        zbx.HADetector
+	checkpointers   Checkpointers
+	stopCheckpoints chan struct{}
+	checkpointsDone chan struct{}
 }
 
 func NewFileInput(zbxConf zbx.ZabbixConf, zmsConf config.ZMSConf) (fi *FileInput, err error) {
@@ -33,13 +42,19 @@ func NewFileInput(zbxConf zbx.ZabbixConf, zmsConf config.ZMSConf) (fi *FileInput
 		logger.Error("Failed to open BadgerDB for file index", slog.Any("error", err))
 	}
 	fi.fileIndex = db
+	if err != nil {
+		return
+	}
 
-	return
-}
+	if !zmsConf.DeadLetter.Disabled {
+		fi.deadLetters, err = deadletter.Open(zmsConf.DeadLetter)
+		if err != nil {
+			return
+		}
+	}
 
-func (fi *FileInput) IsReady() bool {
-	_, isActive := zbx.GetHaStatus(fi.zbxConf)
-	return isActive
+	fi.detector, err = zbx.NewHADetector(zmsConf.HA, zbxConf)
+	return
 }
 
 func (fi *FileInput) Prepare() {
@@ -47,64 +62,116 @@ func (fi *FileInput) Prepare() {
 	fi.baseInput.Prepare()
 }
 
+// Start starts processing values. Export files are read only while the
+// Zabbix server node is active, the HA status is checked in the background.
 func (fi *FileInput) Start() {
 	fi.baseInput.Start()
+	fi.stopCheckpoints = make(chan struct{})
+	fi.checkpointsDone = make(chan struct{})
+	go fi.checkpoint()
+
+	fi.ha = NewHAWatcher(fi.detector, fi, fi.config.HA)
+	fi.ha.Start()
+}
+
+// Pause stops following export files and saves offsets acknowledged so far.
+// Values already read are still delivered to targets.
+func (fi *FileInput) Pause() {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if fi.watcher == nil {
+		return
+	}
+	fi.watcher.Stop()
+	fi.watcher = nil
+	if err := fi.checkpointers.Persist(fi.fileIndex); err != nil {
+		logger.Error("error when saving file offsets", slog.Any("error", err))
+	}
+}
+
+// Resume follows export files again from the last acknowledged offsets.
+func (fi *FileInput) Resume() error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if fi.watcher != nil {
+		return nil
+	}
+	// offsets acknowledged since pausing are where reading continues
+	if err := fi.checkpointers.Persist(fi.fileIndex); err != nil {
+		return err
+	}
+	watcher := zbx.NewExportWatcher(fi.zbxConf, fi.fileIndex, fi.deadLetters, fi.funnels)
+	if err := watcher.Start(); err != nil {
+		return fmt.Errorf("failed to watch export directory %s: %w", fi.zbxConf.ExportDir, err)
+	}
+	fi.watcher = watcher
+	return nil
 }
 
+// Stop stops reading files, delivers values read so far within the shutdown timeout
+// and saves offsets acknowledged by all targets before observers are cleaned up.
+// Values that were read but not delivered in time are read again after restart.
 func (fi *FileInput) Stop() error {
-	for _, f := range fi.activeTails {
-		offset, err := f.Tell()
-		if err != nil {
-			logger.Error("cannot get file offset, resetting to 0", slog.String("file", f.Filename), slog.Any("error", err))
-			offset = 0
-		}
-		f.Stop()
-		f.Cleanup()
+	if fi.ha != nil {
+		fi.ha.Stop()
+	}
+	fi.Pause()
+	if fi.checkpointsDone != nil {
+		close(fi.stopCheckpoints)
+		<-fi.checkpointsDone
+	}
 
-		err = fi.fileIndex.Update(func(txn *badger.Txn) error {
-			err := txn.Set([]byte(f.Filename), int64ToBytes(offset))
-			return err
-		})
-		if err != nil {
-			logger.Error("error when saving file offset", slog.String("file", f.Filename), slog.Any("error", err))
-		}
+	err := fi.drainWithTimeout()
 
+	if err := fi.checkpointers.Persist(fi.fileIndex); err != nil {
+		logger.Error("error when saving file offsets", slog.Any("error", err))
 	}
+	fi.cleanup()
 	fi.fileIndex.Close()
-	return fi.baseInput.Stop()
+	if fi.deadLetters != nil {
+		fi.deadLetters.Close()
+	}
+	return err
+}
 
+// checkpoint periodically saves acknowledged offsets, so a crash does not lose track of files.
+func (fi *FileInput) checkpoint() {
+	defer close(fi.checkpointsDone)
+	ticker := time.NewTicker(fi.config.Checkpoint)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fi.stopCheckpoints:
+			return
+		case <-ticker.C:
+			if err := fi.checkpointers.Persist(fi.fileIndex); err != nil {
+				logger.Error("error when saving file offsets", slog.Any("error", err))
+			}
+		}
+	}
 }
 
 func (fi *FileInput) mkSubjects() {
 	zabbix := fi.zbxConf
-	var files []*tail.Tail
+	fi.funnels = make(map[string]chan any)
+	fi.checkpointers = make(Checkpointers)
 	for _, v := range zabbix.ExportTypes {
-		switch v {
-		case zbxpkg.HISTORY:
-			hs := NewSubject[zbxpkg.History]()
-			hs.Funnel, files = zbx.FileReaderGenerator[zbxpkg.History](zabbix, fi.fileIndex, fi.config.BufferSize*2)
-			fi.subjects[zbxpkg.HISTORY] = &hs
-		case zbxpkg.TREND:
-			ts := NewSubject[zbxpkg.Trend]()
-			ts.Funnel, files = zbx.FileReaderGenerator[zbxpkg.Trend](zabbix, fi.fileIndex, fi.config.BufferSize*2)
-			fi.subjects[zbxpkg.TREND] = &ts
-		case zbxpkg.EVENT:
-			ts := NewSubject[zbxpkg.Event]()
-			ts.Funnel, files = zbx.FileReaderGenerator[zbxpkg.Event](zabbix, fi.fileIndex, fi.config.BufferSize*2)
-			fi.subjects[zbxpkg.EVENT] = &ts
-		default:
+		export, ok := exportTypes[v]
+		if !ok {
 			logger.Error("Export not supported", slog.String("export", v))
+			continue
 		}
+		subject := export.newSubject()
+		fi.funnels[v] = make(chan any, fi.config.BufferSize*2)
+		subject.SetFunnel(fi.funnels[v])
+		fi.subjects[v] = subject
 	}
-	fi.activeTails = append(fi.activeTails, files...)
 
-	for _, subject := range fi.subjects {
+	for name, subject := range fi.subjects {
 		subject.SetBuffer(fi.config.BufferSize)
+		subject.SetFlushPolicy(fi.config.Flush.PolicyFor(name))
+		subject.SetShards(fi.config.Shards)
+		fi.checkpointers[name] = NewCheckpointer()
+		subject.SetCheckpointer(fi.checkpointers[name])
 	}
 }
-
-func int64ToBytes(i int64) []byte {
-	bytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(bytes, uint64(i))
-	return bytes
-}
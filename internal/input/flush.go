@@ -0,0 +1,70 @@
+package input
+
+import (
+	"encoding/json"
+	"fmt"
+
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// Reasons reported by zms_buffer_flushes_total
+const (
+	FLUSH_RECORDS = "records"
+	FLUSH_AGE     = "age"
+	FLUSH_BYTES   = "bytes"
+	FLUSH_CLOSED  = "closed"
+)
+
+// Fixed cost of numeric fields of a record, used for size estimation
+const recordOverhead = 64
+
+// approxSize estimates how much memory a record takes in the buffer.
+// It does not need to be exact, only stable and cheap to compute.
+func approxSize(v any) (size int) {
+	size = recordOverhead
+	switch v := v.(type) {
+	case zbxpkg.History:
+		size += hostSize(v.Host) + len(v.Name) + len(v.Source)
+		size += stringsSize(v.Groups) + tagsSize(v.Tags)
+		switch value := v.Value.(type) {
+		case string:
+			size += len(value)
+		case json.Number:
+			size += len(value)
+		case nil:
+		default:
+			size += len(fmt.Sprint(value))
+		}
+	case zbxpkg.Trend:
+		size += hostSize(v.Host) + len(v.Name)
+		size += stringsSize(v.Groups) + tagsSize(v.Tags)
+	case zbxpkg.Event:
+		size += len(v.Name)
+		for _, h := range v.Hosts {
+			size += hostSize(&h)
+		}
+		size += stringsSize(v.Groups) + tagsSize(v.Tags)
+	}
+	return
+}
+
+func hostSize(h *zbxpkg.Host) int {
+	if h == nil {
+		return 0
+	}
+	return len(h.Host) + len(h.Name)
+}
+
+func stringsSize(s []string) (size int) {
+	for _, v := range s {
+		size += len(v)
+	}
+	return
+}
+
+func tagsSize(tags []zbxpkg.Tag) (size int) {
+	for _, t := range tags {
+		size += len(t.Tag) + len(t.Value)
+	}
+	return
+}
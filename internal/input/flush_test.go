@@ -0,0 +1,102 @@
+package input
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/pkg/filter"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func TestApproxSize(t *testing.T) {
+	h := zbxpkg.History{ItemID: 1, Value: json.Number("1")}
+	require.Equal(t, recordOverhead+1, approxSize(h))
+	require.Equal(t, approxSize(h), approxSize(h), "size must be stable")
+
+	h.Host = &zbxpkg.Host{Host: "db-1", Name: "Database"}
+	h.Tags = []zbxpkg.Tag{{Tag: "env", Value: "prod"}}
+	h.Groups = []string{"Linux"}
+	require.Equal(t, recordOverhead+1+len("db-1Database")+len("envprod")+len("Linux"), approxSize(h))
+
+	text := zbxpkg.History{Value: "some log line"}
+	require.Equal(t, recordOverhead+len("some log line"), approxSize(text))
+
+	event := zbxpkg.Event{Name: "problem", Hosts: []zbxpkg.Host{{Host: "a"}, {Host: "b"}}}
+	require.Equal(t, recordOverhead+len("problem")+2, approxSize(event))
+}
+
+// startFlushSubject runs a single shard subject flushing by the policy.
+func startFlushSubject(t *testing.T, observer config.Observer, policy config.FlushPolicy) (*Subject[zbxpkg.History], chan struct{}) {
+	t.Helper()
+	s := NewSubject[zbxpkg.History]()
+	s.SetFunnel(make(chan any, 10))
+	s.SetBuffer(100)
+	s.SetFlushPolicy(policy)
+	s.SetFilter(filter.NewTagFilter(filter.FilterConfig{}))
+	s.Register(observer, config.DeliveryConf{Workers: 1, QueueDepth: 10})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.AcceptValues()
+	}()
+	return s, done
+}
+
+func flushes(t *testing.T, reason string) float64 {
+	return counterValue(t, bufferFlushes.With(prometheus.Labels{"export_type": zbxpkg.HISTORY, "reason": reason}))
+}
+
+func TestSubject_FlushPolicy(t *testing.T) {
+	value := zbxpkg.History{ItemID: 1, Value: json.Number("1")}
+	tests := map[string]struct {
+		policy config.FlushPolicy
+		values int
+	}{
+		FLUSH_RECORDS: {policy: config.FlushPolicy{MaxRecords: 2}, values: 2},
+		FLUSH_BYTES:   {policy: config.FlushPolicy{MaxBytes: 2 * approxSize(value)}, values: 2},
+		FLUSH_AGE:     {policy: config.FlushPolicy{MaxAge: 20 * time.Millisecond}, values: 1},
+	}
+	for reason, tt := range tests {
+		t.Run(reason, func(t *testing.T) {
+			observer := &fakeObserver{name: reason}
+			before := flushes(t, reason)
+			s, done := startFlushSubject(t, observer, tt.policy)
+
+			for range tt.values {
+				s.Funnel <- value
+			}
+			require.Eventually(t, func() bool {
+				observer.mu.Lock()
+				defer observer.mu.Unlock()
+				return len(observer.history) == tt.values
+			}, time.Second, time.Millisecond)
+			require.Equal(t, before+1, flushes(t, reason))
+
+			close(s.Funnel)
+			<-done
+			s.Drain()
+		})
+	}
+}
+
+func TestSubject_FlushOnClose(t *testing.T) {
+	observer := &fakeObserver{name: FLUSH_CLOSED}
+	before := flushes(t, FLUSH_CLOSED)
+	s, done := startFlushSubject(t, observer, config.FlushPolicy{})
+
+	s.Funnel <- zbxpkg.History{ItemID: 1}
+	time.Sleep(10 * time.Millisecond)
+	observer.mu.Lock()
+	require.Empty(t, observer.history, "no limit reached, values wait in the buffer")
+	observer.mu.Unlock()
+
+	close(s.Funnel)
+	<-done
+	s.Drain()
+	require.Len(t, observer.history, 1)
+	require.Equal(t, before+1, flushes(t, FLUSH_CLOSED))
+}
@@ -0,0 +1,165 @@
+package input
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/logger"
+	"zms.szuro.net/internal/zbx"
+)
+
+const (
+	HA_ROLE_ACTIVE  = "active"
+	HA_ROLE_STANDBY = "standby"
+)
+
+var (
+	haActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zms_ha_active",
+		Help: "Whether the Zabbix server node is active and export files are read",
+	})
+	haTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zms_ha_transitions_total",
+		Help: "The total number of HA role changes of the Zabbix server node",
+	}, []string{"from", "to"})
+	haCheckErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zms_ha_check_errors_total",
+		Help: "The total number of failed HA status checks",
+	})
+)
+
+// Pausable is an input that reads only while the Zabbix server node is active.
+type Pausable interface {
+	// Pause stops reading and saves acknowledged positions.
+	Pause()
+	// Resume starts reading again from the saved positions.
+	Resume() error
+}
+
+// HAWatcher periodically checks the HA status of the Zabbix server node,
+// pausing the input when the node goes standby and resuming it once it is active again.
+// A failed check keeps the current role.
+type HAWatcher struct {
+	detector zbx.HADetector
+	input    Pausable
+	interval time.Duration
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	active bool
+	known  bool // the role was checked at least once
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewHAWatcher(detector zbx.HADetector, input Pausable, conf config.HAConf) *HAWatcher {
+	return &HAWatcher{
+		detector: detector,
+		input:    input,
+		interval: conf.CheckInterval,
+		timeout:  conf.Timeout,
+	}
+}
+
+// Start checks the status once, then keeps checking in the background.
+// The input starts paused and is resumed only if the node is active.
+func (w *HAWatcher) Start() {
+	haActive.Set(0)
+	w.Check()
+
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.watch()
+}
+
+// Stop stops checking the status. The input is left in its current state.
+func (w *HAWatcher) Stop() {
+	if w.stop == nil {
+		return
+	}
+	close(w.stop)
+	<-w.done
+}
+
+// Active reports if the node was active at the last successful check.
+func (w *HAWatcher) Active() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.active
+}
+
+func (w *HAWatcher) watch() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.Check()
+		}
+	}
+}
+
+// Check gets the HA status and pauses or resumes the input if the role changed.
+func (w *HAWatcher) Check() {
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+	status, err := w.detector.Status(ctx)
+	if err != nil {
+		haCheckErrors.Inc()
+		logger.Error("Failed to get HA status", slog.Any("error", err))
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	active := status.Active()
+	if w.known && active == w.active {
+		return
+	}
+	if !w.known && !active {
+		w.known = true
+		logger.Info("Node is not active, waiting", slog.String("node", status.Node.Name), slog.String("status", status.Node.Status))
+		return
+	}
+
+	from, to := haRole(w.active), haRole(active)
+	attrs := []any{slog.String("node", status.Node.Name), slog.String("status", status.Node.Status), slog.String("from", from), slog.String("to", to)}
+	if active {
+		if err := w.input.Resume(); err != nil {
+			// retried with the next check
+			logger.Error("Failed to resume input", append(attrs, slog.Any("error", err))...)
+			return
+		}
+		logger.Info("Node is active, resumed reading exports", attrs...)
+	} else {
+		w.input.Pause()
+		logger.Info("Node is not active, paused reading exports", attrs...)
+	}
+
+	if w.known {
+		haTransitions.WithLabelValues(from, to).Inc()
+	}
+	w.active = active
+	w.known = true
+	if active {
+		haActive.Set(1)
+	} else {
+		haActive.Set(0)
+	}
+}
+
+func haRole(active bool) string {
+	if active {
+		return HA_ROLE_ACTIVE
+	}
+	return HA_ROLE_STANDBY
+}
@@ -0,0 +1,143 @@
+package input
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/zbx"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+type fakeDetector struct {
+	status zbx.HAStatus
+	err    error
+}
+
+func (d *fakeDetector) Status(ctx context.Context) (zbx.HAStatus, error) {
+	return d.status, d.err
+}
+
+func (d *fakeDetector) set(status string, err error) {
+	d.status = zbx.HAStatus{Node: zbx.NodeInfo{Name: "node1", Status: status}}
+	d.err = err
+}
+
+type fakePausable struct {
+	paused, resumed int
+	resumeErr       error
+}
+
+func (p *fakePausable) Pause() {
+	p.paused++
+}
+
+func (p *fakePausable) Resume() error {
+	if p.resumeErr != nil {
+		return p.resumeErr
+	}
+	p.resumed++
+	return nil
+}
+
+func TestHAWatcher_Check(t *testing.T) {
+	detector := &fakeDetector{}
+	input := &fakePausable{}
+	w := NewHAWatcher(detector, input, config.HAConf{Timeout: time.Second, CheckInterval: time.Hour})
+	promoted := counterValue(t, haTransitions.WithLabelValues(HA_ROLE_STANDBY, HA_ROLE_ACTIVE))
+	demoted := counterValue(t, haTransitions.WithLabelValues(HA_ROLE_ACTIVE, HA_ROLE_STANDBY))
+
+	// starts paused
+	detector.set(zbx.NODE_STANDBY, nil)
+	w.Check()
+	require.False(t, w.Active())
+	require.Equal(t, 0, input.paused+input.resumed)
+
+	detector.set(zbx.NODE_ACTIVE, nil)
+	w.Check()
+	require.True(t, w.Active())
+	require.Equal(t, 1, input.resumed)
+
+	// failed checks keep the role
+	detector.set("", errors.New("database is down"))
+	w.Check()
+	require.True(t, w.Active())
+
+	detector.set(zbx.NODE_STANDBY, nil)
+	w.Check()
+	require.False(t, w.Active())
+	require.Equal(t, 1, input.paused)
+
+	// resuming is retried
+	detector.set(zbx.NODE_ACTIVE, nil)
+	input.resumeErr = errors.New("export directory missing")
+	w.Check()
+	require.False(t, w.Active())
+	input.resumeErr = nil
+	w.Check()
+	require.True(t, w.Active())
+	require.Equal(t, 2, input.resumed)
+
+	require.Equal(t, promoted+2, counterValue(t, haTransitions.WithLabelValues(HA_ROLE_STANDBY, HA_ROLE_ACTIVE)))
+	require.Equal(t, demoted+1, counterValue(t, haTransitions.WithLabelValues(HA_ROLE_ACTIVE, HA_ROLE_STANDBY)))
+}
+
+func appendHistoryLines(t *testing.T, path string, first, last int) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	defer f.Close()
+	for id := first; id <= last; id++ {
+		_, err := fmt.Fprintf(f, `{"itemid":%d,"clock":1,"ns":0,"value":1,"type":3}`+"\n", id)
+		require.NoError(t, err)
+	}
+}
+
+func TestFileInput_PauseResume(t *testing.T) {
+	exportDir := t.TempDir()
+	export := filepath.Join(exportDir, "history-history-syncer-1.ndjson")
+	appendHistoryLines(t, export, 1, 3)
+
+	zbxConf := zbx.ZabbixConf{ExportDir: exportDir, ExportTypes: []string{zbxpkg.HISTORY}, ExportFileSize: zbx.DEFAULT_EXPORT_FILE_SIZE}
+	zmsConf := config.ZMSConf{
+		BufferSize: 1,
+		DataDir:    t.TempDir(),
+		Checkpoint: time.Hour,
+		Flush:      config.FlushConf{FlushPolicy: config.FlushPolicy{MaxRecords: 1, MaxAge: time.Hour}},
+		HA:         config.HAConf{Detector: config.HA_STATIC, Timeout: time.Second, CheckInterval: time.Hour},
+		DeadLetter: config.DeadLetterConf{Dir: t.TempDir(), MaxFileSize: 1 << 20, MaxSize: 1 << 20},
+	}
+	fi, err := NewFileInput(zbxConf, zmsConf)
+	require.NoError(t, err)
+	detector := &fakeDetector{}
+	detector.set(zbx.NODE_ACTIVE, nil)
+	fi.detector = detector
+	fi.Prepare()
+	fi.Start()
+	defer fi.Stop()
+
+	committed := func() int64 {
+		pos, _ := fi.checkpointers[zbxpkg.HISTORY].Committed(export)
+		return pos.Offset
+	}
+	line := int64(len(`{"itemid":1,"clock":1,"ns":0,"value":1,"type":3}` + "\n"))
+	require.Eventually(t, func() bool { return committed() == 3*line }, 5*time.Second, 10*time.Millisecond)
+
+	// standby node does not read
+	detector.set(zbx.NODE_STANDBY, nil)
+	fi.ha.Check()
+	appendHistoryLines(t, export, 4, 5)
+	time.Sleep(3 * zbx.FOLLOW_POLL_INTERVAL)
+	require.Equal(t, 3*line, committed())
+
+	// promoted node continues from the checkpoint
+	detector.set(zbx.NODE_ACTIVE, nil)
+	fi.ha.Check()
+	require.Eventually(t, func() bool { return committed() == 5*line }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,193 @@
+package input
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/crypto/bcrypt"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/logger"
+)
+
+// Reasons reported by zms_http_auth_rejected_total
+const (
+	AUTH_MISSING      = "missing_credentials"
+	AUTH_TOKEN        = "invalid_token"
+	AUTH_BASIC        = "invalid_basic"
+	AUTH_CERT         = "invalid_certificate"
+	AUTH_UNSUPPORTED  = "unsupported_scheme"
+	WWW_AUTHENTICATE  = "WWW-Authenticate"
+	AUTH_REALM        = `realm="zms"`
+	BEARER_PREFIX     = "Bearer "
+	AUTHORIZATION_HDR = "Authorization"
+)
+
+var authRejected = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "zms_http_auth_rejected_total",
+		Help: "Total number of rejected HTTP authentication attempts per endpoint and reason",
+	},
+	[]string{"endpoint", "reason"},
+)
+
+// Authenticator checks credentials of HTTP requests against HTTPAuthConf.
+type Authenticator struct {
+	conf   config.HTTPAuthConf
+	tokens [][]byte
+	users  map[string][]byte
+
+	// verified caches SHA-256 of passwords that already passed bcrypt,
+	// so that every push from Zabbix does not pay the bcrypt cost.
+	mu       sync.RWMutex
+	verified map[string][32]byte
+}
+
+func NewAuthenticator(conf config.HTTPAuthConf) *Authenticator {
+	a := &Authenticator{
+		conf:     conf,
+		users:    make(map[string][]byte, len(conf.BasicUsers)),
+		verified: make(map[string][32]byte),
+	}
+	for _, t := range conf.BearerTokens {
+		a.tokens = append(a.tokens, []byte(t))
+	}
+	for _, u := range conf.BasicUsers {
+		a.users[u.Username] = []byte(u.PasswordHash)
+	}
+	return a
+}
+
+// Wrap returns a handler that passes only authenticated requests to next.
+// If authentication is not configured, next is returned unchanged.
+func (a *Authenticator) Wrap(endpoint string, next http.Handler) http.Handler {
+	if !a.conf.Enabled() {
+		return next
+	}
+	for _, reason := range []string{AUTH_MISSING, AUTH_TOKEN, AUTH_BASIC, AUTH_CERT, AUTH_UNSUPPORTED} {
+		authRejected.WithLabelValues(endpoint, reason).Add(0)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, reason := a.authenticate(r)
+		if !ok {
+			authRejected.WithLabelValues(endpoint, reason).Inc()
+			logger.Warn("Rejected HTTP request",
+				slog.String("endpoint", endpoint),
+				slog.String("remote", r.RemoteAddr),
+				slog.String("reason", reason))
+			a.challenge(w)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Authenticator) challenge(w http.ResponseWriter) {
+	if len(a.tokens) > 0 {
+		w.Header().Add(WWW_AUTHENTICATE, "Bearer "+AUTH_REALM)
+	}
+	if len(a.users) > 0 {
+		w.Header().Add(WWW_AUTHENTICATE, "Basic "+AUTH_REALM)
+	}
+}
+
+// authenticate returns true if any configured method accepts the request.
+// Otherwise it returns the reason of the most specific failure.
+func (a *Authenticator) authenticate(r *http.Request) (bool, string) {
+	reason := AUTH_MISSING
+
+	if a.conf.ClientCert {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			if a.certAllowed(r) {
+				return true, ""
+			}
+			reason = AUTH_CERT
+		} else if len(a.tokens) == 0 && len(a.users) == 0 {
+			return false, AUTH_CERT
+		}
+	}
+
+	header := r.Header.Get(AUTHORIZATION_HDR)
+	if header == "" {
+		return false, reason
+	}
+
+	if strings.HasPrefix(header, BEARER_PREFIX) {
+		if len(a.tokens) == 0 {
+			return false, AUTH_UNSUPPORTED
+		}
+		if a.tokenValid(strings.TrimPrefix(header, BEARER_PREFIX)) {
+			return true, ""
+		}
+		return false, AUTH_TOKEN
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		if len(a.users) == 0 {
+			return false, AUTH_UNSUPPORTED
+		}
+		if a.passwordValid(username, password) {
+			return true, ""
+		}
+		return false, AUTH_BASIC
+	}
+
+	return false, AUTH_UNSUPPORTED
+}
+
+func (a *Authenticator) tokenValid(token string) bool {
+	valid := false
+	for _, t := range a.tokens {
+		// check every token to keep timing independent of which one matches
+		if subtle.ConstantTimeCompare(t, []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func (a *Authenticator) passwordValid(username, password string) bool {
+	hash, ok := a.users[username]
+	if !ok {
+		return false
+	}
+	sum := sha256.Sum256([]byte(password))
+
+	a.mu.RLock()
+	cached, ok := a.verified[username]
+	a.mu.RUnlock()
+	if ok && subtle.ConstantTimeCompare(cached[:], sum[:]) == 1 {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+	a.mu.Lock()
+	a.verified[username] = sum
+	a.mu.Unlock()
+	return true
+}
+
+func (a *Authenticator) certAllowed(r *http.Request) bool {
+	if len(a.conf.ClientCertNames) == 0 {
+		return true
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if slices.Contains(a.conf.ClientCertNames, cert.Subject.CommonName) {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if slices.Contains(a.conf.ClientCertNames, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package input
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"zms.szuro.net/internal/config"
+)
+
+func serveAuth(t *testing.T, a *Authenticator, endpoint string, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	rec := httptest.NewRecorder()
+	a.Wrap(endpoint, ok).ServeHTTP(rec, req)
+	return rec
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, c.Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func TestAuthenticator_Disabled(t *testing.T) {
+	a := NewAuthenticator(config.HTTPAuthConf{})
+	req := httptest.NewRequest(http.MethodPost, "/history", nil)
+	require.Equal(t, http.StatusOK, serveAuth(t, a, "disabled", req).Code)
+}
+
+func TestAuthenticator_Bearer(t *testing.T) {
+	a := NewAuthenticator(config.HTTPAuthConf{BearerTokens: []string{"secret", "other"}})
+	token := counterValue(t, authRejected.WithLabelValues("bearer", AUTH_TOKEN))
+	missing := counterValue(t, authRejected.WithLabelValues("bearer", AUTH_MISSING))
+	unsupported := counterValue(t, authRejected.WithLabelValues("bearer", AUTH_UNSUPPORTED))
+
+	req := httptest.NewRequest(http.MethodPost, "/history", nil)
+	req.Header.Set("Authorization", "Bearer other")
+	require.Equal(t, http.StatusOK, serveAuth(t, a, "bearer", req).Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/history", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := serveAuth(t, a, "bearer", req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Contains(t, rec.Header().Values("WWW-Authenticate"), `Bearer realm="zms"`)
+	require.Equal(t, token+1, counterValue(t, authRejected.WithLabelValues("bearer", AUTH_TOKEN)))
+
+	req = httptest.NewRequest(http.MethodPost, "/history", nil)
+	require.Equal(t, http.StatusUnauthorized, serveAuth(t, a, "bearer", req).Code)
+	require.Equal(t, missing+1, counterValue(t, authRejected.WithLabelValues("bearer", AUTH_MISSING)))
+
+	req = httptest.NewRequest(http.MethodPost, "/history", nil)
+	req.SetBasicAuth("user", "secret")
+	require.Equal(t, http.StatusUnauthorized, serveAuth(t, a, "bearer", req).Code)
+	require.Equal(t, unsupported+1, counterValue(t, authRejected.WithLabelValues("bearer", AUTH_UNSUPPORTED)))
+}
+
+func TestAuthenticator_Basic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost)
+	require.NoError(t, err)
+	a := NewAuthenticator(config.HTTPAuthConf{
+		BasicUsers: []config.BasicUser{{Username: "zabbix", PasswordHash: string(hash)}},
+	})
+	invalid := counterValue(t, authRejected.WithLabelValues("basic", AUTH_BASIC))
+
+	for range 2 { // second round is served from the cache
+		req := httptest.NewRequest(http.MethodPost, "/events", nil)
+		req.SetBasicAuth("zabbix", "pass")
+		require.Equal(t, http.StatusOK, serveAuth(t, a, "basic", req).Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/events", nil)
+	req.SetBasicAuth("zabbix", "wrong")
+	rec := serveAuth(t, a, "basic", req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Equal(t, `Basic realm="zms"`, rec.Header().Get("WWW-Authenticate"))
+
+	req = httptest.NewRequest(http.MethodPost, "/events", nil)
+	req.SetBasicAuth("nobody", "pass")
+	require.Equal(t, http.StatusUnauthorized, serveAuth(t, a, "basic", req).Code)
+	require.Equal(t, invalid+2, counterValue(t, authRejected.WithLabelValues("basic", AUTH_BASIC)))
+}
+
+func TestAuthenticator_ClientCert(t *testing.T) {
+	a := NewAuthenticator(config.HTTPAuthConf{ClientCert: true, ClientCertNames: []string{"zabbix.example.com"}})
+	invalid := counterValue(t, authRejected.WithLabelValues("cert", AUTH_CERT))
+
+	withCert := func(cn string, dns ...string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/trends", nil)
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}, DNSNames: dns}
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return req
+	}
+
+	require.Equal(t, http.StatusOK, serveAuth(t, a, "cert", withCert("zabbix.example.com")).Code)
+	require.Equal(t, http.StatusOK, serveAuth(t, a, "cert", withCert("server", "zabbix.example.com")).Code)
+	require.Equal(t, http.StatusUnauthorized, serveAuth(t, a, "cert", withCert("intruder")).Code)
+	require.Equal(t, http.StatusUnauthorized, serveAuth(t, a, "cert", httptest.NewRequest(http.MethodPost, "/trends", nil)).Code)
+	require.Equal(t, invalid+2, counterValue(t, authRejected.WithLabelValues("cert", AUTH_CERT)))
+}
@@ -4,16 +4,21 @@ import (
 	"bufio"
 	"compress/gzip"
 	"compress/zlib"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	zbxpkg "zms.szuro.net/pkg/zbx"
 	"zms.szuro.net/internal/config"
 	"zms.szuro.net/internal/logger"
 )
@@ -34,28 +39,64 @@ var (
 		},
 		[]string{"endpoint"},
 	)
+
+	httpRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zms_http_requests_rejected_total",
+			Help: "Total number of HTTP requests rejected due to overload per endpoint and reason",
+		},
+		[]string{"endpoint", "reason"},
+	)
+
+	httpInFlight = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "zms_http_inflight_requests",
+			Help: "Number of HTTP requests currently processed per endpoint",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+// Reasons reported by zms_http_requests_rejected_total
+const (
+	REJECT_SATURATED   = "saturated"
+	REJECT_CONCURRENCY = "concurrency_limit"
+	REJECT_BODY_SIZE   = "body_too_large"
+	REJECT_TIMEOUT     = "enqueue_timeout"
+	REJECT_SHUTDOWN    = "shutting_down"
 )
 
+// NDJSON_CONTENT_TYPE is the content type of request bodies, as sent by the Zabbix connector.
+const NDJSON_CONTENT_TYPE = "application/x-ndjson"
+
 type HTTPInput struct {
 	baseInput
+	auth  *Authenticator
+	slots chan struct{} // limits concurrent requests, nil if unlimited
+
+	intake  sync.RWMutex // held for reading while values are passed to funnels
+	stopped bool
 }
 
 func NewHTTPInput(zmsConf config.ZMSConf) (*HTTPInput, error) {
 	hi := &HTTPInput{
-		baseInput{
+		baseInput: baseInput{
 			config:   zmsConf,
 			subjects: make(map[string]Subjecter),
 		},
+		auth: NewAuthenticator(zmsConf.Http.Auth),
+	}
+	if limit := zmsConf.Http.Limits.MaxConcurrentRequests; limit > 0 {
+		hi.slots = make(chan struct{}, limit)
+	}
+	for name, export := range exportTypes {
+		subject := export.newSubject()
+		subject.SetFunnel(make(chan any, zmsConf.BufferSize*2))
+		subject.SetBuffer(zmsConf.BufferSize)
+		subject.SetFlushPolicy(zmsConf.Flush.PolicyFor(name))
+		subject.SetShards(zmsConf.Shards)
+		hi.subjects[name] = subject
 	}
-	historySubject := NewSubject[zbxpkg.History]()
-	historySubject.Funnel = make(chan any, zmsConf.BufferSize*2)
-	historySubject.SetBuffer(zmsConf.BufferSize)
-	hi.subjects[zbxpkg.HISTORY] = &historySubject
-
-	eventSubject := NewSubject[zbxpkg.Event]()
-	eventSubject.Funnel = make(chan any, zmsConf.BufferSize*2)
-	eventSubject.SetBuffer(zmsConf.BufferSize)
-	hi.subjects[zbxpkg.EVENT] = &eventSubject
 
 	return hi, nil
 }
@@ -64,135 +105,280 @@ func (hi *HTTPInput) Prepare() {
 	hi.baseInput.Prepare()
 }
 
+// Start registers an endpoint for every export type, e.g. /history, /trends and /events,
+// and /connector for Zabbix connectors. Endpoints require authentication if it is configured.
 func (hi *HTTPInput) Start() {
-	http.HandleFunc("/history", hi.handleHistory)
-	http.HandleFunc("/events", hi.handleEvents)
-	ndjsonLinesReceived.WithLabelValues(zbxpkg.HISTORY).Add(0)
-	ndjsonLinesReceived.WithLabelValues(zbxpkg.EVENT).Add(0)
-	ndjsonParseErrors.WithLabelValues(zbxpkg.HISTORY).Add(0)
-	ndjsonParseErrors.WithLabelValues(zbxpkg.EVENT).Add(0)
+	for name := range hi.subjects {
+		hi.handle(name, hi.handleExport(name))
+	}
+	hi.handle(CONNECTOR_ENDPOINT, hi.handleConnector())
 	hi.baseInput.Start()
 }
 
+// Stop rejects new values, waits for requests passing values to funnels
+// and drains what was accepted so far to targets.
 func (hi *HTTPInput) Stop() error {
+	hi.intake.Lock()
+	hi.stopped = true
+	hi.intake.Unlock()
 	return hi.baseInput.Stop()
 }
 
-func (hi *HTTPInput) IsReady() bool {
-	return true // HTTP server is always ready after Start
+func (hi *HTTPInput) handle(endpoint string, handler http.Handler) {
+	http.Handle("/"+endpoint, hi.auth.Wrap(endpoint, hi.limit(endpoint, handler)))
+	ndjsonLinesReceived.WithLabelValues(endpoint).Add(0)
+	ndjsonParseErrors.WithLabelValues(endpoint).Add(0)
+	httpInFlight.WithLabelValues(endpoint).Set(0)
+	for _, reason := range []string{REJECT_SATURATED, REJECT_CONCURRENCY, REJECT_BODY_SIZE, REJECT_TIMEOUT, REJECT_SHUTDOWN} {
+		httpRejected.WithLabelValues(endpoint, reason).Add(0)
+	}
+}
+
+// limit rejects requests over the concurrency limit with 503.
+func (hi *HTTPInput) limit(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hi.slots != nil {
+			select {
+			case hi.slots <- struct{}{}:
+				defer func() { <-hi.slots }()
+			default:
+				hi.reject(w, name, REJECT_CONCURRENCY, http.StatusServiceUnavailable)
+				return
+			}
+		}
+		httpInFlight.WithLabelValues(name).Inc()
+		defer httpInFlight.WithLabelValues(name).Dec()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reject answers with the status and a Retry-After header.
+func (hi *HTTPInput) reject(w http.ResponseWriter, name, reason string, status int) {
+	httpRejected.WithLabelValues(name, reason).Inc()
+	logger.Warn("Rejected HTTP request", slog.String("endpoint", name), slog.String("reason", reason))
+	retry := int(math.Ceil(hi.config.Http.Limits.RetryAfter.Seconds()))
+	if retry > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+	}
+	writeError(w, status, reason, nil)
+}
+
+// routeFunc parses a single NDJSON line and returns the name of the subject it belongs to.
+type routeFunc func(line []byte) (subject string, value any, err error)
+
+// handleExport returns a handler parsing NDJSON lines of the given export type
+// and passing them to the matching subject.
+func (hi *HTTPInput) handleExport(name string) http.HandlerFunc {
+	export := exportTypes[name]
+	return hi.handleLines(name, []string{name}, func(line []byte) (string, any, error) {
+		value, err := export.parse(line)
+		return name, value, err
+	})
 }
 
-func (hi *HTTPInput) handleHistory(w http.ResponseWriter, r *http.Request) {
-	hi.handleNDJSON(w, r, func(line string) {
-		var hExport zbxpkg.History
-		if err := json.Unmarshal([]byte(line), &hExport); err != nil {
-			logger.Error("Failed to parse history line", slog.Any("error", err))
-			ndjsonParseErrors.WithLabelValues(zbxpkg.HISTORY).Inc()
+// handleLines returns a handler passing NDJSON lines to subjects chosen by route.
+// The whole body is parsed before anything is passed on. Invalid lines are skipped
+// and listed in a JSON body along with the number of accepted ones, so a single bad
+// record does not hold back the rest of the request. If no line is valid,
+// the request is rejected with 422.
+//
+// Only NDJSON bodies are accepted, other content types are rejected with 415.
+// If any value cannot be buffered in time, the whole request is rejected with 503,
+// so the client sends it again. Values buffered before are then received twice.
+func (hi *HTTPInput) handleLines(endpoint string, subjects []string, route routeFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+			return
+		}
+		if !isNDJSON(r) {
+			writeError(w, http.StatusUnsupportedMediaType, "unsupported content type "+r.Header.Get("Content-Type"), nil)
 			return
 		}
-		ndjsonLinesReceived.WithLabelValues(zbxpkg.HISTORY).Inc()
-		subject, ok := hi.subjects[zbxpkg.HISTORY]
+		funnels := make(map[string]chan any, len(subjects))
+		for _, name := range subjects {
+			funnel := hi.subjects[name].GetFunnel()
+			if funnel == nil {
+				logger.Error("No funnel for export", slog.String("subject", name))
+				writeError(w, http.StatusInternalServerError, "no funnel for "+name, nil)
+				return
+			}
+			// Fail fast instead of reading the body if the buffer can't keep up
+			if len(funnel) >= cap(funnel) {
+				hi.reject(w, endpoint, REJECT_SATURATED, http.StatusTooManyRequests)
+				return
+			}
+			funnels[name] = funnel
+		}
+
+		values := make(map[string][]any, len(subjects))
+		var rejected []lineError
+		lines := 0
+		ok := hi.handleNDJSON(w, r, endpoint, func(n int, line string) {
+			lines++
+			name, value, err := route([]byte(line))
+			if err == nil && funnels[name] == nil {
+				err = fmt.Errorf("unexpected %s record", name)
+			}
+			if err != nil {
+				logger.Error("Failed to parse line", slog.String("endpoint", endpoint), slog.Int("line", n), slog.Any("error", err))
+				ndjsonParseErrors.WithLabelValues(endpoint).Inc()
+				rejected = append(rejected, lineError{Line: n, Error: err.Error()})
+				return
+			}
+			ndjsonLinesReceived.WithLabelValues(endpoint).Inc()
+			values[name] = append(values[name], value)
+		})
 		if !ok {
-			logger.Error("No subject!")
 			return
 		}
-		funnel := subject.GetFunnel()
-		if funnel == nil {
-			logger.Error("No funnel for HISTORY export", slog.String("subject", zbxpkg.HISTORY))
+		if len(rejected) > 0 && len(rejected) == lines {
+			writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("%d of %d lines rejected", len(rejected), lines), rejected)
 			return
 		}
-		funnel <- hExport
-	})
-}
 
-func (hi *HTTPInput) handleEvents(w http.ResponseWriter, r *http.Request) {
-	hi.handleNDJSON(w, r, func(line string) {
-		var eExport zbxpkg.Event
-		if err := json.Unmarshal([]byte(line), &eExport); err != nil {
-			logger.Error("Failed to parse event line", slog.Any("error", err))
-			ndjsonParseErrors.WithLabelValues(zbxpkg.EVENT).Inc()
+		hi.intake.RLock()
+		defer hi.intake.RUnlock()
+		if hi.stopped {
+			hi.reject(w, endpoint, REJECT_SHUTDOWN, http.StatusServiceUnavailable)
 			return
 		}
-		ndjsonLinesReceived.WithLabelValues(zbxpkg.EVENT).Inc()
-		subject, ok := hi.subjects[zbxpkg.EVENT]
-		if !ok {
-			logger.Error("No subject!")
-			return
+		for _, name := range subjects {
+			if sent := hi.enqueue(r, funnels[name], values[name]); sent < len(values[name]) {
+				logger.Warn("Buffer full, request only partially accepted",
+					slog.String("endpoint", endpoint),
+					slog.String("subject", name),
+					slog.Int("accepted", sent),
+					slog.Int("values", len(values[name])))
+				hi.reject(w, endpoint, REJECT_TIMEOUT, http.StatusServiceUnavailable)
+				return
+			}
 		}
-		funnel := subject.GetFunnel()
-		if funnel == nil {
-			logger.Error("No funnel for EVENT export", slog.String("subject", zbxpkg.EVENT))
+		if len(rejected) > 0 {
+			writeAccepted(w, lines-len(rejected), rejected)
 			return
 		}
-		funnel <- eExport
-	})
+		w.WriteHeader(http.StatusOK)
+	}
 }
 
-// handleNDJSON handles decompression, NDJSON reading, and error responses for HTTPInput
-func (hi *HTTPInput) handleNDJSON(w http.ResponseWriter, r *http.Request, handleLine func(string)) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+// isNDJSON reports whether the request body is NDJSON. Requests without a content type are taken as NDJSON.
+func isNDJSON(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
 	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == NDJSON_CONTENT_TYPE
+}
+
+// enqueue passes values to the funnel in order, waiting at most EnqueueTimeout for free space.
+// Returns the number of values passed.
+func (hi *HTTPInput) enqueue(r *http.Request, funnel chan any, values []any) int {
+	var timeout <-chan time.Time
+	for i, v := range values {
+		select {
+		case funnel <- v:
+			continue
+		default:
+		}
+		if timeout == nil {
+			wait := hi.config.Http.Limits.EnqueueTimeout
+			if wait <= 0 {
+				return i
+			}
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case funnel <- v:
+		case <-timeout:
+			return i
+		case <-r.Context().Done():
+			return i
+		}
+	}
+	return len(values)
+}
+
+// handleNDJSON handles decompression, NDJSON reading, and error responses for HTTPInput.
+// handleLine gets every non-empty line along with its number, starting from 1.
+// Returns false if the request failed and the response was already written.
+func (hi *HTTPInput) handleNDJSON(w http.ResponseWriter, r *http.Request, name string, handleLine func(int, string)) bool {
 	defer r.Body.Close()
 
+	maxBody := hi.config.Http.Limits.MaxBodySize
 	var bodyReader io.Reader = r.Body
-	if ce := r.Header.Get("Content-Encoding"); ce != "" {
+	if maxBody > 0 {
+		bodyReader = http.MaxBytesReader(w, r.Body, maxBody)
+	}
+	ce := r.Header.Get("Content-Encoding")
+	if ce != "" {
 		switch strings.ToLower(ce) {
 		case "gzip":
-			gz, err := gzip.NewReader(r.Body)
+			gz, err := gzip.NewReader(bodyReader)
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, http.StatusBadRequest, "invalid gzip body", nil)
 				logger.Error("Failed to create gzip reader", slog.Any("error", err))
-				return
+				return false
 			}
 			defer gz.Close()
 			bodyReader = gz
 		case "deflate":
-			zr, err := zlib.NewReader(r.Body)
+			zr, err := zlib.NewReader(bodyReader)
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, http.StatusBadRequest, "invalid deflate body", nil)
 				logger.Error("Failed to create zlib/deflate reader", slog.Any("error", err))
-				return
+				return false
 			}
 			defer zr.Close()
 			bodyReader = zr
 		case "zstd", "ztsd":
-			zr, err := zstd.NewReader(r.Body)
+			zr, err := zstd.NewReader(bodyReader)
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, http.StatusBadRequest, "invalid zstd body", nil)
 				logger.Error("Failed to create zstd reader", slog.Any("error", err))
-				return
+				return false
 			}
 			defer zr.Close()
 			bodyReader = zr
 		default:
-			w.WriteHeader(http.StatusUnsupportedMediaType)
+			writeError(w, http.StatusUnsupportedMediaType, "unsupported Content-Encoding "+ce, nil)
 			logger.Error("Unsupported Content-Encoding", slog.String("encoding", ce))
-			return
+			return false
 		}
 	}
 
+	// Limit decompressed size as well
+	if maxBody > 0 && ce != "" {
+		bodyReader = http.MaxBytesReader(w, io.NopCloser(bodyReader), maxBody)
+	}
+
 	reader := bufio.NewReader(bodyReader)
 	fin := false
-	for {
+	for n := 1; ; n++ {
 		line, err := reader.ReadString('\n')
 		if err == io.EOF {
 			fin = true
 		}
 		if err != nil && err != io.EOF {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				hi.reject(w, name, REJECT_BODY_SIZE, http.StatusRequestEntityTooLarge)
+				return false
+			}
 			logger.Error("Error reading request body", slog.Any("error", err))
-			w.WriteHeader(http.StatusBadRequest)
-			return
+			writeError(w, http.StatusBadRequest, "failed to read body", nil)
+			return false
 		}
 
-		if line = strings.TrimSpace(line); line == "" {
-			continue
+		if line = strings.TrimSpace(line); line != "" {
+			handleLine(n, line)
 		}
-		handleLine(line)
 		if fin {
 			break
 		}
 	}
-	w.WriteHeader(http.StatusOK)
+	return true
 }
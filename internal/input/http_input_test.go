@@ -0,0 +1,187 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func newTestHTTPInput(t *testing.T) *HTTPInput {
+	t.Helper()
+	hi, err := NewHTTPInput(config.ZMSConf{BufferSize: 10})
+	require.NoError(t, err)
+	return hi
+}
+
+func TestHTTPInput_AllExportTypes(t *testing.T) {
+	hi := newTestHTTPInput(t)
+	for name := range exportTypes {
+		require.Contains(t, hi.GetSubjects(), name)
+	}
+}
+
+func TestHTTPInput_HandleTrends(t *testing.T) {
+	hi := newTestHTTPInput(t)
+	body := `{"host":{"host":"h1","name":"Host 1"},"itemid":1,"name":"cpu","clock":1700000000,"count":60,"min":1,"max":3,"avg":2,"type":0}
+{"itemid":2,"clock":1700000000,"count":60,"min":1,"max":1,"avg":1,"type":3}
+`
+	req := httptest.NewRequest(http.MethodPost, "/"+zbxpkg.TREND, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	hi.handleExport(zbxpkg.TREND)(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	funnel := hi.GetSubjects()[zbxpkg.TREND].GetFunnel()
+	require.Len(t, funnel, 2)
+	first := (<-funnel).(zbxpkg.Trend)
+	require.Equal(t, int64(1), first.ItemID)
+	require.Equal(t, "h1", first.Host.Host)
+	require.Equal(t, float64(2), first.Avg)
+}
+
+func TestHTTPInput_MethodNotAllowed(t *testing.T) {
+	hi := newTestHTTPInput(t)
+	req := httptest.NewRequest(http.MethodGet, "/"+zbxpkg.HISTORY, nil)
+	rec := httptest.NewRecorder()
+	hi.handleExport(zbxpkg.HISTORY)(rec, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func newLimitedHTTPInput(t *testing.T, limits config.HTTPLimits) *HTTPInput {
+	t.Helper()
+	hi, err := NewHTTPInput(config.ZMSConf{BufferSize: 1, Http: config.HTTPConf{Limits: limits}})
+	require.NoError(t, err)
+	return hi
+}
+
+const (
+	historyLine = `{"itemid":1,"clock":1700000000,"ns":0,"value":1,"type":3}
+`
+	eventLine = `{"eventid":1,"clock":1700000000,"ns":0,"value":1,"name":"problem","severity":3}
+`
+)
+
+func TestHTTPInput_Saturated(t *testing.T) {
+	hi := newLimitedHTTPInput(t, config.HTTPLimits{RetryAfter: 1500 * time.Millisecond})
+	handler := hi.handleExport(zbxpkg.HISTORY)
+
+	// funnel holds BufferSize*2 values
+	req := httptest.NewRequest(http.MethodPost, "/"+zbxpkg.HISTORY, strings.NewReader(historyLine+historyLine))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/"+zbxpkg.HISTORY, strings.NewReader(historyLine))
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "2", rec.Header().Get("Retry-After"))
+}
+
+func TestHTTPInput_EnqueueTimeout(t *testing.T) {
+	hi := newLimitedHTTPInput(t, config.HTTPLimits{EnqueueTimeout: 10 * time.Millisecond, RetryAfter: time.Second})
+	req := httptest.NewRequest(http.MethodPost, "/"+zbxpkg.HISTORY, strings.NewReader(historyLine+historyLine+historyLine))
+	rec := httptest.NewRecorder()
+	hi.handleExport(zbxpkg.HISTORY)(rec, req)
+
+	// a single value not buffered in time rejects the whole request
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	// a consumer draining the funnel lets the whole request through
+	hi = newLimitedHTTPInput(t, config.HTTPLimits{EnqueueTimeout: time.Second})
+	funnel := hi.GetSubjects()[zbxpkg.HISTORY].GetFunnel()
+	go func() {
+		for range 3 {
+			<-funnel
+		}
+	}()
+	req = httptest.NewRequest(http.MethodPost, "/"+zbxpkg.HISTORY, strings.NewReader(historyLine+historyLine+historyLine))
+	rec = httptest.NewRecorder()
+	hi.handleExport(zbxpkg.HISTORY)(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestHTTPInput_BodyTooLarge(t *testing.T) {
+	hi := newLimitedHTTPInput(t, config.HTTPLimits{MaxBodySize: 64})
+	req := httptest.NewRequest(http.MethodPost, "/"+zbxpkg.HISTORY, strings.NewReader(historyLine+historyLine))
+	rec := httptest.NewRecorder()
+	hi.handleExport(zbxpkg.HISTORY)(rec, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	require.Empty(t, hi.GetSubjects()[zbxpkg.HISTORY].GetFunnel())
+
+	// small compressed body expanding over the limit
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(bytes.Repeat([]byte(" "), 4096))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.Less(t, buf.Len(), 64)
+
+	req = httptest.NewRequest(http.MethodPost, "/"+zbxpkg.HISTORY, &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	hi.handleExport(zbxpkg.HISTORY)(rec, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func TestHTTPInput_ConcurrencyLimit(t *testing.T) {
+	hi := newLimitedHTTPInput(t, config.HTTPLimits{MaxConcurrentRequests: 1})
+	entered, release := make(chan struct{}), make(chan struct{})
+	blocking := hi.limit(zbxpkg.HISTORY, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+
+	go blocking.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/"+zbxpkg.HISTORY, nil))
+	<-entered
+
+	rec := httptest.NewRecorder()
+	blocking.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+zbxpkg.HISTORY, nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	close(release)
+}
+
+func TestHTTPInput_ConnectorEnqueueTimeout(t *testing.T) {
+	hi := newLimitedHTTPInput(t, config.HTTPLimits{EnqueueTimeout: 10 * time.Millisecond, RetryAfter: time.Second})
+
+	// history is buffered first, events are not tried anymore once it timed out
+	req := httptest.NewRequest(http.MethodPost, "/"+CONNECTOR_ENDPOINT, strings.NewReader(eventLine+historyLine+historyLine+historyLine))
+	rec := httptest.NewRecorder()
+	hi.handleConnector()(rec, req)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+	require.Empty(t, hi.GetSubjects()[zbxpkg.EVENT].GetFunnel())
+}
+
+func TestHTTPInput_ContentType(t *testing.T) {
+	tests := map[string]int{
+		"":                                      http.StatusOK,
+		NDJSON_CONTENT_TYPE:                     http.StatusOK,
+		NDJSON_CONTENT_TYPE + "; charset=utf-8": http.StatusOK,
+		"application/json":                      http.StatusUnsupportedMediaType,
+		"text/plain":                            http.StatusUnsupportedMediaType,
+	}
+	for contentType, status := range tests {
+		t.Run(contentType, func(t *testing.T) {
+			hi := newLimitedHTTPInput(t, config.HTTPLimits{})
+			req := httptest.NewRequest(http.MethodPost, "/"+zbxpkg.HISTORY, strings.NewReader(historyLine))
+			if contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+			rec := httptest.NewRecorder()
+			hi.handleExport(zbxpkg.HISTORY)(rec, req)
+			require.Equal(t, status, rec.Code)
+			if status != http.StatusOK {
+				require.Empty(t, hi.GetSubjects()[zbxpkg.HISTORY].GetFunnel())
+			}
+		})
+	}
+}
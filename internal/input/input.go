@@ -1,8 +1,11 @@
 package input
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"slices"
+	"sync"
 
 	"zms.szuro.net/internal/config"
 	"zms.szuro.net/internal/logger"
@@ -12,16 +15,22 @@ import (
 type Inputer interface {
 	cleanup()
 	GetSubjects() map[string]Subjecter
-	IsReady() bool
 	setFilter()
 	Prepare()
 	Start()
 	Stop() error
+	Reload(conf config.ZMSConf) error
 }
 
 type baseInput struct {
-	config   config.ZMSConf
-	subjects map[string]Subjecter
+	config    config.ZMSConf
+	subjects  map[string]Subjecter
+	accepting sync.WaitGroup
+	reloading sync.Mutex // serializes reloads and draining
+	drained   bool
+	dedup     *Deduplicator
+	// newObserver creates observers of targets, Target.ToObserver if nil.
+	newObserver func(target config.Target) (config.Observer, error)
 }
 
 func (bs *baseInput) GetSubjects() map[string]Subjecter {
@@ -30,44 +39,197 @@ func (bs *baseInput) GetSubjects() map[string]Subjecter {
 
 func (bs *baseInput) Prepare() {
 	bs.setFilter()
+	bs.setDedup()
 	bs.setTargets()
 }
 
+// setDedup drops values seen recently, if enabled. Without access to
+// persisted hashes, only hashes seen since start are remembered.
+func (bs *baseInput) setDedup() {
+	if !bs.config.Dedup.Enabled {
+		return
+	}
+	dedup, err := OpenDeduplicator(bs.config.Dedup)
+	if err != nil {
+		logger.Error("Failed to open store of hashes for deduplication, keeping them in memory only", slog.String("path", bs.config.Dedup.Dir), slog.Any("error", err))
+		conf := bs.config.Dedup
+		conf.Persist = false
+		dedup, _ = OpenDeduplicator(conf)
+	}
+	bs.dedup = dedup
+	for _, subject := range bs.subjects {
+		subject.SetDedup(dedup)
+	}
+}
+
 func (bs *baseInput) Start() {
 	for _, subject := range bs.subjects {
-		go subject.AcceptValues()
+		bs.accepting.Add(1)
+		go func() {
+			defer bs.accepting.Done()
+			subject.AcceptValues()
+		}()
 	}
 }
 
+// Stop drains subjects, waiting at most the shutdown timeout, and cleans up observers.
+// Intake must be stopped before, nothing may be sent to funnels anymore.
 func (bs *baseInput) Stop() error {
+	err := bs.drainWithTimeout()
 	bs.cleanup()
-	return nil
+	return err
+}
+
+// drainWithTimeout drains subjects, giving up after the configured shutdown timeout.
+func (bs *baseInput) drainWithTimeout() error {
+	ctx := context.Background()
+	if bs.config.Shutdown > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, bs.config.Shutdown)
+		defer cancel()
+	}
+	return bs.drain(ctx)
+}
+
+// drain closes funnels, which flushes values left in buffers, and waits until
+// delivery queues handed every batch in memory over to observers.
+// If ctx is done first, batches still queued are abandoned. Offsets of values read
+// from files are not committed for them, so they are read again after restart.
+// Either way drain returns only once shards and queue workers stopped, so observers
+// and the deduplication store can be closed. Batches being delivered are finished,
+// which takes at most the delivery timeout of their target.
+func (bs *baseInput) drain(ctx context.Context) error {
+	bs.reloading.Lock()
+	bs.drained = true
+	bs.reloading.Unlock()
+
+	logger.Info("Draining buffers and delivery queues")
+	for _, subject := range bs.subjects {
+		close(subject.GetFunnel())
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		bs.accepting.Wait()
+		var queues sync.WaitGroup
+		for _, subject := range bs.subjects {
+			queues.Add(1)
+			go func() {
+				defer queues.Done()
+				subject.Drain()
+			}()
+		}
+		queues.Wait()
+	}()
+
+	select {
+	case <-drained:
+		logger.Info("Buffers and delivery queues drained")
+		return nil
+	case <-ctx.Done():
+		logger.Error("Shutdown timeout exceeded, abandoning undelivered values", slog.Any("error", ctx.Err()))
+		for _, subject := range bs.subjects {
+			subject.Abort()
+		}
+		<-drained
+		return fmt.Errorf("draining subjects: %w", ctx.Err())
+	}
 }
 
 func (bs *baseInput) cleanup() {
 	for _, subject := range bs.subjects {
 		subject.Cleanup()
 	}
+	if err := bs.dedup.Close(); err != nil {
+		logger.Error("Failed to close store of hashes for deduplication", slog.Any("error", err))
+	}
 }
 
 func (bs *baseInput) setFilter() {
+	// the filter was validated when the configuration was loaded
+	f, err := filter.NewFilter(bs.config.Filter)
+	if err != nil {
+		panic(err)
+	}
 	for _, subject := range bs.subjects {
-		f := filter.NewTagFilter(bs.config.Filter)
 		subject.SetFilter(f)
 	}
 }
 
 func (bs *baseInput) setTargets() {
 	for _, target := range bs.config.Targets {
-		for name, subject := range bs.subjects {
-			if slices.Contains(target.Source, name) {
-				t, err := target.ToObserver(bs.config)
-				if err == nil {
-					subject.Register(t)
-				} else {
-					logger.Warn("Failed to register target", slog.String("name", target.UniqueName))
-				}
-			}
+		if err := bs.registerTarget(target); err != nil {
+			logger.Warn("Failed to register target", slog.String("name", target.UniqueName), slog.Any("error", err))
+		}
+	}
+}
+
+// registerTarget creates an observer of the target for every subject it is a source of.
+// If any of them fails, the target is not registered at all.
+func (bs *baseInput) registerTarget(target config.Target) error {
+	observers, err := bs.createObservers(target)
+	if err != nil {
+		return err
+	}
+	for _, o := range observers {
+		o.register()
+	}
+	return nil
+}
+
+// targetObserver is an observer of a target created for a subject, not registered yet.
+type targetObserver struct {
+	subject  Subjecter
+	observer config.Observer
+	delivery config.DeliveryConf
+}
+
+// register starts the observer and registers it with the subject.
+func (o targetObserver) register() {
+	startObserver(o.observer)
+	o.subject.Register(o.observer, o.delivery)
+}
+
+// startObserver starts observers opening resources once they are registered.
+func startObserver(observer config.Observer) {
+	if s, ok := observer.(config.Starter); ok {
+		s.Start()
+	}
+}
+
+// createObservers creates and initializes an observer of the target for every subject
+// it is a source of. If any of them fails, the ones already created are cleaned up.
+func (bs *baseInput) createObservers(target config.Target) ([]targetObserver, error) {
+	var observers []targetObserver
+	for name, subject := range bs.subjects {
+		if !slices.Contains(target.Source, name) {
+			continue
 		}
+		obs, err := bs.createObserver(target.ForExport(name))
+		if err != nil {
+			cleanupObservers(observers)
+			return nil, fmt.Errorf("failed to register target %s: %w", target.UniqueName, err)
+		}
+		observers = append(observers, targetObserver{subject: subject, observer: obs, delivery: target.Delivery})
+	}
+	return observers, nil
+}
+
+func (bs *baseInput) createObserver(target config.Target) (config.Observer, error) {
+	if bs.newObserver != nil {
+		return bs.newObserver(target)
+	}
+	obs, err := target.ToObserver(bs.config)
+	if err == nil && obs == nil {
+		err = fmt.Errorf("plugin %s is not loaded", target.PluginBinaryName)
+	}
+	return obs, err
+}
+
+// cleanupObservers cleans up observers that were never registered.
+func cleanupObservers(observers []targetObserver) {
+	for _, o := range observers {
+		o.observer.Cleanup()
 	}
 }
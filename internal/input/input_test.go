@@ -0,0 +1,106 @@
+package input
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// blockingObserver holds every save until released.
+type blockingObserver struct {
+	fakeObserver
+	release       chan struct{}
+	saving        atomic.Int32
+	cleanedSaving atomic.Bool // cleaned up while a save was in progress
+}
+
+func (b *blockingObserver) SaveHistory(h []zbxpkg.History) bool {
+	b.saving.Add(1)
+	defer b.saving.Add(-1)
+	<-b.release
+	return b.fakeObserver.SaveHistory(h)
+}
+
+func (b *blockingObserver) Cleanup() {
+	if b.saving.Load() > 0 {
+		b.cleanedSaving.Store(true)
+	}
+}
+
+func startTestInput(t *testing.T, observer config.Observer, bufferSize int, timeout time.Duration) *HTTPInput {
+	t.Helper()
+	hi, err := NewHTTPInput(config.ZMSConf{BufferSize: bufferSize, Shutdown: timeout})
+	require.NoError(t, err)
+	hi.setFilter()
+	hi.GetSubjects()[zbxpkg.HISTORY].Register(observer, config.DeliveryConf{Workers: 1, QueueDepth: 1})
+	// endpoints are not registered, handlers are called directly
+	hi.baseInput.Start()
+	return hi
+}
+
+func postHistory(hi *HTTPInput, lines int) int {
+	req := httptest.NewRequest(http.MethodPost, "/"+zbxpkg.HISTORY, strings.NewReader(strings.Repeat(historyLine, lines)))
+	rec := httptest.NewRecorder()
+	hi.handleExport(zbxpkg.HISTORY)(rec, req)
+	return rec.Code
+}
+
+func TestBaseInput_StopDrains(t *testing.T) {
+	observer := &fakeObserver{name: "target"}
+	hi := startTestInput(t, observer, 10, time.Second)
+
+	// fewer values than the buffer size wait in the buffer until stopping
+	require.Equal(t, http.StatusOK, postHistory(hi, 3))
+	require.NoError(t, hi.Stop())
+	require.Len(t, observer.history, 3)
+
+	require.Equal(t, http.StatusServiceUnavailable, postHistory(hi, 1))
+}
+
+func TestBaseInput_StopTimeout(t *testing.T) {
+	observer := &blockingObserver{fakeObserver: fakeObserver{name: "target"}, release: make(chan struct{})}
+	hi := startTestInput(t, observer, 10, 50*time.Millisecond)
+
+	require.Equal(t, http.StatusOK, postHistory(hi, 3))
+	// values flushed on stop are being saved when the timeout is exceeded,
+	// the save is finished before the observer is cleaned up
+	time.AfterFunc(200*time.Millisecond, func() { close(observer.release) })
+	start := time.Now()
+	require.ErrorContains(t, hi.Stop(), "deadline exceeded")
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	require.Less(t, time.Since(start), time.Second)
+	require.False(t, observer.cleanedSaving.Load(), "observer cleaned up while saving")
+	require.Len(t, observer.history, 3)
+}
+
+func TestDeliveryQueue_AbortLeavesBatchesUnacknowledged(t *testing.T) {
+	observer := &blockingObserver{fakeObserver: fakeObserver{name: "target"}, release: make(chan struct{})}
+	q := NewDeliveryQueue[zbxpkg.History](observer, config.DeliveryConf{Workers: 1, QueueDepth: 1})
+
+	var acked atomic.Int32
+	ack := func() { acked.Add(1) }
+	q.Enqueue([]zbxpkg.History{{ItemID: 1}}, ack)
+	require.Eventually(t, func() bool { return observer.saving.Load() == 1 }, time.Second, time.Millisecond)
+	q.Enqueue([]zbxpkg.History{{ItemID: 2}}, ack)
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		q.Enqueue([]zbxpkg.History{{ItemID: 3}}, ack)
+	}()
+
+	q.Abort()
+	<-blocked
+	close(observer.release)
+	q.Close()
+
+	// only the batch being saved was delivered and acknowledged
+	require.Equal(t, []zbxpkg.History{{ItemID: 1}}, observer.history)
+	require.Equal(t, int32(1), acked.Load())
+}
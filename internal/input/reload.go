@@ -0,0 +1,169 @@
+package input
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/logger"
+)
+
+const (
+	RELOAD_SUCCESS = "success"
+	RELOAD_FAILURE = "failure"
+)
+
+var configReloads = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "zms_config_reloads_total",
+		Help: "The total number of config reloads by result",
+	},
+	[]string{"result"},
+)
+
+// ErrStopped is returned when reloading an input that is stopping.
+var ErrStopped = errors.New("input is stopped")
+
+// ReloadConfig reads the config file at path and applies it to the input.
+// An invalid config is rejected and the running one is kept.
+func ReloadConfig(inp Inputer, path string) error {
+	conf, err := config.LoadZMSConfig(path)
+	if err != nil {
+		configReloads.WithLabelValues(RELOAD_FAILURE).Inc()
+		logger.Error("Failed to reload config", slog.String("path", path), slog.Any("error", err))
+		return fmt.Errorf("invalid config, keeping the running one: %w", err)
+	}
+	return inp.Reload(conf)
+}
+
+// Reload applies targets and the global filter of conf while values keep flowing.
+// Targets are matched by name: removed ones are drained and cleaned up, added ones
+// are started and targets with changed settings are re-initialized.
+// Other settings need a restart.
+//
+// Observers of added targets are created and initialized before anything is changed.
+// If conf is invalid or any of them fails, they are cleaned up and the running
+// config is kept. Otherwise the filter and targets are swapped in one go.
+func (bs *baseInput) Reload(conf config.ZMSConf) (err error) {
+	bs.reloading.Lock()
+	defer bs.reloading.Unlock()
+	defer func() {
+		if err != nil {
+			configReloads.WithLabelValues(RELOAD_FAILURE).Inc()
+			logger.Error("Failed to reload config", slog.Any("error", err))
+			return
+		}
+		configReloads.WithLabelValues(RELOAD_SUCCESS).Inc()
+		logger.Info("Reloaded config")
+	}()
+
+	if bs.drained {
+		return ErrStopped
+	}
+	removed, added := diffTargets(bs.config.Targets, conf.Targets)
+	if err := validateTargets(added); err != nil {
+		return fmt.Errorf("invalid config, keeping the running one: %w", err)
+	}
+	if restartRequired(bs.config, conf) {
+		logger.Warn("Only targets and filter are reloaded, restart to apply other changes")
+	}
+
+	var observers []targetObserver
+	for _, target := range added {
+		logger.Info("Starting target", slog.String("name", target.UniqueName))
+		created, err := bs.createObservers(target)
+		if err != nil {
+			cleanupObservers(observers)
+			return fmt.Errorf("keeping the running config: %w", err)
+		}
+		observers = append(observers, created...)
+	}
+
+	if !reflect.DeepEqual(bs.config.Filter, conf.Filter) {
+		bs.config.Filter = conf.Filter
+		bs.setFilter()
+		logger.Info("Reloaded filter")
+	}
+	for _, target := range removed {
+		logger.Info("Stopping target", slog.String("name", target.UniqueName))
+		for name, subject := range bs.subjects {
+			if slices.Contains(target.Source, name) {
+				subject.Deregister(target.UniqueName)
+			}
+		}
+	}
+	for _, o := range observers {
+		o.register()
+	}
+	bs.config.Targets = conf.Targets
+	return nil
+}
+
+// validateTargets checks that targets to start use known sources.
+// Names are checked to be unique when the config is parsed, plugins when observers are created.
+func validateTargets(targets []config.Target) error {
+	for _, target := range targets {
+		for _, source := range target.Source {
+			if _, ok := exportTypes[source]; !ok {
+				return fmt.Errorf("target %s: unknown source %s", target.UniqueName, source)
+			}
+		}
+	}
+	return nil
+}
+
+// diffTargets matches targets by name. A target with changed settings is both removed and added.
+func diffTargets(running, next []config.Target) (removed, added []config.Target) {
+	for _, old := range running {
+		i := slices.IndexFunc(next, func(t config.Target) bool { return t.UniqueName == old.UniqueName })
+		if i < 0 || !reflect.DeepEqual(old, next[i]) {
+			removed = append(removed, old)
+		}
+	}
+	for _, t := range next {
+		i := slices.IndexFunc(running, func(old config.Target) bool { return old.UniqueName == t.UniqueName })
+		if i < 0 || !reflect.DeepEqual(running[i], t) {
+			added = append(added, t)
+		}
+	}
+	return removed, added
+}
+
+// restartRequired reports if confs differ in anything else than targets and filter.
+func restartRequired(running, next config.ZMSConf) bool {
+	running.Targets, next.Targets = nil, nil
+	running.Filter = next.Filter
+	return !reflect.DeepEqual(running, next)
+}
+
+// AdminReloadHandler returns ReloadHandler behind authentication of HTTP endpoints.
+// It returns nil when authentication is not configured, as anyone able to reach the
+// listener could otherwise reload the config, so the endpoint must not be registered.
+func AdminReloadHandler(auth config.HTTPAuthConf, reload func() error) http.Handler {
+	if !auth.Enabled() {
+		return nil
+	}
+	return NewAuthenticator(auth).Wrap("admin", ReloadHandler(reload))
+}
+
+// ReloadHandler returns a handler calling reload on POST requests.
+// It responds with 200 once the config was applied, or 500 with the reason it was not.
+func ReloadHandler(reload func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+			return
+		}
+		if err := reload(); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error(), nil)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,186 @@
+package input
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/pkg/filter"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func TestDiffTargets(t *testing.T) {
+	kept := config.Target{UniqueName: "kept", PluginBinaryName: "print", Source: []string{zbxpkg.HISTORY}}
+	changed := config.Target{UniqueName: "changed", PluginBinaryName: "print", Connection: "old"}
+	removed := config.Target{UniqueName: "removed", PluginBinaryName: "print"}
+	added := config.Target{UniqueName: "added", PluginBinaryName: "print"}
+	updated := changed
+	updated.Connection = "new"
+
+	r, a := diffTargets([]config.Target{kept, changed, removed}, []config.Target{kept, updated, added})
+	require.Equal(t, []config.Target{changed, removed}, r)
+	require.Equal(t, []config.Target{updated, added}, a)
+}
+
+// waitHistory waits until the observer got n history values.
+func waitHistory(t *testing.T, observer *fakeObserver, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		observer.mu.Lock()
+		defer observer.mu.Unlock()
+		return len(observer.history) == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestBaseInput_Reload(t *testing.T) {
+	observer := &fakeObserver{name: "target"}
+	hi := startTestInput(t, observer, 1, time.Second)
+	hi.config.Targets = []config.Target{{UniqueName: "target", PluginBinaryName: "print", Source: []string{zbxpkg.HISTORY}}}
+	running := hi.config
+	funnel := hi.GetSubjects()[zbxpkg.HISTORY].GetFunnel()
+	post := func() {
+		require.Equal(t, http.StatusOK, postHistory(hi, 1))
+		require.Eventually(t, func() bool { return len(funnel) == 0 }, time.Second, time.Millisecond)
+	}
+
+	// unknown plugin keeps the running config
+	invalid := running
+	invalid.Targets = append([]config.Target{}, running.Targets...)
+	invalid.Targets = append(invalid.Targets, config.Target{UniqueName: "other", PluginBinaryName: "missing", Source: []string{zbxpkg.HISTORY}})
+	invalid.Filter = filter.FilterConfig{Accepted: []string{"env:prod"}}
+	require.ErrorContains(t, hi.Reload(invalid), "plugin missing is not loaded")
+	require.Equal(t, running.Targets, hi.config.Targets)
+	post()
+	waitHistory(t, observer, 1)
+
+	// values without matching tags are filtered out after reloading the filter
+	filtered := running
+	filtered.Filter = filter.FilterConfig{Accepted: []string{"env:prod"}}
+	require.NoError(t, hi.Reload(filtered))
+	post()
+
+	// removed target is drained and gets nothing more
+	removed := filtered
+	removed.Targets = nil
+	require.NoError(t, hi.Reload(removed))
+	require.Empty(t, hi.config.Targets)
+	post()
+
+	require.NoError(t, hi.Stop())
+	require.Len(t, observer.history, 1)
+	require.ErrorIs(t, hi.Reload(running), ErrStopped)
+}
+
+// startedObserver records whether it was started and cleaned up.
+type startedObserver struct {
+	fakeObserver
+	started atomic.Bool
+	cleaned atomic.Bool
+}
+
+func (o *startedObserver) Start()   { o.started.Store(true) }
+func (o *startedObserver) Cleanup() { o.cleaned.Store(true) }
+
+func TestBaseInput_ReloadIsAtomic(t *testing.T) {
+	observer := &fakeObserver{name: "target"}
+	hi := startTestInput(t, observer, 1, time.Second)
+	hi.config.Targets = []config.Target{{UniqueName: "target", PluginBinaryName: "print", Source: []string{zbxpkg.HISTORY}}}
+	running := hi.config
+	var created []*startedObserver
+	hi.newObserver = func(target config.Target) (config.Observer, error) {
+		if target.UniqueName == "broken" {
+			return nil, errors.New("plugin failed to initialize")
+		}
+		o := &startedObserver{fakeObserver: fakeObserver{name: target.UniqueName}}
+		created = append(created, o)
+		return o, nil
+	}
+	added := config.Target{
+		UniqueName:       "added",
+		PluginBinaryName: "print",
+		Source:           []string{zbxpkg.HISTORY, zbxpkg.TREND},
+		Delivery:         config.DeliveryConf{Workers: 1, QueueDepth: 1},
+	}
+
+	// a target failing to start cleans up observers created so far and keeps the running config
+	failing := running
+	failing.Targets = []config.Target{added, {UniqueName: "broken", PluginBinaryName: "print", Source: []string{zbxpkg.HISTORY}}}
+	failing.Filter = filter.FilterConfig{Accepted: []string{"env:prod"}}
+	require.ErrorContains(t, hi.Reload(failing), "plugin failed to initialize")
+	require.Equal(t, running.Targets, hi.config.Targets)
+	require.Equal(t, running.Filter, hi.config.Filter)
+	require.Len(t, created, 2)
+	for _, o := range created {
+		require.False(t, o.started.Load())
+		require.True(t, o.cleaned.Load())
+	}
+	require.Equal(t, http.StatusOK, postHistory(hi, 1))
+	waitHistory(t, observer, 1)
+
+	// otherwise the new target is started and gets values along with the running one
+	created = nil
+	next := running
+	next.Targets = append([]config.Target{added}, running.Targets...)
+	require.NoError(t, hi.Reload(next))
+	require.Equal(t, next.Targets, hi.config.Targets)
+	require.Len(t, created, 2)
+	for _, o := range created {
+		require.True(t, o.started.Load())
+		require.False(t, o.cleaned.Load())
+	}
+	require.Equal(t, http.StatusOK, postHistory(hi, 1))
+
+	require.NoError(t, hi.Stop())
+	require.Len(t, observer.history, 2, "values unfiltered by the filter of the failed reload")
+	historyObserver := created[slices.IndexFunc(created, func(o *startedObserver) bool { return len(o.history) > 0 })]
+	require.Len(t, historyObserver.history, 1)
+}
+
+func TestReloadHandler(t *testing.T) {
+	calls := 0
+	handler := ReloadHandler(func() error {
+		calls++
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/reload", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 1, calls)
+}
+
+func TestAdminReloadHandler(t *testing.T) {
+	calls := 0
+	reload := func() error {
+		calls++
+		return nil
+	}
+
+	// without authentication the endpoint is not registered
+	require.Nil(t, AdminReloadHandler(config.HTTPAuthConf{}, reload))
+
+	handler := AdminReloadHandler(config.HTTPAuthConf{BearerTokens: []string{"secret"}}, reload)
+	require.NotNil(t, handler)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Equal(t, 0, calls)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	req.Header.Set(AUTHORIZATION_HDR, BEARER_PREFIX+"secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 1, calls)
+}
@@ -0,0 +1,363 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/deadletter"
+	"zms.szuro.net/internal/logger"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// ReplayOptions select archived export files and records to replay.
+type ReplayOptions struct {
+	// Paths are export files or directories containing them.
+	Paths []string
+	// From and To limit records by Clock, zero value means no limit.
+	From, To time.Time
+	// Exports limits export types to replay, empty means all.
+	Exports []string
+}
+
+// ReplaySummary reports the outcome of a replay.
+type ReplaySummary struct {
+	Files      int
+	Lines      int
+	Invalid    int
+	OutOfRange int
+	Replayed   int
+	Targets    []TargetSummary
+}
+
+// TargetSummary counts values delivered to a single target.
+type TargetSummary struct {
+	Name   string
+	Sent   int64
+	Failed int64
+}
+
+// Failed returns the number of values any target failed to accept.
+func (rs ReplaySummary) Failed() (failed int64) {
+	for _, t := range rs.Targets {
+		failed += t.Failed
+	}
+	return
+}
+
+type replayFile struct {
+	path   string
+	export string
+}
+
+// ReplayInput reads archived, possibly compressed, export files once
+// and pushes records through configured filters and targets.
+type ReplayInput struct {
+	baseInput
+	opts     ReplayOptions
+	files    []replayFile
+	entries  []deadletter.Entry
+	counters []*countingObserver
+}
+
+// NewReplayInput finds export files to replay.
+// Offline buffers and spilling are disabled, so failures are reported instead of
+// stored in data directory of a running ZMS.
+func NewReplayInput(zmsConf config.ZMSConf, opts ReplayOptions) (*ReplayInput, error) {
+	files, err := findReplayFiles(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no export files to replay")
+	}
+
+	ri := newReplayInput(zmsConf, opts)
+	ri.files = files
+	for _, f := range files {
+		ri.addSubject(f.export)
+	}
+	return ri, nil
+}
+
+// NewDeadLetterReplay sends fixed lines of dead-letter entries through
+// filters and targets the same way as NewReplayInput sends export files.
+func NewDeadLetterReplay(zmsConf config.ZMSConf, entries []deadletter.Entry) (*ReplayInput, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("no dead-letter entries to replay")
+	}
+	ri := newReplayInput(zmsConf, ReplayOptions{})
+	ri.entries = entries
+	for _, e := range entries {
+		if _, ok := exportTypes[e.Export]; !ok {
+			return nil, fmt.Errorf("unknown export type %q of entry from %s at offset %d", e.Export, e.File, e.Offset)
+		}
+		ri.addSubject(e.Export)
+	}
+	return ri, nil
+}
+
+func newReplayInput(zmsConf config.ZMSConf, opts ReplayOptions) *ReplayInput {
+	zmsConf.Targets = slices.Clone(zmsConf.Targets)
+	for i := range zmsConf.Targets {
+		zmsConf.Targets[i].OfflineBufferTime = 0
+		zmsConf.Targets[i].Delivery.Overflow = config.OVERFLOW_BLOCK
+	}
+
+	ri := &ReplayInput{opts: opts}
+	ri.config = zmsConf
+	ri.subjects = make(map[string]Subjecter)
+	return ri
+}
+
+func (ri *ReplayInput) addSubject(export string) {
+	if _, ok := ri.subjects[export]; ok {
+		return
+	}
+	subject := exportTypes[export].newSubject()
+	subject.SetFunnel(make(chan any, ri.config.BufferSize*2))
+	subject.SetBuffer(ri.config.BufferSize)
+	subject.SetFlushPolicy(ri.config.Flush.PolicyFor(export))
+	subject.SetShards(ri.config.Shards)
+	ri.subjects[export] = subject
+}
+
+// Prepare sets filters and registers targets wrapped to count delivered values.
+func (ri *ReplayInput) Prepare() {
+	ri.setFilter()
+	for _, target := range ri.config.Targets {
+		for name, subject := range ri.subjects {
+			if !slices.Contains(target.Source, name) {
+				continue
+			}
+			export := target.ForExport(name)
+			obs, err := export.ToObserver(ri.config)
+			if err != nil || obs == nil {
+				logger.Warn("Failed to register target", slog.String("name", target.UniqueName))
+				continue
+			}
+			startObserver(obs)
+			counter := &countingObserver{Observer: obs}
+			ri.counters = append(ri.counters, counter)
+			subject.Register(counter, target.Delivery)
+		}
+	}
+}
+
+// Run replays all files and waits until targets handled every record.
+func (ri *ReplayInput) Run() (summary ReplaySummary) {
+	ri.Start()
+
+	for _, f := range ri.files {
+		if err := ri.replayFile(f, &summary); err != nil {
+			logger.Error("Failed to replay file", slog.String("file", f.path), slog.Any("error", err))
+			continue
+		}
+		summary.Files++
+	}
+	ri.replayEntries(&summary)
+
+	// replay waits for targets however long it takes
+	ri.drain(context.Background())
+	ri.cleanup()
+
+	for _, c := range ri.counters {
+		i := slices.IndexFunc(summary.Targets, func(t TargetSummary) bool { return t.Name == c.GetName() })
+		if i < 0 {
+			summary.Targets = append(summary.Targets, TargetSummary{Name: c.GetName()})
+			i = len(summary.Targets) - 1
+		}
+		summary.Targets[i].Sent += c.sent.Load()
+		summary.Targets[i].Failed += c.failed.Load()
+	}
+	return summary
+}
+
+func (ri *ReplayInput) replayFile(f replayFile, summary *ReplaySummary) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	switch {
+	case strings.HasSuffix(f.path, ".gz"):
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	case strings.HasSuffix(f.path, ".zst"):
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		reader = zr
+	}
+
+	logger.Info("Replaying export file", slog.String("file", f.path), slog.String("export", f.export))
+	export := exportTypes[f.export]
+	funnel := ri.subjects[f.export].GetFunnel()
+	buf := bufio.NewReader(reader)
+	for n := 1; ; n++ {
+		line, err := buf.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			summary.Lines++
+			value, perr := export.parse(line)
+			switch {
+			case perr != nil:
+				summary.Invalid++
+				logger.Error("Failed to parse line", slog.String("file", f.path), slog.Int("line_number", n), slog.Any("error", perr))
+			case !ri.inRange(value):
+				summary.OutOfRange++
+			default:
+				summary.Replayed++
+				funnel <- value
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// replayEntries sends lines of dead-letter entries. Lines still invalid are logged and counted.
+func (ri *ReplayInput) replayEntries(summary *ReplaySummary) {
+	for _, e := range ri.entries {
+		summary.Lines++
+		value, err := exportTypes[e.Export].parse([]byte(e.Line))
+		switch {
+		case err != nil:
+			summary.Invalid++
+			logger.Error("Failed to parse dead-letter line", slog.String("file", e.File), slog.Int64("offset", e.Offset), slog.Any("error", err))
+		case !ri.inRange(value):
+			summary.OutOfRange++
+		default:
+			summary.Replayed++
+			ri.subjects[e.Export].GetFunnel() <- value
+		}
+	}
+}
+
+func (ri *ReplayInput) inRange(value any) bool {
+	var clock int64
+	switch v := value.(type) {
+	case zbxpkg.History:
+		clock = v.Clock
+	case zbxpkg.Trend:
+		clock = v.Clock
+	case zbxpkg.Event:
+		clock = v.Clock
+	}
+	if !ri.opts.From.IsZero() && clock < ri.opts.From.Unix() {
+		return false
+	}
+	if !ri.opts.To.IsZero() && clock > ri.opts.To.Unix() {
+		return false
+	}
+	return true
+}
+
+// replayExportType tells the export type from the name of an export file,
+// e.g. history-history-syncer-1.ndjson.1.gz is a history export.
+func replayExportType(path string) (string, bool) {
+	base := filepath.Base(path)
+	switch {
+	case strings.HasPrefix(base, "history-"):
+		return zbxpkg.HISTORY, true
+	case strings.HasPrefix(base, "trends-"):
+		return zbxpkg.TREND, true
+	case strings.HasPrefix(base, "problems-"):
+		return zbxpkg.EVENT, true
+	}
+	return "", false
+}
+
+// findReplayFiles expands directories and keeps files of selected export types.
+// Files named explicitly must be recognized export files, unknown files in directories are skipped.
+func findReplayFiles(opts ReplayOptions) (files []replayFile, err error) {
+	add := func(path string, explicit bool) error {
+		export, ok := replayExportType(path)
+		if !ok {
+			if explicit {
+				return fmt.Errorf("unknown export type of %s", path)
+			}
+			return nil
+		}
+		if len(opts.Exports) == 0 || slices.Contains(opts.Exports, export) {
+			files = append(files, replayFile{path: path, export: export})
+		}
+		return nil
+	}
+
+	for _, p := range opts.Paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if err := add(p, true); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				add(filepath.Join(p, e.Name()), false)
+			}
+		}
+	}
+
+	slices.SortStableFunc(files, func(a, b replayFile) int { return strings.Compare(a.path, b.path) })
+	return files, nil
+}
+
+// countingObserver counts values an observer accepted or failed to accept.
+type countingObserver struct {
+	config.Observer
+	sent, failed atomic.Int64
+}
+
+func (c *countingObserver) count(n int, ok bool) bool {
+	if ok {
+		c.sent.Add(int64(n))
+	} else {
+		c.failed.Add(int64(n))
+	}
+	return ok
+}
+
+func (c *countingObserver) SaveHistory(h []zbxpkg.History) bool {
+	return c.count(len(h), c.Observer.SaveHistory(h))
+}
+
+func (c *countingObserver) SaveTrends(t []zbxpkg.Trend) bool {
+	return c.count(len(t), c.Observer.SaveTrends(t))
+}
+
+func (c *countingObserver) SaveEvents(e []zbxpkg.Event) bool {
+	return c.count(len(e), c.Observer.SaveEvents(e))
+}
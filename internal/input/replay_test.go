@@ -0,0 +1,178 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/deadletter"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// fakeObserver records everything it was asked to save.
+type fakeObserver struct {
+	name    string
+	fail    bool
+	mu      sync.Mutex
+	history []zbxpkg.History
+	trends  []zbxpkg.Trend
+	events  []zbxpkg.Event
+}
+
+func (f *fakeObserver) Cleanup()        {}
+func (f *fakeObserver) GetName() string { return f.name }
+func (f *fakeObserver) SaveHistory(h []zbxpkg.History) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.history = append(f.history, h...)
+	return !f.fail
+}
+func (f *fakeObserver) SaveTrends(t []zbxpkg.Trend) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.trends = append(f.trends, t...)
+	return !f.fail
+}
+func (f *fakeObserver) SaveEvents(e []zbxpkg.Event) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, e...)
+	return !f.fail
+}
+
+const replayHistory = `{"itemid":1,"clock":1700000000,"ns":1,"value":1,"type":3}
+{"itemid":1,"clock":1700000060,"ns":1,"value":2,"type":3}
+not json
+{"itemid":1,"clock":1700000120,"ns":1,"value":3,"type":3}
+`
+
+const replayTrends = `{"itemid":2,"clock":1700000000,"count":60,"min":1,"max":3,"avg":2,"type":0}
+`
+
+const replayEvents = `{"clock":1700000000,"ns":0,"value":1,"eventid":5,"name":"problem","severity":3}
+{"clock":1700000060,"ns":0,"value":0,"eventid":6,"p_eventid":5}
+`
+
+func writeReplayFiles(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "history-history-syncer-1.ndjson.old"), []byte(replayHistory), 0o600))
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, err := gw.Write([]byte(replayTrends))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "trends-history-syncer-1.ndjson.1.gz"), gz.Bytes(), 0o600))
+
+	var zs bytes.Buffer
+	zw, err := zstd.NewWriter(&zs)
+	require.NoError(t, err)
+	_, err = zw.Write([]byte(replayEvents))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "problems-main-process-0.ndjson.zst"), zs.Bytes(), 0o600))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not an export"), 0o600))
+	return dir
+}
+
+// registerFake registers the observer the way Prepare does, without plugins.
+func registerFake(ri *ReplayInput, obs *fakeObserver) {
+	for _, subject := range ri.subjects {
+		counter := &countingObserver{Observer: obs}
+		ri.counters = append(ri.counters, counter)
+		subject.Register(counter, config.DeliveryConf{QueueDepth: 1, Workers: 1})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir := writeReplayFiles(t)
+	ri, err := NewReplayInput(config.ZMSConf{BufferSize: 2}, ReplayOptions{Paths: []string{dir}})
+	require.NoError(t, err)
+	require.Len(t, ri.files, 3)
+
+	ri.setFilter()
+	obs := &fakeObserver{name: "fake"}
+	registerFake(ri, obs)
+	summary := ri.Run()
+
+	require.Equal(t, 3, summary.Files)
+	require.Equal(t, 7, summary.Lines)
+	require.Equal(t, 1, summary.Invalid)
+	require.Equal(t, 6, summary.Replayed)
+	require.Equal(t, []TargetSummary{{Name: "fake", Sent: 6}}, summary.Targets)
+	require.Len(t, obs.history, 3)
+	require.Len(t, obs.trends, 1)
+	require.Len(t, obs.events, 2)
+}
+
+func TestReplay_RangeAndExports(t *testing.T) {
+	dir := writeReplayFiles(t)
+	ri, err := NewReplayInput(config.ZMSConf{BufferSize: 10}, ReplayOptions{
+		Paths:   []string{dir},
+		From:    time.Unix(1700000060, 0),
+		To:      time.Unix(1700000060, 0),
+		Exports: []string{zbxpkg.HISTORY, zbxpkg.EVENT},
+	})
+	require.NoError(t, err)
+	require.Len(t, ri.files, 2)
+
+	ri.setFilter()
+	obs := &fakeObserver{name: "fake", fail: true}
+	registerFake(ri, obs)
+	summary := ri.Run()
+
+	require.Equal(t, 3, summary.OutOfRange)
+	require.Equal(t, 2, summary.Replayed)
+	require.Equal(t, int64(2), summary.Failed())
+	require.Equal(t, int64(1700000060), obs.history[0].Clock)
+	require.Equal(t, int64(6), obs.events[0].EventID)
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	entries := []deadletter.Entry{
+		{Export: zbxpkg.HISTORY, File: "history-history-syncer-1.ndjson", Offset: 0, Line: `{"itemid":1,"clock":1700000000,"ns":1,"value":1,"type":3}`},
+		{Export: zbxpkg.HISTORY, File: "history-history-syncer-1.ndjson", Offset: 60, Line: `{"itemid":2,"clock":`},
+		{Export: zbxpkg.EVENT, File: "problems-task-manager-1.ndjson", Offset: 0, Line: `{"clock":1700000000,"ns":0,"value":1,"eventid":5,"name":"problem","severity":3}`},
+	}
+	ri, err := NewDeadLetterReplay(config.ZMSConf{BufferSize: 10}, entries)
+	require.NoError(t, err)
+
+	ri.setFilter()
+	obs := &fakeObserver{name: "fake"}
+	registerFake(ri, obs)
+	summary := ri.Run()
+
+	require.Equal(t, 3, summary.Lines)
+	require.Equal(t, 1, summary.Invalid)
+	require.Equal(t, 2, summary.Replayed)
+	require.Len(t, obs.history, 1)
+	require.Len(t, obs.events, 1)
+
+	_, err = NewDeadLetterReplay(config.ZMSConf{}, []deadletter.Entry{{Export: "unknown"}})
+	require.Error(t, err)
+	_, err = NewDeadLetterReplay(config.ZMSConf{}, nil)
+	require.Error(t, err)
+}
+
+func TestReplay_Files(t *testing.T) {
+	dir := writeReplayFiles(t)
+
+	_, err := NewReplayInput(config.ZMSConf{}, ReplayOptions{Paths: []string{filepath.Join(dir, "README")}})
+	require.Error(t, err)
+
+	_, err = NewReplayInput(config.ZMSConf{}, ReplayOptions{Paths: []string{dir}, Exports: []string{"unknown"}})
+	require.Error(t, err)
+
+	ri, err := NewReplayInput(config.ZMSConf{}, ReplayOptions{Paths: []string{filepath.Join(dir, "trends-history-syncer-1.ndjson.1.gz")}})
+	require.NoError(t, err)
+	require.Equal(t, []replayFile{{path: filepath.Join(dir, "trends-history-syncer-1.ndjson.1.gz"), export: zbxpkg.TREND}}, ri.files)
+}
@@ -0,0 +1,356 @@
+package input
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/pkg/filter"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+const (
+	// SAMPLING_RELEASE_DELAY is how long values held for an interval wait for late values
+	// after the interval ended.
+	SAMPLING_RELEASE_DELAY = 10 * time.Second
+	// SAMPLING_RELEASE_INTERVAL is how often intervals are checked for held values to release.
+	SAMPLING_RELEASE_INTERVAL = time.Second
+)
+
+var samplingDropped = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "zms_sampling_dropped_total",
+		Help: "Number of values dropped by a sampling rule of a target",
+	},
+	[]string{"target_name", "export_type", "rule"},
+)
+
+// sampler applies sampling rules of a target to values before they are queued.
+// Rules are applied in order, values dropped by a rule do not reach the following ones.
+//
+// A batch is not acknowledged before values held from it until the end of their interval
+// were delivered, so they are read again after a crash. Offsets are not committed past
+// held values meanwhile, so long intervals make more values read again after a restart.
+type sampler[T zbxpkg.Export] struct {
+	mu    sync.Mutex
+	rules []*samplingRule
+	now   func() time.Time
+}
+
+// newSampler returns nil when there are no rules.
+func newSampler[T zbxpkg.Export](rules []config.SamplingRule, labels prometheus.Labels) *sampler[T] {
+	if len(rules) == 0 {
+		return nil
+	}
+	s := &sampler[T]{now: time.Now}
+	for _, conf := range rules {
+		// filters were validated when the configuration was loaded, no filter accepts everything
+		f, _ := filter.NewFilter(conf.Filter)
+		dropped := samplingDropped.With(prometheus.Labels{
+			"target_name": labels["target_name"],
+			"export_type": labels["export_type"],
+			"rule":        conf.Name,
+		})
+		dropped.Add(0)
+		s.rules = append(s.rules, &samplingRule{
+			conf:    conf,
+			filter:  f,
+			windows: make(map[int64]*sampleWindow),
+			seconds: make(map[string]*hostSecond),
+			dropped: dropped,
+		})
+	}
+	return s
+}
+
+// holds reports whether any rule holds values until the end of their interval.
+func (s *sampler[T]) holds() bool {
+	for _, r := range s.rules {
+		if r.conf.Holds() {
+			return true
+		}
+	}
+	return false
+}
+
+// batchAck acknowledges a batch once it was delivered and values held from it were released.
+type batchAck struct {
+	pending atomic.Int32
+	ack     func()
+}
+
+func newBatchAck(ack func()) *batchAck {
+	a := &batchAck{ack: ack}
+	a.pending.Store(1)
+	return a
+}
+
+// hold delays the acknowledgement until a held value is done.
+func (a *batchAck) hold() {
+	a.pending.Add(1)
+}
+
+func (a *batchAck) done() {
+	if a.pending.Add(-1) == 0 && a.ack != nil {
+		a.ack()
+	}
+}
+
+// sampleOutput holds values passing all rules, along with the batch they were sampled from
+// and batches released values among them came in.
+type sampleOutput[T zbxpkg.Export] struct {
+	values []T
+	batch  *batchAck // nil for released values only
+	acks   []*batchAck
+}
+
+// ack is called once the values were handled.
+func (o sampleOutput[T]) ack() {
+	if o.batch != nil {
+		o.batch.done()
+	}
+	for _, a := range o.acks {
+		a.done()
+	}
+}
+
+// sample returns values of the batch passing all rules, preceded by held values
+// of intervals that ended. Values held from the batch delay its acknowledgement.
+func (s *sampler[T]) sample(batch []T, ack *batchAck) sampleOutput[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	out := sampleOutput[T]{values: make([]T, 0, len(batch)), batch: ack}
+	for _, v := range batch {
+		s.apply(&out, v, ack, false, 0, now)
+	}
+	return out
+}
+
+// release returns held values of intervals that ended before now, less the release delay.
+func (s *sampler[T]) release(now time.Time) sampleOutput[T] {
+	return s.releaseBefore(now.Add(-SAMPLING_RELEASE_DELAY).UnixNano())
+}
+
+// flush returns all held values, e.g. before the queue is closed.
+func (s *sampler[T]) flush() sampleOutput[T] {
+	return s.releaseBefore(1<<63 - 1)
+}
+
+func (s *sampler[T]) releaseBefore(end int64) sampleOutput[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var out sampleOutput[T]
+	for i, r := range s.rules {
+		for _, h := range r.releaseBefore(end) {
+			if v, ok := any(h.history).(T); ok {
+				s.apply(&out, v, h.ack, true, i+1, now)
+			}
+		}
+	}
+	return out
+}
+
+// apply passes the value through rules starting at the given one and appends it to out
+// if it passed all of them. Values released by a rule go through the following rules first,
+// as they are older. A released value carries a hold of its batch, which is passed on
+// with the value, or given up if the value was dropped or held again by another rule.
+// Must be called with the lock held.
+func (s *sampler[T]) apply(out *sampleOutput[T], v T, ack *batchAck, released bool, from int, now time.Time) {
+	for i := from; i < len(s.rules); i++ {
+		keep, held := s.rules[i].apply(v, ack, now)
+		for _, h := range held {
+			if r, ok := any(h.history).(T); ok {
+				s.apply(out, r, h.ack, true, i+1, now)
+			}
+		}
+		if !keep {
+			if released {
+				ack.done()
+			}
+			return
+		}
+	}
+	out.values = append(out.values, v)
+	if released {
+		out.acks = append(out.acks, ack)
+	}
+}
+
+// samplingRule keeps the state of a rule. It is guarded by the lock of the sampler.
+type samplingRule struct {
+	conf    config.SamplingRule
+	filter  filter.Filter
+	windows map[int64]*sampleWindow
+	seconds map[string]*hostSecond
+	dropped prometheus.Counter
+}
+
+// sampleWindow is the latest interval of an item a value was seen in.
+type sampleWindow struct {
+	index int64
+	held  *heldValue // nil once released, or with keep first
+}
+
+// heldValue is a value held until the end of its interval, holding the acknowledgement of its batch.
+type heldValue struct {
+	history zbxpkg.History
+	ack     *batchAck
+}
+
+// hostSecond counts values of a host in the current second.
+type hostSecond struct {
+	second int64
+	count  int
+}
+
+// apply reports whether the value passes the rule, and returns values it held before and released now.
+func (r *samplingRule) apply(v any, ack *batchAck, now time.Time) (keep bool, released []heldValue) {
+	if !accepts(r.filter, v) {
+		return true, nil
+	}
+	keep = true
+	if h, ok := v.(zbxpkg.History); ok && r.conf.Interval > 0 {
+		keep, released = r.sample(h, ack)
+	}
+	if keep && r.conf.MaxPerSecond > 0 {
+		keep = r.limit(v, now)
+	}
+	return keep, released
+}
+
+// sample keeps one value per item and interval of the clock of values.
+func (r *samplingRule) sample(h zbxpkg.History, ack *batchAck) (keep bool, released []heldValue) {
+	index := (h.Clock*int64(time.Second) + h.Ns) / int64(r.conf.Interval)
+	w := r.windows[h.ItemID]
+	switch {
+	case w == nil || index > w.index:
+		if w != nil && w.held != nil {
+			released = append(released, *w.held)
+		}
+		w = &sampleWindow{index: index}
+		r.windows[h.ItemID] = w
+		if r.conf.Keep == config.SAMPLE_FIRST {
+			return true, released
+		}
+		ack.hold()
+		w.held = &heldValue{history: h, ack: ack}
+		return false, released
+	case index < w.index || w.held == nil:
+		// late for an interval already sampled
+		r.dropped.Inc()
+		return false, released
+	}
+
+	if r.replaces(h, w.held.history) {
+		ack.hold()
+		w.held.ack.done()
+		w.held = &heldValue{history: h, ack: ack}
+	}
+	r.dropped.Inc()
+	return false, released
+}
+
+// replaces reports whether the value is kept instead of the held one.
+// min and max fall back to the last value for values that are not numbers.
+func (r *samplingRule) replaces(h, held zbxpkg.History) bool {
+	if r.conf.Keep == config.SAMPLE_LAST {
+		return true
+	}
+	value, ok := numericValue(h.Value)
+	heldNumber, heldOk := numericValue(held.Value)
+	if !ok || !heldOk {
+		return true
+	}
+	if r.conf.Keep == config.SAMPLE_MIN {
+		return value < heldNumber
+	}
+	return value > heldNumber
+}
+
+// limit caps values per host and second.
+func (r *samplingRule) limit(v any, now time.Time) bool {
+	host := hostOf(v)
+	if host == "" {
+		return true
+	}
+	second := now.Unix()
+	s := r.seconds[host]
+	if s == nil {
+		s = &hostSecond{}
+		r.seconds[host] = s
+	}
+	if s.second != second {
+		s.second = second
+		s.count = 0
+	}
+	if s.count >= r.conf.MaxPerSecond {
+		r.dropped.Inc()
+		return false
+	}
+	s.count++
+	return true
+}
+
+// releaseBefore returns held values of intervals ending before end, in nanoseconds.
+// Intervals are kept, so late values of them are still dropped.
+func (r *samplingRule) releaseBefore(end int64) []heldValue {
+	var released []heldValue
+	for _, w := range r.windows {
+		if w.held != nil && (w.index+1)*int64(r.conf.Interval) <= end {
+			released = append(released, *w.held)
+			w.held = nil
+		}
+	}
+	slices.SortFunc(released, func(a, b heldValue) int { return cmp.Compare(a.history.Clock, b.history.Clock) })
+	return released
+}
+
+func accepts(f filter.Filter, v any) bool {
+	switch v := v.(type) {
+	case zbxpkg.History:
+		return f.AcceptHistory(v)
+	case zbxpkg.Trend:
+		return f.AcceptTrend(v)
+	case zbxpkg.Event:
+		return f.AcceptEvent(v)
+	}
+	return false
+}
+
+// hostOf returns the technical name of the host of a value, or of the first host of an event.
+func hostOf(v any) string {
+	switch v := v.(type) {
+	case zbxpkg.History:
+		if v.Host != nil {
+			return v.Host.Host
+		}
+	case zbxpkg.Trend:
+		if v.Host != nil {
+			return v.Host.Host
+		}
+	case zbxpkg.Event:
+		if len(v.Hosts) > 0 {
+			return v.Hosts[0].Host
+		}
+	}
+	return ""
+}
+
+func numericValue(v json.Token) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,175 @@
+package input
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/pkg/filter"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func testSampler(t *testing.T, rules ...config.SamplingRule) *sampler[zbxpkg.History] {
+	t.Helper()
+	return newSampler[zbxpkg.History](rules, prometheus.Labels{"target_name": t.Name(), "export_type": zbxpkg.HISTORY})
+}
+
+func sampled(item, clock int64, v string) zbxpkg.History {
+	return zbxpkg.History{ItemID: item, Clock: clock, Value: json.Number(v), Host: &zbxpkg.Host{Host: "host"}}
+}
+
+func values(history []zbxpkg.History) []string {
+	var v []string
+	for _, h := range history {
+		v = append(v, string(h.Value.(json.Number)))
+	}
+	return v
+}
+
+func TestSampler_Keep(t *testing.T) {
+	batch := []zbxpkg.History{
+		sampled(1, 0, "5"), sampled(1, 20, "9"), sampled(1, 40, "1"),
+		sampled(1, 60, "7"), sampled(1, 61, "3"),
+		// late for the first minute
+		sampled(1, 59, "100"),
+		sampled(1, 120, "4"),
+	}
+	tests := map[string][]string{
+		config.SAMPLE_FIRST: {"5", "7", "4"},
+		config.SAMPLE_LAST:  {"1", "3"},
+		config.SAMPLE_MIN:   {"1", "3"},
+		config.SAMPLE_MAX:   {"9", "7"},
+	}
+	for keep, expected := range tests {
+		t.Run(keep, func(t *testing.T) {
+			s := testSampler(t, config.SamplingRule{Name: keep, Interval: time.Minute, Keep: keep})
+			require.Equal(t, expected, values(s.sample(batch, newBatchAck(nil)).values))
+			// the value held for the last minute is released on close
+			if keep != config.SAMPLE_FIRST {
+				require.Equal(t, []string{"4"}, values(s.flush().values))
+			}
+			require.Empty(t, s.flush().values)
+		})
+	}
+}
+
+func TestSampler_ItemsAndRelease(t *testing.T) {
+	s := testSampler(t, config.SamplingRule{Name: "last", Interval: time.Minute, Keep: config.SAMPLE_LAST})
+	dropped := counterValue(t, s.rules[0].dropped)
+	start := time.Unix(1700000040, 0) // start of a minute
+	minute := start.Unix()
+
+	require.Empty(t, s.sample([]zbxpkg.History{sampled(1, minute, "1"), sampled(2, minute, "2"), sampled(1, minute+1, "3")}, newBatchAck(nil)).values)
+	// not released before the minute ended and late values had time to arrive
+	require.Empty(t, s.release(start.Add(time.Minute)).values)
+	require.Equal(t, []string{"2", "3"}, values(s.release(start.Add(time.Minute+SAMPLING_RELEASE_DELAY)).values))
+	// late values of a released minute are dropped
+	require.Empty(t, s.sample([]zbxpkg.History{sampled(1, minute+2, "4")}, newBatchAck(nil)).values)
+	require.Empty(t, s.flush().values)
+	require.Equal(t, dropped+2, counterValue(t, s.rules[0].dropped))
+}
+
+func TestSampler_MaxPerSecond(t *testing.T) {
+	s := testSampler(t, config.SamplingRule{Name: "cap", MaxPerSecond: 2})
+	dropped := counterValue(t, s.rules[0].dropped)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	other := zbxpkg.History{ItemID: 9, Host: &zbxpkg.Host{Host: "other"}}
+	noHost := zbxpkg.History{ItemID: 10}
+	batch := []zbxpkg.History{sampled(1, 0, "1"), sampled(2, 0, "2"), sampled(3, 0, "3"), other, noHost}
+	require.Len(t, s.sample(batch, newBatchAck(nil)).values, 4)
+	require.Equal(t, dropped+1, counterValue(t, s.rules[0].dropped))
+
+	now = now.Add(time.Second)
+	require.Len(t, s.sample(batch, newBatchAck(nil)).values, 4)
+}
+
+func TestSampler_Rules(t *testing.T) {
+	// only items tagged for sampling are sampled, the cap applies to all values
+	s := testSampler(t,
+		config.SamplingRule{Name: "minute", Interval: time.Minute, Keep: config.SAMPLE_FIRST, Filter: filter.FilterConfig{Accepted: []string{"sample"}}},
+		config.SamplingRule{Name: "cap", MaxPerSecond: 3},
+	)
+	s.now = func() time.Time { return time.Unix(1700000000, 0) }
+	minuteDropped, capDropped := counterValue(t, s.rules[0].dropped), counterValue(t, s.rules[1].dropped)
+	tagged := func(item, clock int64) zbxpkg.History {
+		h := sampled(item, clock, "1")
+		h.Tags = []zbxpkg.Tag{{Tag: "sample", Value: "1m"}}
+		return h
+	}
+
+	out := s.sample([]zbxpkg.History{tagged(1, 0), tagged(1, 1), sampled(2, 0, "1"), sampled(2, 1, "1"), sampled(2, 2, "1")}, newBatchAck(nil)).values
+	require.Len(t, out, 3)
+	require.Equal(t, minuteDropped+1, counterValue(t, s.rules[0].dropped))
+	require.Equal(t, capDropped+1, counterValue(t, s.rules[1].dropped))
+}
+
+func TestDeliveryQueue_Sampling(t *testing.T) {
+	observer := &fakeObserver{name: "longterm"}
+	q := NewDeliveryQueue[zbxpkg.History](observer, config.DeliveryConf{
+		Workers:    1,
+		QueueDepth: 4,
+		Sampling:   []config.SamplingRule{{Name: "max", Interval: time.Minute, Keep: config.SAMPLE_MAX}},
+	})
+
+	acked := 0
+	q.Enqueue([]zbxpkg.History{sampled(1, 0, "1"), sampled(1, 1, "5"), sampled(1, 2, "2")}, func() { acked++ })
+	q.Enqueue([]zbxpkg.History{sampled(1, 60, "3")}, func() { acked++ })
+	q.Close()
+
+	require.Equal(t, 2, acked)
+	require.Equal(t, []string{"5", "3"}, values(observer.history))
+}
+
+func TestSampler_HeldValuesDelayAck(t *testing.T) {
+	s := testSampler(t,
+		config.SamplingRule{Name: "minute", Interval: time.Minute, Keep: config.SAMPLE_LAST},
+		config.SamplingRule{Name: "hour", Interval: time.Hour, Keep: config.SAMPLE_MAX},
+	)
+	acked := map[string]bool{}
+	ackOf := func(name string) *batchAck { return newBatchAck(func() { acked[name] = true }) }
+
+	first := s.sample([]zbxpkg.History{sampled(1, 0, "1"), sampled(1, 1, "2")}, ackOf("first"))
+	require.Empty(t, first.values)
+	first.ack()
+	require.False(t, acked["first"], "value held from the batch was not delivered yet")
+
+	// the value of the first minute is released to the hourly rule, which holds it again
+	second := s.sample([]zbxpkg.History{sampled(1, 60, "3")}, ackOf("second"))
+	second.ack()
+	require.False(t, acked["first"])
+
+	// a bigger value replaces it in the hourly rule, so nothing of the first batch is held anymore
+	third := s.sample([]zbxpkg.History{sampled(1, 120, "5")}, ackOf("third"))
+	third.ack()
+	require.True(t, acked["first"])
+	require.False(t, acked["second"])
+
+	flushed := s.flush()
+	require.Equal(t, []string{"5"}, values(flushed.values))
+	require.True(t, acked["second"])
+	require.False(t, acked["third"], "acknowledged once the flushed values were handled")
+	flushed.ack()
+	require.True(t, acked["third"])
+}
+
+func TestDeliveryQueue_SamplingAbortLeavesHeldBatchUnacknowledged(t *testing.T) {
+	observer := &fakeObserver{name: "longterm"}
+	q := NewDeliveryQueue[zbxpkg.History](observer, config.DeliveryConf{
+		Workers:    1,
+		QueueDepth: 4,
+		Sampling:   []config.SamplingRule{{Name: "last", Interval: time.Minute, Keep: config.SAMPLE_LAST}},
+	})
+
+	var acked atomic.Int32
+	q.Enqueue([]zbxpkg.History{sampled(1, 0, "1"), sampled(1, 1, "2")}, func() { acked.Add(1) })
+	// a crash loses the held value, the batch is read again after restart
+	q.Abort()
+	require.Zero(t, acked.Load())
+	require.Empty(t, observer.history)
+}
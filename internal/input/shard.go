@@ -0,0 +1,245 @@
+package input
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// barrier follows values routed to a shard. ack is called once the shard
+// delivered all values it received before the barrier.
+type barrier struct {
+	ack func()
+}
+
+// shardOf picks the shard of a value by item, so values of an item are handled in order.
+// Recovery events go to the shard of the problem they resolve.
+func shardOf(v any, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	var key int64
+	switch v := v.(type) {
+	case zbxpkg.History:
+		key = v.ItemID
+	case zbxpkg.Trend:
+		key = v.ItemID
+	case zbxpkg.Event:
+		key = v.EventID
+		if v.PEventID != 0 {
+			key = v.PEventID
+		}
+	}
+	return int(uint64(key) % uint64(shards))
+}
+
+// shard filters and buffers a part of values of a subject.
+// Its state is owned by the goroutine running it.
+type shard[T zbxpkg.Export] struct {
+	subject       *Subject[T]
+	in            chan any // values and barriers, in the order they were routed
+	done          chan struct{}
+	values        []T
+	bufferBytes   int
+	ageTimer      *time.Timer
+	ageTimerArmed bool
+	acks          ackOrder // batches and barriers waiting for acknowledgements
+	barriers      []func() // barriers following values in the buffer
+	hashes        [][]byte // hashes of buffered values, persisted once they are delivered
+	usage         prometheus.Gauge
+	routed        prometheus.Counter
+}
+
+func newShard[T zbxpkg.Export](subject *Subject[T], export string, index int) *shard[T] {
+	sh := &shard[T]{
+		subject: subject,
+		in:      make(chan any, max(subject.buffer, 1)),
+		done:    make(chan struct{}),
+		usage:   bufferUsage.WithLabelValues(export, strconv.Itoa(index)),
+		routed:  shardValues.WithLabelValues(export, strconv.Itoa(index)),
+	}
+	sh.usage.Set(0)
+	if subject.flushPolicy.MaxAge > 0 {
+		sh.ageTimer = time.NewTimer(subject.flushPolicy.MaxAge)
+		sh.ageTimer.Stop()
+	}
+	return sh
+}
+
+func (sh *shard[T]) route(v T) {
+	sh.routed.Inc()
+	sh.in <- v
+}
+
+// close flushes values left in the buffer and waits until the shard stopped.
+func (sh *shard[T]) close() {
+	close(sh.in)
+	<-sh.done
+}
+
+func (sh *shard[T]) run() {
+	defer close(sh.done)
+	for {
+		select {
+		case m, ok := <-sh.in:
+			if !ok {
+				sh.flush(FLUSH_CLOSED)
+				return
+			}
+			switch m := m.(type) {
+			case barrier:
+				sh.barrier(m.ack)
+			case T:
+				sh.accept(m)
+			}
+		case <-sh.ageTimerC():
+			sh.flush(FLUSH_AGE)
+		}
+	}
+}
+
+func (sh *shard[T]) accept(v T) {
+	var accepted bool
+	globalFilter := sh.subject.filter()
+	switch h := any(v).(type) {
+	case zbxpkg.History:
+		accepted = globalFilter.AcceptHistory(h)
+	case zbxpkg.Trend:
+		accepted = globalFilter.AcceptTrend(h)
+	case zbxpkg.Event:
+		accepted = globalFilter.AcceptEvent(h)
+	}
+	if !accepted {
+		return
+	}
+	if dedup := sh.subject.dedup; dedup != nil {
+		hash := v.Hash()
+		if dedup.Seen(hash) {
+			return
+		}
+		if dedup.Persisted() {
+			sh.hashes = append(sh.hashes, hash)
+		}
+	}
+	sh.values = append(sh.values, v)
+	usage := len(sh.values)
+	sh.usage.Set(float64(usage))
+
+	sh.armAgeTimer()
+
+	policy := sh.subject.flushPolicy
+	if policy.MaxBytes > 0 {
+		sh.bufferBytes += approxSize(v)
+	}
+
+	switch {
+	case usage >= sh.subject.buffer:
+		sh.flush(FLUSH_RECORDS)
+	case policy.MaxBytes > 0 && sh.bufferBytes >= policy.MaxBytes:
+		sh.flush(FLUSH_BYTES)
+	}
+}
+
+// barrier is acknowledged after values in the buffer, or after batches already flushed if it is empty.
+func (sh *shard[T]) barrier(ack func()) {
+	if len(sh.values) > 0 {
+		sh.barriers = append(sh.barriers, ack)
+		return
+	}
+	sh.acks.add(0, ack)
+}
+
+// flush hands buffered values over to every observer's delivery queue and empties the buffer.
+// The subject lock is not held while enqueuing, so a target blocking its queue does not
+// block reloads removing it. A queue closed in the meantime drops and acknowledges the batch.
+func (sh *shard[T]) flush(reason string) {
+	if sh.ageTimer != nil {
+		sh.ageTimer.Stop()
+		sh.ageTimerArmed = false
+	}
+	if len(sh.values) == 0 {
+		return
+	}
+	queues := sh.subject.snapshotQueues()
+	var delivered func()
+	if hashes := sh.hashes; len(hashes) > 0 {
+		delivered = func() { sh.subject.dedup.Persist(hashes) }
+		sh.hashes = nil
+	}
+	var ack func()
+	if sh.subject.checkpointer != nil || delivered != nil {
+		ack = sh.acks.add(len(queues), delivered)
+	}
+	for _, q := range queues {
+		q.Enqueue(sh.values, ack)
+	}
+	for _, b := range sh.barriers {
+		sh.acks.add(0, b)
+	}
+	sh.barriers = nil
+	sh.values = nil
+	sh.bufferBytes = 0
+	sh.usage.Set(0)
+	sh.subject.flushCounter.WithLabelValues(reason).Inc()
+}
+
+// armAgeTimer starts counting the age of the buffer, unless it is already running.
+func (sh *shard[T]) armAgeTimer() {
+	if sh.ageTimer != nil && !sh.ageTimerArmed {
+		sh.ageTimer.Reset(sh.subject.flushPolicy.MaxAge)
+		sh.ageTimerArmed = true
+	}
+}
+
+// ageTimerC returns the channel of the age timer, or nil when age based flushing is disabled.
+func (sh *shard[T]) ageTimerC() <-chan time.Time {
+	if sh.ageTimer == nil {
+		return nil
+	}
+	return sh.ageTimer.C
+}
+
+// ackOrder calls done functions of entries in the order they were added,
+// once an entry and all entries before it were acknowledged.
+type ackOrder struct {
+	mu      sync.Mutex
+	pending []*ackEntry
+}
+
+type ackEntry struct {
+	remaining int
+	done      func()
+}
+
+// add appends an entry waiting for the given number of acknowledgements.
+// The returned function must be called once per acknowledgement.
+func (o *ackOrder) add(acks int, done func()) (ack func()) {
+	e := &ackEntry{remaining: acks, done: done}
+
+	o.mu.Lock()
+	o.pending = append(o.pending, e)
+	o.advance()
+	o.mu.Unlock()
+
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		e.remaining--
+		o.advance()
+	}
+}
+
+// advance completes acknowledged entries from the head of the queue.
+// Must be called with the lock held.
+func (o *ackOrder) advance() {
+	for len(o.pending) > 0 && o.pending[0].remaining <= 0 {
+		if o.pending[0].done != nil {
+			o.pending[0].done()
+		}
+		o.pending[0] = nil
+		o.pending = o.pending[1:]
+	}
+}
@@ -0,0 +1,61 @@
+package input
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func TestSubject_RoutesItemsToShards(t *testing.T) {
+	observer := &fakeObserver{name: "target"}
+	s, done := startTestSubject(t, observer, 100, 4, nil)
+	before := make([]float64, 4)
+	for i := range before {
+		before[i] = counterValue(t, shardValues.WithLabelValues(zbxpkg.HISTORY, strconv.Itoa(i)))
+	}
+
+	// items 0-7 twice, every shard gets two items
+	for range 2 {
+		for item := range int64(8) {
+			s.Funnel <- zbxpkg.History{ItemID: item}
+		}
+	}
+	close(s.Funnel)
+	<-done
+	s.Drain()
+
+	require.Len(t, observer.history, 16)
+	for i := range before {
+		require.Equal(t, before[i]+4, counterValue(t, shardValues.WithLabelValues(zbxpkg.HISTORY, strconv.Itoa(i))), "shard %d", i)
+	}
+}
+
+func TestAckOrder(t *testing.T) {
+	var o ackOrder
+	var done []int
+	entry := func(i int) func() { return func() { done = append(done, i) } }
+
+	first := o.add(2, entry(1))
+	second := o.add(1, entry(2))
+
+	// a later entry waits for earlier ones
+	second()
+	require.Empty(t, done)
+	first()
+	require.Empty(t, done)
+	first()
+	require.Equal(t, []int{1, 2}, done)
+
+	// entries without pending acknowledgements complete right away once the queue is empty
+	o.add(0, entry(3))
+	require.Equal(t, []int{1, 2, 3}, done)
+
+	// and wait behind pending ones otherwise
+	third := o.add(1, entry(4))
+	o.add(0, entry(5))
+	require.Equal(t, []int{1, 2, 3}, done)
+	third()
+	require.Equal(t, []int{1, 2, 3, 4, 5}, done)
+}
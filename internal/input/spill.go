@@ -0,0 +1,138 @@
+package input
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+const spillExt = ".gob"
+
+func init() {
+	// History.Value holds json.Number for numeric items
+	gob.Register(json.Number(""))
+}
+
+// spillStore is an on-disk FIFO of batches.
+// Every batch is kept in a separate gob encoded file named after its sequence number,
+// so spilled batches survive restarts and are delivered in the order they were spilled.
+// A popped batch stays on disk until it is removed, so a batch the observer did not
+// accept before a crash or shutdown is read again after restart.
+type spillStore[T zbxpkg.Export] struct {
+	dir  string
+	head uint64 // sequence number of the oldest batch not popped yet
+	tail uint64 // sequence number for the next batch
+}
+
+func openSpillStore[T zbxpkg.Export](dir string) (*spillStore[T], error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("cannot create spill directory %s: %w", dir, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read spill directory %s: %w", dir, err)
+	}
+
+	var seqs []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spillExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spillExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	s := &spillStore[T]{dir: dir}
+	if len(seqs) > 0 {
+		s.head = seqs[0]
+		s.tail = seqs[len(seqs)-1] + 1
+	}
+	return s, nil
+}
+
+func (s *spillStore[T]) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spillExt))
+}
+
+// Len returns the number of spilled batches not popped yet.
+func (s *spillStore[T]) Len() int {
+	return int(s.tail - s.head)
+}
+
+// Push appends a batch to the end of the store.
+func (s *spillStore[T]) Push(batch []T) error {
+	if err := s.write(s.tail, batch); err != nil {
+		return err
+	}
+	s.tail++
+	return nil
+}
+
+// write stores the batch and syncs it to disk along with the directory entry,
+// so a batch acknowledged once it was spilled is not lost on a crash.
+func (s *spillStore[T]) write(seq uint64, batch []T) error {
+	f, err := os.OpenFile(s.path(seq), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if err = gob.NewEncoder(f).Encode(batch); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return s.syncDir()
+}
+
+// syncDir makes file creation in the store durable.
+func (s *spillStore[T]) syncDir() error {
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Pop returns the oldest batch not popped yet along with its sequence number.
+// The batch stays on disk until it is removed with Remove.
+// A batch that cannot be decoded is removed and returned as an error.
+func (s *spillStore[T]) Pop() (seq uint64, batch []T, err error) {
+	if s.Len() == 0 {
+		return 0, nil, nil
+	}
+	seq = s.head
+	s.head++
+
+	f, err := os.Open(s.path(seq))
+	if err != nil {
+		return seq, nil, err
+	}
+	defer f.Close()
+	if err = gob.NewDecoder(f).Decode(&batch); err != nil {
+		os.Remove(f.Name())
+	}
+	return
+}
+
+// Remove deletes a popped batch once it was delivered.
+func (s *spillStore[T]) Remove(seq uint64) error {
+	return os.Remove(s.path(seq))
+}
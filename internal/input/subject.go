@@ -1,34 +1,85 @@
 package input
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/zbx"
 	"zms.szuro.net/pkg/filter"
 	zbxpkg "zms.szuro.net/pkg/zbx"
 )
 
+var (
+	bufferSize = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "zms_buffer_size",
+			Help: "Size of internal ZMS buffer",
+		},
+		[]string{"export_type"},
+	)
+
+	bufferUsage = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "zms_buffer_usage",
+			Help: "Values in internal ZMS buffer by shard",
+		},
+		[]string{"export_type", "shard"},
+	)
+
+	shardValues = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zms_shard_values_total",
+			Help: "Number of values routed to a shard of internal ZMS buffer",
+		},
+		[]string{"export_type", "shard"},
+	)
+
+	bufferFlushes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zms_buffer_flushes_total",
+			Help: "Number of internal ZMS buffer flushes by reason",
+		},
+		[]string{"export_type", "reason"},
+	)
+)
+
 type Subjecter interface {
 	AcceptValues()
-	Register(observer config.Observer)
-	Deregister(observer config.Observer)
-	NotifyAll()
+	Register(observer config.Observer, delivery config.DeliveryConf)
+	Deregister(name string)
 	SetFilter(filter filter.Filter)
+	Drain()
+	Abort()
 	Cleanup()
 	SetBuffer(size int)
+	SetFlushPolicy(policy config.FlushPolicy)
+	SetShards(n int)
+	SetDedup(d *Deduplicator)
+	SetCheckpointer(c *Checkpointer)
 	GetFunnel() chan any
+	SetFunnel(funnel chan any)
 }
 
 type ObserverRegistry map[string]config.Observer
 
+// Subject routes values from its funnel to shards, which filter and buffer them
+// in parallel and hand batches over to delivery queues of observers.
+// Values are routed by item (or problem event), so their order is kept per item.
 type Subject[T zbxpkg.Export] struct {
-	observers        ObserverRegistry
-	values           []T
-	buffer           int
-	Funnel           chan any
-	globalFilter     filter.Filter
-	bufferSizeGauge  prometheus.Gauge
-	bufferUsageGauge prometheus.Gauge
+	mu              sync.RWMutex // guards observers, queues and globalFilter
+	observers       ObserverRegistry
+	queues          map[string]*DeliveryQueue[T]
+	buffer          int
+	shards          int
+	flushPolicy     config.FlushPolicy
+	checkpointer    *Checkpointer
+	dedup           *dedupSet // nil if deduplication is disabled
+	Funnel          chan any
+	globalFilter    filter.Filter
+	bufferSizeGauge prometheus.Gauge
+	flushCounter    *prometheus.CounterVec
 }
 
 func (s *Subject[T]) SetBuffer(size int) {
@@ -36,89 +87,189 @@ func (s *Subject[T]) SetBuffer(size int) {
 
 	var t T
 	exportyType := t.GetExportName()
-	bufferLabels := prometheus.Labels{"export_type": exportyType}
 
-	s.bufferSizeGauge = promauto.NewGauge(prometheus.GaugeOpts{
-		Name:        "zms_buffer_size",
-		Help:        "Size of internal ZMS buffer",
-		ConstLabels: bufferLabels,
-	})
+	s.bufferSizeGauge = bufferSize.WithLabelValues(exportyType)
 	s.bufferSizeGauge.Set(float64(size))
 
-	s.bufferUsageGauge = promauto.NewGauge(prometheus.GaugeOpts{
-		Name:        "zms_buffer_usage",
-		Help:        "Values in internal ZMS buffer",
-		ConstLabels: bufferLabels,
-	})
-	s.bufferUsageGauge.Set(0)
+	s.flushCounter = bufferFlushes.MustCurryWith(prometheus.Labels{"export_type": exportyType})
+	for _, reason := range []string{FLUSH_RECORDS, FLUSH_AGE, FLUSH_BYTES, FLUSH_CLOSED} {
+		s.flushCounter.WithLabelValues(reason).Add(0)
+	}
+}
+
+// SetFlushPolicy configures when buffered values are sent to observers.
+// Limits apply to every shard. Must be called after SetBuffer and before AcceptValues.
+func (s *Subject[T]) SetFlushPolicy(policy config.FlushPolicy) {
+	s.flushPolicy = policy
+	if policy.MaxRecords > 0 {
+		s.buffer = policy.MaxRecords
+		s.bufferSizeGauge.Set(float64(policy.MaxRecords))
+	}
 }
 
-func NewSubject[t zbxpkg.Export]() (s Subject[t]) {
-	s.observers = make(ObserverRegistry)
-	return s
+// SetShards sets the number of shards filtering and buffering values in parallel.
+// Must be called before AcceptValues, a single shard is used otherwise.
+func (s *Subject[T]) SetShards(n int) {
+	s.shards = n
 }
 
-func (bs *Subject[T]) Register(observer config.Observer) {
+func NewSubject[t zbxpkg.Export]() *Subject[t] {
+	return &Subject[t]{
+		observers: make(ObserverRegistry),
+		queues:    make(map[string]*DeliveryQueue[t]),
+	}
+}
+
+// Register adds an observer and starts a delivery queue for it.
+// It is safe to call while values are accepted.
+func (bs *Subject[T]) Register(observer config.Observer, delivery config.DeliveryConf) {
 	//nil observer check
 	if observer == nil {
 		return
 	}
-	bs.observers[observer.GetName()] = observer
+	name := observer.GetName()
+	queue := NewDeliveryQueue[T](observer, delivery)
+	bs.mu.Lock()
+	old := bs.queues[name]
+	bs.observers[name] = observer
+	bs.queues[name] = queue
+	bs.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
 }
 
-func (bs *Subject[T]) Deregister(observer config.Observer) {
-	delete(bs.observers, observer.GetName())
+// Deregister removes an observer, delivers batches already queued for it and cleans it up.
+// Batches the observer fails to accept are dropped, see DeliveryQueue.Remove.
+// It is safe to call while values are accepted.
+func (bs *Subject[T]) Deregister(name string) {
+	bs.mu.Lock()
+	q := bs.queues[name]
+	observer := bs.observers[name]
+	delete(bs.queues, name)
+	delete(bs.observers, name)
+	bs.mu.Unlock()
+	if q != nil {
+		q.Remove()
+	}
+	if observer != nil {
+		observer.Cleanup()
+	}
 }
 
-func (bs *Subject[T]) NotifyAll() {
+// SetDedup drops values seen recently, unless deduplication is disabled for the export type.
+// Must be called before AcceptValues.
+func (bs *Subject[T]) SetDedup(d *Deduplicator) {
 	var t T
-	for _, v := range bs.observers {
-		switch any(t).(type) {
-		case zbxpkg.History:
-			h := any(bs.values).([]zbxpkg.History)
-			go v.SaveHistory(h)
-		case zbxpkg.Trend:
-			t := any(bs.values).([]zbxpkg.Trend)
-			go v.SaveTrends(t)
-		case zbxpkg.Event:
-			e := any(bs.values).([]zbxpkg.Event)
-			go v.SaveEvents(e)
-		}
-	}
+	bs.dedup = d.Set(t.GetExportName())
+}
+
+// SetCheckpointer enables tracking of positions of values read from files.
+func (bs *Subject[T]) SetCheckpointer(c *Checkpointer) {
+	bs.checkpointer = c
 }
+
+// AcceptValues routes values from the funnel to shards until it is closed.
+// Values left in buffers are flushed once the funnel is closed.
+//
+// With a checkpointer set, positions of values read so far are tracked by barriers
+// sent to every shard after them. A barrier is acknowledged by a shard once all values
+// it received before the barrier were acknowledged by every queue, so positions are
+// committed only after values read up to them were delivered by all shards.
 func (bs *Subject[T]) AcceptValues() {
+	shards := bs.startShards()
+	positions := make(map[string]zbx.Position)
+	routed := 0
 	for h := range bs.Funnel {
-		v := h.(T)
-		var accepted bool
-		switch h := h.(type) {
-		case zbxpkg.History:
-			accepted = bs.globalFilter.AcceptHistory(h)
-		case zbxpkg.Trend:
-			accepted = bs.globalFilter.AcceptTrend(h)
-		case zbxpkg.Event:
-			accepted = bs.globalFilter.AcceptEvent(h)
+		if r, ok := h.(zbx.Record); ok {
+			if bs.checkpointer != nil {
+				positions[r.Position.File] = r.Position
+			}
+			h = r.Value
 		}
-		if !accepted {
-			continue
+		v := h.(T)
+		shards[shardOf(v, len(shards))].route(v)
+		routed++
+		// positions of values rejected by filters are committed as soon as the funnel is empty
+		if len(positions) > 0 && (routed >= bs.buffer || len(bs.Funnel) == 0) {
+			bs.barrier(shards, positions)
+			clear(positions)
+			routed = 0
 		}
-		bs.values = append(bs.values, v)
-		usage := len(bs.values)
+	}
+	if len(positions) > 0 {
+		bs.barrier(shards, positions)
+	}
+	for _, sh := range shards {
+		sh.close()
+	}
+}
 
-		bs.bufferUsageGauge.Set(float64(usage))
+// startShards creates shards and starts processing values routed to them.
+func (bs *Subject[T]) startShards() []*shard[T] {
+	var t T
+	shards := make([]*shard[T], max(bs.shards, 1))
+	for i := range shards {
+		shards[i] = newShard(bs, t.GetExportName(), i)
+		go shards[i].run()
+	}
+	return shards
+}
 
-		if usage >= bs.buffer {
-			bs.NotifyAll()
-			bs.values = nil
-			bs.bufferUsageGauge.Set(0)
-		}
+// barrier sends positions read so far to all shards.
+func (bs *Subject[T]) barrier(shards []*shard[T], positions map[string]zbx.Position) {
+	ack := bs.checkpointer.Track(positions, len(shards))
+	for _, sh := range shards {
+		sh.in <- barrier{ack: ack}
 	}
 }
 
+// SetFilter replaces the filter applied to values before buffering.
+// It is safe to call while values are accepted.
 func (bs *Subject[T]) SetFilter(filter filter.Filter) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
 	bs.globalFilter = filter
 }
 
+// filter returns the filter applied to values before buffering.
+func (bs *Subject[T]) filter() filter.Filter {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	return bs.globalFilter
+}
+
+// snapshotQueues returns delivery queues of observers registered at the moment.
+func (bs *Subject[T]) snapshotQueues() []*DeliveryQueue[T] {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	queues := make([]*DeliveryQueue[T], 0, len(bs.queues))
+	for _, q := range bs.queues {
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+// Drain closes delivery queues and waits until batches in memory are handed over to observers.
+// Must be called after AcceptValues returned.
+func (bs *Subject[T]) Drain() {
+	for _, q := range bs.snapshotQueues() {
+		q.Close()
+	}
+}
+
+// Abort makes delivery queues give up batches in memory, so Drain returns once
+// batches being delivered are finished.
+func (bs *Subject[T]) Abort() {
+	for _, q := range bs.snapshotQueues() {
+		q.Abort()
+	}
+}
+
+// Cleanup releases observers. Delivery queues should be drained first.
 func (bs *Subject[T]) Cleanup() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
 	for _, observer := range bs.observers {
 		observer.Cleanup()
 	}
@@ -127,3 +278,7 @@ func (bs *Subject[T]) Cleanup() {
 func (bs *Subject[T]) GetFunnel() chan any {
 	return bs.Funnel
 }
+
+func (bs *Subject[T]) SetFunnel(funnel chan any) {
+	bs.Funnel = funnel
+}
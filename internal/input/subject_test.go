@@ -0,0 +1,131 @@
+package input
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/zbx"
+	"zms.szuro.net/pkg/filter"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func startTestSubject(t *testing.T, observer config.Observer, buffer, shards int, c *Checkpointer) (*Subject[zbxpkg.History], chan struct{}) {
+	t.Helper()
+	s := NewSubject[zbxpkg.History]()
+	s.SetFunnel(make(chan any, 100))
+	s.SetBuffer(buffer)
+	s.SetFlushPolicy(config.FlushPolicy{MaxRecords: buffer})
+	s.SetShards(shards)
+	if c != nil {
+		s.SetCheckpointer(c)
+	}
+	s.SetFilter(filter.NewTagFilter(filter.FilterConfig{}))
+	s.Register(observer, config.DeliveryConf{Workers: 1, QueueDepth: 10})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.AcceptValues()
+	}()
+	return s, done
+}
+
+func TestShardOf(t *testing.T) {
+	require.Equal(t, 0, shardOf(zbxpkg.History{ItemID: 7}, 1))
+	require.Equal(t, 3, shardOf(zbxpkg.History{ItemID: 7}, 4))
+	require.Equal(t, shardOf(zbxpkg.Trend{ItemID: 7}, 4), shardOf(zbxpkg.History{ItemID: 7}, 4))
+	// recovery goes along with its problem
+	require.Equal(t, shardOf(zbxpkg.Event{EventID: 5}, 4), shardOf(zbxpkg.Event{EventID: 6, PEventID: 5}, 4))
+}
+
+func TestSubject_ShardsKeepItemOrder(t *testing.T) {
+	observer := &fakeObserver{name: "target"}
+	s, done := startTestSubject(t, observer, 3, 4, nil)
+
+	for clock := range int64(50) {
+		for item := range int64(8) {
+			s.Funnel <- zbxpkg.History{ItemID: item, Clock: clock}
+		}
+	}
+	close(s.Funnel)
+	<-done
+	s.Drain()
+
+	require.Len(t, observer.history, 400)
+	last := make(map[int64]int64)
+	for _, h := range observer.history {
+		if prev, ok := last[h.ItemID]; ok {
+			require.Greater(t, h.Clock, prev, "item %d out of order", h.ItemID)
+		}
+		last[h.ItemID] = h.Clock
+	}
+}
+
+func TestSubject_ShardsCommitDeliveredPositions(t *testing.T) {
+	observer := &fakeObserver{name: "target"}
+	c := NewCheckpointer()
+	s, done := startTestSubject(t, observer, 2, 2, c)
+
+	record := func(item, offset int64) zbx.Record {
+		return zbx.Record{Value: zbxpkg.History{ItemID: item}, Position: zbx.Position{File: "f", Offset: offset}}
+	}
+	// item 1 waits in a buffer of its own shard, while the other shard flushes item 0
+	s.Funnel <- record(0, 1)
+	s.Funnel <- record(1, 2)
+	s.Funnel <- record(0, 3)
+
+	require.Eventually(t, func() bool {
+		observer.mu.Lock()
+		defer observer.mu.Unlock()
+		return len(observer.history) == 2
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	pos, ok := c.Committed("f")
+	require.False(t, ok && pos.Offset >= 2, "offset %d committed before it was delivered", pos.Offset)
+
+	close(s.Funnel)
+	<-done
+	s.Drain()
+	pos, ok = c.Committed("f")
+	require.True(t, ok)
+	require.Equal(t, int64(3), pos.Offset)
+}
+
+func TestSubject_HungTargetDoesNotBlockReload(t *testing.T) {
+	observer := &blockingObserver{fakeObserver: fakeObserver{name: "target"}, release: make(chan struct{})}
+	s := NewSubject[zbxpkg.History]()
+	s.SetFunnel(make(chan any, 10))
+	s.SetBuffer(1)
+	s.SetFilter(filter.NewTagFilter(filter.FilterConfig{}))
+	s.Register(observer, config.DeliveryConf{Workers: 1, QueueDepth: 1})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.AcceptValues()
+	}()
+
+	// the first batch is being saved, the second one fills the queue and the third one waits for space
+	for item := range int64(3) {
+		s.Funnel <- zbxpkg.History{ItemID: item}
+	}
+	require.Eventually(t, func() bool { return len(s.Funnel) == 0 }, time.Second, time.Millisecond)
+
+	replaced := make(chan struct{})
+	go func() {
+		defer close(replaced)
+		s.SetFilter(filter.NewTagFilter(filter.FilterConfig{}))
+	}()
+	select {
+	case <-replaced:
+	case <-time.After(time.Second):
+		t.Fatal("filter cannot be replaced while a target blocks its queue")
+	}
+
+	close(observer.release)
+	close(s.Funnel)
+	<-done
+	s.Drain()
+	require.Len(t, observer.history, 3)
+}
@@ -2,34 +2,51 @@ package zbx
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
-	"regexp"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
 	"log/slog"
 
-	zbxpkg "zms.szuro.net/pkg/zbx"
 	"zms.szuro.net/internal/logger"
-	//	"path/filepath"
+	zbxpkg "zms.szuro.net/pkg/zbx"
 )
 
-var ZbxRegex = regexp.MustCompile("^(StartDBSyncers|ExportDir|ExportType|HANodeName)=(.*)$")
+const (
+	// DEFAULT_EXPORT_FILE_SIZE is the ExportFileSize used by Zabbix if not set.
+	DEFAULT_EXPORT_FILE_SIZE = 1 << 30
+	// MAX_INCLUDE_DEPTH limits nesting of Include directives, like Zabbix does.
+	MAX_INCLUDE_DEPTH = 10
+)
 
 type ZabbixConf struct {
-	configPath  string
-	ExportDir   string
-	ExportTypes []string
-	DBSyncers   int
-	NodeName    string
+	configPath     string
+	ExportDir      string
+	ExportTypes    []string
+	ExportFileSize int64
+	DBSyncers      int
+	NodeName       string
+	// Sources holds the file and line every setting was read from, by parameter name.
+	// Settings left at their defaults are missing.
+	Sources map[string]string
 }
 
+// ParseZabbixConfig reads zabbix_server.conf along with files included by it.
+// Like in Zabbix, lines starting with # are comments, whitespace around
+// parameter names and values is ignored and a parameter set again overrides
+// the previous value.
 func ParseZabbixConfig(path string) (conf ZabbixConf, err error) {
 	conf.configPath = path
 	// zabbix_server.conf defaults
 	conf.DBSyncers = 4
 	conf.ExportTypes = []string{zbxpkg.HISTORY, zbxpkg.TREND, zbxpkg.EVENT}
+	conf.ExportFileSize = DEFAULT_EXPORT_FILE_SIZE
+	conf.Sources = make(map[string]string)
 	logger.Info("Reading config", slog.String("path", conf.configPath))
 
 	file, err := os.Open(path)
@@ -37,35 +54,156 @@ func ParseZabbixConfig(path string) (conf ZabbixConf, err error) {
 		panic(fmt.Sprintf("Could not open file: %s", err))
 	}
 	defer file.Close()
-	scanner := bufio.NewScanner(file)
-
-	for scanner.Scan() {
-		configLine := scanner.Text()
-		line := ZbxRegex.FindStringSubmatch(configLine)
-		if line != nil {
-			parameter := line[1]
-			value := line[2]
-			switch parameter {
-			case "ExportDir":
-				conf.ExportDir = value
-			case "ExportType":
-				conf.ExportTypes = strings.Split(value, ",")
-			case "StartDBSyncers":
-				conf.DBSyncers, _ = strconv.Atoi(value)
-			case "HANodeName":
-				conf.NodeName = value
-			}
-		}
+	if err = conf.parse(file, path, 0); err != nil {
+		return
 	}
 
+	sources := make([]any, 0, len(conf.Sources))
+	for _, parameter := range slices.Sorted(maps.Keys(conf.Sources)) {
+		sources = append(sources, slog.String(parameter, conf.Sources[parameter]))
+	}
 	logger.Info(
 		"Detected config",
 		slog.String("node", conf.NodeName),
 		slog.String("export", conf.ExportDir),
+		slog.Int64("export_file_size", conf.ExportFileSize),
 		slog.Int("syncers", conf.DBSyncers),
 		slog.String("types", strings.Join(conf.ExportTypes, ",")),
+		slog.Group("sources", sources...),
 	)
 	syncerGauge.Set(float64(conf.DBSyncers))
 
 	return
 }
+
+// Source returns the file and line a parameter was read from, or an empty string if it was not set.
+func (conf ZabbixConf) Source(parameter string) string {
+	return conf.Sources[parameter]
+}
+
+func (conf *ZabbixConf) parse(file *os.File, path string, depth int) error {
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		source := fmt.Sprintf("%s:%d", path, n)
+
+		parameter, value, ok := strings.Cut(line, "=")
+		if !ok {
+			logger.Warn("Ignoring invalid line in Zabbix config", slog.String("source", source))
+			continue
+		}
+		parameter, value = strings.TrimSpace(parameter), strings.TrimSpace(value)
+
+		if parameter == "Include" {
+			if err := conf.include(value, depth+1); err != nil {
+				return fmt.Errorf("%s: %w", source, err)
+			}
+			continue
+		}
+		conf.set(parameter, value, source)
+	}
+	return scanner.Err()
+}
+
+// include parses a file, every file in a directory or files matching a glob pattern.
+func (conf *ZabbixConf) include(pattern string, depth int) error {
+	if depth > MAX_INCLUDE_DEPTH {
+		return errors.New("too many nested includes")
+	}
+
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		pattern = filepath.Join(pattern, "*")
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid include %q: %w", pattern, err)
+	}
+	// a pattern may match nothing, a missing file is an error
+	if len(paths) == 0 && !strings.ContainsAny(pattern, `*?[\`) {
+		return fmt.Errorf("included file %q does not exist", pattern)
+	}
+
+	for _, path := range paths {
+		if err := conf.includeFile(path, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (conf *ZabbixConf) includeFile(path string, depth int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		// directories matched by a pattern are not read recursively
+		return err
+	}
+	return conf.parse(file, path, depth)
+}
+
+func (conf *ZabbixConf) set(parameter, value, source string) {
+	var err error
+	switch parameter {
+	case "ExportDir":
+		conf.ExportDir = value
+	case "ExportType":
+		conf.ExportTypes = nil
+		for _, t := range strings.Split(value, ",") {
+			conf.ExportTypes = append(conf.ExportTypes, strings.TrimSpace(t))
+		}
+	case "ExportFileSize":
+		var size int64
+		if size, err = parseSize(value); err == nil {
+			conf.ExportFileSize = size
+		}
+	case "StartDBSyncers":
+		var syncers int
+		if syncers, err = strconv.Atoi(value); err == nil {
+			conf.DBSyncers = syncers
+		}
+	case "HANodeName":
+		conf.NodeName = value
+	default:
+		return
+	}
+
+	if err != nil {
+		logger.Warn("Ignoring invalid value in Zabbix config", slog.String("parameter", parameter), slog.String("source", source), slog.Any("error", err))
+		return
+	}
+	conf.Sources[parameter] = source
+}
+
+// parseSize parses sizes with an optional K, M, G or T suffix, like ExportFileSize=1G.
+func parseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size <= 0 {
+		return 0, fmt.Errorf("size must be positive, got %d", size)
+	}
+	return size * multiplier, nil
+}
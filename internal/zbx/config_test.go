@@ -65,3 +65,88 @@ func TestParseZabbixConfig_InvalidFile(t *testing.T) {
 		ParseZabbixConfig("nonexistent.conf")
 	})
 }
+
+func TestParseZabbixConfig_CommentsAndWhitespace(t *testing.T) {
+	content := `
+# ExportDir=/commented
+   ExportDir = /var/lib/zabbix/export  
+	ExportType=history, events
+HANodeName=
+HANodeName=node2
+not a parameter
+`
+	path := writeTempConfigFile(t, content)
+	conf, err := ParseZabbixConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, "/var/lib/zabbix/export", conf.ExportDir)
+	require.Equal(t, []string{zbxpkg.HISTORY, zbxpkg.EVENT}, conf.ExportTypes)
+	require.Equal(t, "node2", conf.NodeName)
+	require.Equal(t, path+":3", conf.Source("ExportDir"))
+	require.Equal(t, path+":6", conf.Source("HANodeName"))
+	require.Empty(t, conf.Source("StartDBSyncers"))
+}
+
+func TestParseZabbixConfig_ExportFileSize(t *testing.T) {
+	tests := []struct {
+		value string
+		size  int64
+	}{
+		{"1048576", 1 << 20},
+		{"64K", 64 << 10},
+		{"512M", 512 << 20},
+		{"1G", 1 << 30},
+		{"invalid", DEFAULT_EXPORT_FILE_SIZE},
+		{"0M", DEFAULT_EXPORT_FILE_SIZE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			conf, err := ParseZabbixConfig(writeTempConfigFile(t, "ExportFileSize="+tt.value))
+			require.NoError(t, err)
+			require.Equal(t, tt.size, conf.ExportFileSize)
+		})
+	}
+}
+
+func TestParseZabbixConfig_Include(t *testing.T) {
+	dir := t.TempDir()
+	confd := filepath.Join(dir, "zabbix_server.d")
+	require.NoError(t, os.Mkdir(confd, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(confd, "export.conf"), []byte("ExportDir=/export\nExportFileSize=10M\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(confd, "ha.conf"), []byte("HANodeName=node1\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(confd, "ignored.txt"), []byte("HANodeName=ignored\n"), 0644))
+	nested := filepath.Join(dir, "nested")
+	require.NoError(t, os.Mkdir(nested, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(nested, "syncers"), []byte("StartDBSyncers=10\n"), 0644))
+
+	content := `StartDBSyncers=2
+Include=` + filepath.Join(confd, "*.conf") + `
+Include=` + nested + `/
+Include=` + filepath.Join(dir, "missing.d", "*.conf") + `
+`
+	path := filepath.Join(dir, "zabbix_server.conf")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	conf, err := ParseZabbixConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, "/export", conf.ExportDir)
+	require.Equal(t, int64(10<<20), conf.ExportFileSize)
+	require.Equal(t, "node1", conf.NodeName)
+	require.Equal(t, 10, conf.DBSyncers)
+	require.Equal(t, filepath.Join(confd, "export.conf")+":1", conf.Source("ExportDir"))
+	require.Equal(t, filepath.Join(confd, "ha.conf")+":1", conf.Source("HANodeName"))
+	require.Equal(t, filepath.Join(nested, "syncers")+":1", conf.Source("StartDBSyncers"))
+}
+
+func TestParseZabbixConfig_IncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	path := writeTempConfigFile(t, "Include="+filepath.Join(dir, "missing.conf"))
+	_, err := ParseZabbixConfig(path)
+	require.ErrorContains(t, err, "does not exist")
+
+	// includes itself
+	loop := filepath.Join(dir, "loop.conf")
+	require.NoError(t, os.WriteFile(loop, []byte("Include="+loop+"\n"), 0644))
+	_, err = ParseZabbixConfig(loop)
+	require.ErrorContains(t, err, "too many nested includes")
+}
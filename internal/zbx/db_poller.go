@@ -0,0 +1,571 @@
+package zbx
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/logger"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+const (
+	// DB_POSITION_PREFIX is prepended to export types to make keys of positions in the index.
+	DB_POSITION_PREFIX = "database/"
+	// TREND_PERIOD is the period covered by a row of trends. Zabbix writes
+	// trends of an hour once it is over, so they are read a period later.
+	TREND_PERIOD = time.Hour
+	// DB_IN_CHUNK limits IDs in a single IN (...) condition.
+	DB_IN_CHUNK = 1000
+)
+
+var (
+	dbRowsRead = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zms_db_rows_read_total",
+		Help: "The total number of rows read from the Zabbix database",
+	}, []string{"export_type"})
+
+	dbPollErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zms_db_poll_errors_total",
+		Help: "The total number of failed polls of the Zabbix database",
+	}, []string{"export_type"})
+
+	dbReadPosition = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zms_db_read_position",
+		Help: "Position read up to in the Zabbix database: clock for history and trends, eventid for events",
+	}, []string{"export_type"})
+)
+
+// historyTables maps tables holding history to the value type stored in them
+var historyTables = []struct {
+	table     string
+	valueType int32
+}{
+	{"history", zbxpkg.FLOAT},
+	{"history_uint", zbxpkg.UNSIGNED},
+	{"history_str", zbxpkg.CHARACTER},
+	{"history_text", zbxpkg.TEXT},
+	{"history_log", zbxpkg.LOG},
+}
+
+// trendTables maps tables holding trends to the value type stored in them
+var trendTables = []struct {
+	table     string
+	valueType int32
+}{
+	{"trends", zbxpkg.FLOAT},
+	{"trends_uint", zbxpkg.UNSIGNED},
+}
+
+// itemMeta holds item details exported by Zabbix along with values.
+type itemMeta struct {
+	name   string
+	host   *zbxpkg.Host
+	groups []string
+	tags   []zbxpkg.Tag
+}
+
+// eventMeta holds details of a problem event exported by Zabbix.
+type eventMeta struct {
+	hosts  []zbxpkg.Host
+	groups []string
+	tags   []zbxpkg.Tag
+}
+
+// DBPoller reads history, trends and events incrementally from the Zabbix database,
+// for servers that cannot write export files. Records are built like Zabbix exports them.
+//
+// History and trends are read by clock, in windows ending Delay before now, so values
+// committed late by history syncers are not skipped. Events are read by eventid.
+// Positions of records are keyed DB_POSITION_PREFIX + export type in the index,
+// reading continues from positions saved there.
+type DBPoller struct {
+	conf    config.DatabaseConf
+	db      *sql.DB
+	indexDB *badger.DB
+	funnels map[string]chan any // by export type
+	now     func() time.Time
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDBPoller connects to the Zabbix database using a registered database/sql driver.
+// Values of every export type are sent to its funnel, export types without a funnel are not read.
+func NewDBPoller(conf config.DatabaseConf, indexDB *badger.DB, funnels map[string]chan any) (*DBPoller, error) {
+	db, err := sql.Open(conf.Driver, conf.DSN)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(len(funnels) + 1)
+	return &DBPoller{conf: conf, db: db, indexDB: indexDB, funnels: funnels, now: time.Now}, nil
+}
+
+// Start polls every export type in the background.
+func (p *DBPoller) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	for export, funnel := range p.funnels {
+		p.wg.Add(1)
+		go p.poll(ctx, export, funnel)
+	}
+}
+
+// Stop stops polling. Records read but not yet sent are read again after Start.
+func (p *DBPoller) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	p.wg.Wait()
+	p.cancel = nil
+}
+
+// Close closes the database connection.
+func (p *DBPoller) Close() error {
+	return p.db.Close()
+}
+
+func (p *DBPoller) poll(ctx context.Context, export string, funnel chan any) {
+	defer p.wg.Done()
+	var from int64
+	for {
+		var err error
+		if from, err = p.startPosition(ctx, export); err == nil {
+			break
+		}
+		dbPollErrors.WithLabelValues(export).Inc()
+		logger.Error("Failed to find where to start reading the database", slog.String("export", export), slog.Any("error", err))
+		if !p.wait(ctx) {
+			return
+		}
+	}
+	logger.Info("Polling Zabbix database", slog.String("export", export), slog.Int64("position", from))
+
+	for {
+		records, next, caughtUp, err := p.read(ctx, export, from)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			dbPollErrors.WithLabelValues(export).Inc()
+			logger.Error("Failed to poll the database", slog.String("export", export), slog.Int64("position", from), slog.Any("error", err))
+			if !p.wait(ctx) {
+				return
+			}
+			continue
+		}
+		for _, r := range records {
+			select {
+			case funnel <- r:
+			case <-ctx.Done():
+				// not committed, read again after restart
+				return
+			}
+		}
+		dbRowsRead.WithLabelValues(export).Add(float64(len(records)))
+		dbReadPosition.WithLabelValues(export).Set(float64(next))
+		from = next
+		if caughtUp && !p.wait(ctx) {
+			return
+		}
+	}
+}
+
+// wait sleeps for the poll interval and returns false if polling was stopped meanwhile.
+func (p *DBPoller) wait(ctx context.Context) bool {
+	t := time.NewTimer(p.conf.PollInterval)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// startPosition returns the saved position of the export,
+// or where Backfill reaches back to if there is none.
+func (p *DBPoller) startPosition(ctx context.Context, export string) (int64, error) {
+	saved, ok, err := loadPosition(p.indexDB, DB_POSITION_PREFIX+export)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return saved.Offset, nil
+	}
+	start := p.now().Add(-p.conf.Backfill).Unix()
+	if export != zbxpkg.EVENT {
+		return start, nil
+	}
+	var eventID sql.NullInt64
+	err = p.db.QueryRowContext(ctx, p.rebind("SELECT MAX(eventid) FROM events WHERE source = 0 AND clock < ?"), start).Scan(&eventID)
+	return eventID.Int64, err
+}
+
+// read returns records following the position, the position to continue from
+// and whether there is nothing more to read right now.
+func (p *DBPoller) read(ctx context.Context, export string, from int64) (records []any, next int64, caughtUp bool, err error) {
+	now := p.now()
+	switch export {
+	case zbxpkg.HISTORY:
+		to, caughtUp := readRange(from, p.conf.Window, now.Add(-p.conf.Delay))
+		records, err = p.readHistory(ctx, from, to)
+		return records, to, caughtUp, err
+	case zbxpkg.TREND:
+		to, caughtUp := readRange(from, TREND_PERIOD, now.Add(-p.conf.Delay-TREND_PERIOD))
+		records, err = p.readTrends(ctx, from, to)
+		return records, to, caughtUp, err
+	case zbxpkg.EVENT:
+		records, next, err = p.readEvents(ctx, from, now.Add(-p.conf.Delay).Unix())
+		return records, next, len(records) < p.conf.BatchSize, err
+	}
+	return nil, from, true, fmt.Errorf("export %s cannot be read from the database", export)
+}
+
+// readRange returns the end of the clock range following from, no longer than window
+// and not past until, and whether it reaches until.
+func readRange(from int64, window time.Duration, until time.Time) (to int64, caughtUp bool) {
+	to = min(from+int64(window/time.Second), until.Unix())
+	return max(to, from), to >= until.Unix()
+}
+
+func (p *DBPoller) readHistory(ctx context.Context, from, to int64) ([]any, error) {
+	if to <= from {
+		return nil, nil
+	}
+	var values []zbxpkg.History
+	for _, t := range historyTables {
+		columns := "itemid, clock, ns, value"
+		if t.valueType == zbxpkg.LOG {
+			columns += ", timestamp, source, severity, logeventid"
+		}
+		query := fmt.Sprintf("SELECT %s FROM %s WHERE clock > ? AND clock <= ?", columns, t.table)
+		rows, err := p.db.QueryContext(ctx, p.rebind(query), from, to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", t.table, err)
+		}
+		for rows.Next() {
+			h := zbxpkg.History{Type: t.valueType}
+			var value string
+			dest := []any{&h.ItemID, &h.Clock, &h.Ns, &value}
+			if t.valueType == zbxpkg.LOG {
+				dest = append(dest, &h.Timestamp, &h.Source, &h.Severity, &h.EventID)
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to read %s: %w", t.table, err)
+			}
+			if h.IsNumeric() {
+				h.Value = json.Number(value)
+			} else {
+				h.Value = value
+			}
+			values = append(values, h)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", t.table, err)
+		}
+	}
+
+	items, err := p.readItems(ctx, itemIDs(values, func(h zbxpkg.History) int64 { return h.ItemID }))
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		if item, ok := items[values[i].ItemID]; ok {
+			values[i].Name, values[i].Host, values[i].Groups, values[i].Tags = item.name, item.host, item.groups, item.tags
+		}
+	}
+	slices.SortFunc(values, func(a, b zbxpkg.History) int {
+		return cmp.Or(cmp.Compare(a.Clock, b.Clock), cmp.Compare(a.Ns, b.Ns), cmp.Compare(a.ItemID, b.ItemID))
+	})
+	return clockRecords(zbxpkg.HISTORY, values, func(h zbxpkg.History) int64 { return h.Clock }, to), nil
+}
+
+func (p *DBPoller) readTrends(ctx context.Context, from, to int64) ([]any, error) {
+	if to <= from {
+		return nil, nil
+	}
+	var values []zbxpkg.Trend
+	for _, t := range trendTables {
+		query := fmt.Sprintf("SELECT itemid, clock, num, value_min, value_avg, value_max FROM %s WHERE clock > ? AND clock <= ?", t.table)
+		rows, err := p.db.QueryContext(ctx, p.rebind(query), from, to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", t.table, err)
+		}
+		for rows.Next() {
+			tr := zbxpkg.Trend{Type: t.valueType}
+			if err := rows.Scan(&tr.ItemID, &tr.Clock, &tr.Count, &tr.Min, &tr.Avg, &tr.Max); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to read %s: %w", t.table, err)
+			}
+			values = append(values, tr)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", t.table, err)
+		}
+	}
+
+	items, err := p.readItems(ctx, itemIDs(values, func(t zbxpkg.Trend) int64 { return t.ItemID }))
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		if item, ok := items[values[i].ItemID]; ok {
+			values[i].Name, values[i].Host, values[i].Groups, values[i].Tags = item.name, item.host, item.groups, item.tags
+		}
+	}
+	slices.SortFunc(values, func(a, b zbxpkg.Trend) int {
+		return cmp.Or(cmp.Compare(a.Clock, b.Clock), cmp.Compare(a.ItemID, b.ItemID))
+	})
+	return clockRecords(zbxpkg.TREND, values, func(t zbxpkg.Trend) int64 { return t.Clock }, to), nil
+}
+
+// clockRecords wraps values sorted by clock into records. A record is positioned right
+// before its clock, as values sharing the clock may not be delivered yet,
+// and the last one at the end of the range read.
+func clockRecords[T any](export string, values []T, clock func(T) int64, to int64) []any {
+	records := make([]any, len(values))
+	for i, v := range values {
+		offset := clock(v) - 1
+		if i == len(values)-1 {
+			offset = to
+		}
+		records[i] = Record{Value: v, Position: Position{File: DB_POSITION_PREFIX + export, Offset: offset}}
+	}
+	return records
+}
+
+// readEvents reads up to BatchSize trigger events following the eventid, with clock not past until.
+// Like in export files, recovery events hold only the ID of the problem they resolve.
+func (p *DBPoller) readEvents(ctx context.Context, from, until int64) ([]any, int64, error) {
+	query := "SELECT e.eventid, e.clock, e.ns, e.value, e.name, e.severity, r.eventid" +
+		" FROM events e LEFT JOIN event_recovery r ON r.r_eventid = e.eventid" +
+		" WHERE e.source = 0 AND e.object = 0 AND e.eventid > ? AND e.clock <= ?" +
+		" ORDER BY e.eventid LIMIT ?"
+	rows, err := p.db.QueryContext(ctx, p.rebind(query), from, until, p.conf.BatchSize)
+	if err != nil {
+		return nil, from, fmt.Errorf("failed to read events: %w", err)
+	}
+	var events []zbxpkg.Event
+	var problems []int64
+	for rows.Next() {
+		var e zbxpkg.Event
+		var problem sql.NullInt64
+		if err := rows.Scan(&e.EventID, &e.Clock, &e.NS, &e.Value, &e.Name, &e.Severity, &problem); err != nil {
+			rows.Close()
+			return nil, from, fmt.Errorf("failed to read events: %w", err)
+		}
+		if e.Value == 0 {
+			e = zbxpkg.Event{EventID: e.EventID, Clock: e.Clock, NS: e.NS, PEventID: problem.Int64}
+		} else {
+			problems = append(problems, e.EventID)
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, from, fmt.Errorf("failed to read events: %w", err)
+	}
+	if len(events) == 0 {
+		return nil, from, nil
+	}
+
+	meta, err := p.readEventMeta(ctx, problems)
+	if err != nil {
+		return nil, from, err
+	}
+	records := make([]any, len(events))
+	for i, e := range events {
+		if m, ok := meta[e.EventID]; ok {
+			e.Hosts, e.Groups, e.Tags = m.hosts, m.groups, m.tags
+		}
+		records[i] = Record{Value: e, Position: Position{File: DB_POSITION_PREFIX + zbxpkg.EVENT, Offset: e.EventID}}
+	}
+	return records, events[len(events)-1].EventID, nil
+}
+
+// readItems reads names, hosts, host groups and tags of items.
+func (p *DBPoller) readItems(ctx context.Context, ids []int64) (map[int64]*itemMeta, error) {
+	items := make(map[int64]*itemMeta, len(ids))
+	err := p.queryIn(ctx, "SELECT i.itemid, i.name, h.host, h.name FROM items i JOIN hosts h ON h.hostid = i.hostid WHERE i.itemid", ids, func(rows *sql.Rows) error {
+		var id int64
+		item := &itemMeta{host: &zbxpkg.Host{}}
+		if err := rows.Scan(&id, &item.name, &item.host.Host, &item.host.Name); err != nil {
+			return err
+		}
+		items[id] = item
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read items: %w", err)
+	}
+
+	err = p.queryIn(ctx, "SELECT i.itemid, g.name FROM items i"+
+		" JOIN hosts_groups hg ON hg.hostid = i.hostid JOIN hstgrp g ON g.groupid = hg.groupid"+
+		" WHERE i.itemid", ids, func(rows *sql.Rows) error {
+		var id int64
+		var group string
+		if err := rows.Scan(&id, &group); err != nil {
+			return err
+		}
+		if item, ok := items[id]; ok {
+			item.groups = append(item.groups, group)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host groups: %w", err)
+	}
+
+	err = p.queryIn(ctx, "SELECT itemid, tag, value FROM item_tag WHERE itemid", ids, func(rows *sql.Rows) error {
+		var id int64
+		var tag zbxpkg.Tag
+		if err := rows.Scan(&id, &tag.Tag, &tag.Value); err != nil {
+			return err
+		}
+		if item, ok := items[id]; ok {
+			item.tags = append(item.tags, tag)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read item tags: %w", err)
+	}
+	return items, nil
+}
+
+// readEventMeta reads hosts and host groups of triggers behind problem events, and tags of the events.
+func (p *DBPoller) readEventMeta(ctx context.Context, ids []int64) (map[int64]*eventMeta, error) {
+	events := make(map[int64]*eventMeta, len(ids))
+	for _, id := range ids {
+		events[id] = &eventMeta{}
+	}
+	triggerHosts := "FROM events e JOIN functions f ON f.triggerid = e.objectid" +
+		" JOIN items i ON i.itemid = f.itemid JOIN hosts h ON h.hostid = i.hostid"
+
+	err := p.queryIn(ctx, "SELECT DISTINCT e.eventid, h.host, h.name "+triggerHosts+" WHERE e.eventid", ids, func(rows *sql.Rows) error {
+		var id int64
+		var host zbxpkg.Host
+		if err := rows.Scan(&id, &host.Host, &host.Name); err != nil {
+			return err
+		}
+		events[id].hosts = append(events[id].hosts, host)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event hosts: %w", err)
+	}
+
+	err = p.queryIn(ctx, "SELECT DISTINCT e.eventid, g.name "+triggerHosts+
+		" JOIN hosts_groups hg ON hg.hostid = h.hostid JOIN hstgrp g ON g.groupid = hg.groupid"+
+		" WHERE e.eventid", ids, func(rows *sql.Rows) error {
+		var id int64
+		var group string
+		if err := rows.Scan(&id, &group); err != nil {
+			return err
+		}
+		events[id].groups = append(events[id].groups, group)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event host groups: %w", err)
+	}
+
+	err = p.queryIn(ctx, "SELECT eventid, tag, value FROM event_tag WHERE eventid", ids, func(rows *sql.Rows) error {
+		var id int64
+		var tag zbxpkg.Tag
+		if err := rows.Scan(&id, &tag.Tag, &tag.Value); err != nil {
+			return err
+		}
+		events[id].tags = append(events[id].tags, tag)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event tags: %w", err)
+	}
+	return events, nil
+}
+
+// queryIn runs the query ending with a column compared against ids, in chunks of DB_IN_CHUNK,
+// and calls fn for every row. IDs are integers, so they are put in the query as is.
+func (p *DBPoller) queryIn(ctx context.Context, query string, ids []int64, fn func(*sql.Rows) error) error {
+	for chunk := range slices.Chunk(ids, DB_IN_CHUNK) {
+		rows, err := p.db.QueryContext(ctx, query+inList(chunk))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			if err := fn(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inList formats ids as an IN condition.
+func inList(ids []int64) string {
+	var b strings.Builder
+	b.WriteString(" IN (")
+	for i, id := range ids {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatInt(id, 10))
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// itemIDs returns unique item IDs of values.
+func itemIDs[T any](values []T, id func(T) int64) []int64 {
+	ids := make([]int64, 0, len(values))
+	for _, v := range values {
+		ids = append(ids, id(v))
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// rebind replaces ? placeholders with the ones of the driver.
+func (p *DBPoller) rebind(query string) string {
+	return rebind(p.conf.Driver, query)
+}
+
+func rebind(driver, query string) string {
+	if driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
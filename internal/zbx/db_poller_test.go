@@ -0,0 +1,140 @@
+package zbx
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func TestRebind(t *testing.T) {
+	query := "SELECT itemid FROM history WHERE clock > ? AND clock <= ?"
+	require.Equal(t, "SELECT itemid FROM history WHERE clock > $1 AND clock <= $2", rebind("postgres", query))
+	require.Equal(t, query, rebind("mysql", query))
+}
+
+// queryRecorder is a database connection that records queries and returns no rows.
+type queryRecorder struct {
+	queries []string
+	args    []int
+}
+
+func (r *queryRecorder) Connect(context.Context) (driver.Conn, error) { return r, nil }
+func (r *queryRecorder) Driver() driver.Driver                        { return nil }
+func (r *queryRecorder) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (r *queryRecorder) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+func (r *queryRecorder) Close() error                                 { return nil }
+
+func (r *queryRecorder) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r.queries = append(r.queries, query)
+	r.args = append(r.args, len(args))
+	return noRows{}, nil
+}
+
+type noRows struct{}
+
+func (noRows) Columns() []string         { return nil }
+func (noRows) Close() error              { return nil }
+func (noRows) Next([]driver.Value) error { return io.EOF }
+
+func TestDBPoller_Placeholders(t *testing.T) {
+	placeholders := map[string]string{"postgres": "$", "mysql": "?"}
+	for driverName, placeholder := range placeholders {
+		t.Run(driverName, func(t *testing.T) {
+			rec := &queryRecorder{}
+			p := &DBPoller{conf: config.DatabaseConf{Driver: driverName, BatchSize: 10}, db: sql.OpenDB(rec)}
+			defer p.Close()
+
+			ctx := context.Background()
+			_, err := p.readHistory(ctx, 100, 160)
+			require.NoError(t, err)
+			_, err = p.readTrends(ctx, 0, 3600)
+			require.NoError(t, err)
+			_, _, err = p.readEvents(ctx, 0, 160)
+			require.NoError(t, err)
+
+			require.Len(t, rec.queries, len(historyTables)+len(trendTables)+1)
+			for i, query := range rec.queries {
+				require.Equal(t, rec.args[i], strings.Count(query, placeholder), query)
+				for other := range placeholders {
+					if other != driverName {
+						require.NotContains(t, query, placeholders[other])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestInList(t *testing.T) {
+	require.Equal(t, " IN (1,22,333)", inList([]int64{1, 22, 333}))
+	require.Equal(t, []int64{1, 2, 3}, itemIDs([]int64{3, 1, 2, 3, 1}, func(id int64) int64 { return id }))
+}
+
+func TestReadRange(t *testing.T) {
+	until := time.Unix(1000, 0)
+
+	to, caughtUp := readRange(100, time.Minute, until)
+	require.Equal(t, int64(160), to)
+	require.False(t, caughtUp)
+
+	to, caughtUp = readRange(980, time.Minute, until)
+	require.Equal(t, int64(1000), to)
+	require.True(t, caughtUp)
+
+	// clock behind the position, nothing to read
+	to, caughtUp = readRange(1100, time.Minute, until)
+	require.Equal(t, int64(1100), to)
+	require.True(t, caughtUp)
+}
+
+func TestClockRecords(t *testing.T) {
+	values := []zbxpkg.History{{ItemID: 1, Clock: 101}, {ItemID: 2, Clock: 101}, {ItemID: 1, Clock: 105}}
+	records := clockRecords(zbxpkg.HISTORY, values, func(h zbxpkg.History) int64 { return h.Clock }, 110)
+
+	offsets := make([]int64, len(records))
+	for i, r := range records {
+		rec := r.(Record)
+		require.Equal(t, DB_POSITION_PREFIX+zbxpkg.HISTORY, rec.Position.File)
+		require.Equal(t, values[i], rec.Value)
+		offsets[i] = rec.Position.Offset
+	}
+	// values sharing a clock do not commit it, the last one commits the whole range
+	require.Equal(t, []int64{100, 100, 110}, offsets)
+}
+
+func TestDBPoller_StartPosition(t *testing.T) {
+	db, cleanup := createTempBadgerDB(t)
+	defer cleanup()
+	now := time.Unix(1700000000, 0)
+	p := &DBPoller{
+		conf:    config.DatabaseConf{Backfill: time.Hour},
+		indexDB: db,
+		now:     func() time.Time { return now },
+	}
+
+	start, err := p.startPosition(context.Background(), zbxpkg.HISTORY)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(-time.Hour).Unix(), start)
+
+	saved := Position{File: DB_POSITION_PREFIX + zbxpkg.HISTORY, Offset: 1690000000}
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		val, err := saved.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte(saved.File), val)
+	}))
+	start, err = p.startPosition(context.Background(), zbxpkg.HISTORY)
+	require.NoError(t, err)
+	require.Equal(t, saved.Offset, start)
+}
@@ -3,6 +3,7 @@
 package zbx
 
 import (
+	"context"
 	"time"
 
 	"zms.szuro.net/internal/logger"
@@ -10,15 +11,17 @@ import (
 
 const DEFAULT_DELAY = 60 * time.Second
 
-func GetFailoverDelay(input string) time.Duration {
-	return DEFAULT_DELAY
+type RuntimeControlDetector struct {
+	Command    string
+	ConfigPath string
+	NodeName   string
 }
 
-func ExtractNameAndStatus(input string) (string, string) {
-	return "test", "active"
+func NewRuntimeControlDetector(conf ZabbixConf) *RuntimeControlDetector {
+	return &RuntimeControlDetector{ConfigPath: conf.configPath, NodeName: conf.NodeName}
 }
 
-func GetHaStatus(config ZabbixConf) (delay time.Duration, nodeIsActive bool) {
+func (d *RuntimeControlDetector) Status(ctx context.Context) (HAStatus, error) {
 	logger.Debug("Debug server is always active")
-	return GetFailoverDelay(""), true
+	return HAStatus{Node: NodeInfo{Name: d.NodeName, Status: NODE_ACTIVE}, FailoverDelay: DEFAULT_DELAY}, nil
 }
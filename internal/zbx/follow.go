@@ -0,0 +1,358 @@
+package zbx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"zms.szuro.net/internal/deadletter"
+	"zms.szuro.net/internal/logger"
+)
+
+const (
+	// FOLLOW_POLL_INTERVAL is how often a file read to the end is checked for new lines and rotation.
+	FOLLOW_POLL_INTERVAL = 100 * time.Millisecond
+	// FINGERPRINT_SIZE limits how much of the first line is hashed to fingerprint a file.
+	FINGERPRINT_SIZE = 1024
+	// ROTATED_SUFFIX is appended by Zabbix to export files reaching ExportFileSize.
+	ROTATED_SUFFIX = ".old"
+)
+
+// follower reads an export file line by line and follows it across rotations.
+//
+// Zabbix renames a file reaching ExportFileSize to <name>.old and creates a new one.
+// The follower keeps reading the renamed file until its end and only then switches
+// to the new file, so lines written right before the rename are not lost.
+type follower struct {
+	file     ExportFile
+	c        chan any
+	parse    func(line string) (any, error)
+	parsed   prometheus.Counter
+	invalid  prometheus.Counter
+	rotated  prometheus.Counter
+	maxSize  int64 // ExportFileSize, the file is rotated before growing past it
+	oversize bool
+	dead     *deadletter.Store // keeps lines that could not be parsed, nil if disabled
+
+	f       *os.File
+	r       *bufio.Reader
+	partial []byte
+	pos     Position // right after the last complete line
+	num     int      // number of lines read from the current file
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// followExport starts following an export file from the last saved position.
+// Parsed values are sent to c until the follower is stopped.
+func followExport(file ExportFile, maxSize int64, indexDB *badger.DB, dead *deadletter.Store, c chan any) (*follower, error) {
+	path, offset := startPosition(indexDB, file.Path)
+
+	labels := prometheus.Labels{
+		"export_type": file.Export,
+		"file_index":  strconv.Itoa(file.Index),
+		"file":        filepath.Base(file.Path),
+	}
+	fw := &follower{
+		file:    file,
+		c:       c,
+		parse:   lineParsers[file.Export],
+		parsed:  linesParsed.With(labels),
+		invalid: linesInvalid.With(labels),
+		rotated: exportRotations.WithLabelValues(file.Export, labels["file"]),
+		maxSize: maxSize,
+		dead:    dead,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := fw.open(path, offset); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Opening and parsing export file", slog.String("file", path), slog.Int64("offset", offset))
+	go fw.run()
+	return fw, nil
+}
+
+func (fw *follower) deadLetter(line string, start int64, parseErr error) {
+	if fw.dead == nil {
+		return
+	}
+	err := fw.dead.Add(deadletter.Entry{
+		Time:   time.Now(),
+		Export: fw.file.Export,
+		File:   fw.pos.File,
+		Offset: start,
+		Error:  parseErr.Error(),
+		Line:   line,
+	})
+	if err != nil {
+		logger.Error("Failed to store line in dead-letter store", slog.String("file", fw.pos.File), slog.Any("error", err))
+	}
+}
+
+// Stop stops following the file and waits until the last line read is handed over.
+func (fw *follower) Stop() {
+	close(fw.stop)
+	<-fw.done
+}
+
+func (fw *follower) run() {
+	defer close(fw.done)
+	defer func() { fw.f.Close() }()
+
+	ticker := time.NewTicker(FOLLOW_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		if !fw.readAll() {
+			return
+		}
+		if fw.reopen() {
+			continue
+		}
+		select {
+		case <-fw.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readAll sends lines until the end of the current file.
+// It returns false if the follower was stopped meanwhile.
+func (fw *follower) readAll() bool {
+	for {
+		line, err := fw.r.ReadBytes('\n')
+		fw.partial = append(fw.partial, line...)
+		if err != nil {
+			// an incomplete line is kept until the rest of it is written
+			if !errors.Is(err, io.EOF) {
+				logger.Error("Failed to read export file", slog.String("file", fw.file.Path), slog.Any("error", err))
+			}
+			return true
+		}
+
+		line, fw.partial = fw.partial, nil
+		start := fw.pos.Offset
+		fw.pos.Offset += int64(len(line))
+		fw.num++
+		if fw.pos.Fingerprint == 0 {
+			fw.pos.Fingerprint = fingerprint(fw.f)
+		}
+		if !fw.send(string(bytes.TrimRight(line, "\r\n")), start) {
+			return false
+		}
+	}
+}
+
+// send parses the line starting at offset start and passes it on.
+// Lines that cannot be parsed go to the dead-letter store.
+func (fw *follower) send(line string, start int64) bool {
+	parsed, err := fw.parse(line)
+	fw.parsed.Inc()
+	if err != nil {
+		fw.invalid.Inc()
+		logger.Error("Failed to parse line", slog.String("file", fw.pos.File), slog.Int("line_number", fw.num), slog.Int64("offset", start), slog.Any("error", err))
+		fw.deadLetter(line, start, err)
+		return true
+	}
+
+	select {
+	case fw.c <- Record{Value: parsed, Position: fw.pos}:
+		return true
+	case <-fw.stop:
+		// not committed, the line is read again after restart
+		return false
+	}
+}
+
+// reopen switches to the file now found at the path, once the current one was rotated or truncated.
+// It must be called at the end of the current file and returns true if another file was opened.
+func (fw *follower) reopen() bool {
+	current, err := fw.f.Stat()
+	if err != nil {
+		logger.Error("Failed to stat export file", slog.String("file", fw.file.Path), slog.Any("error", err))
+		return false
+	}
+	info, err := os.Stat(fw.file.Path)
+	if err != nil {
+		// renamed and the new file is not created yet
+		return false
+	}
+
+	if os.SameFile(current, info) {
+		if fw.maxSize > 0 && info.Size() > fw.maxSize && !fw.oversize {
+			fw.oversize = true
+			logger.Warn("Export file larger than ExportFileSize, Zabbix server may run with a different config",
+				slog.String("file", fw.file.Path), slog.Int64("size", info.Size()), slog.Int64("export_file_size", fw.maxSize))
+		}
+		if info.Size() >= fw.pos.Offset {
+			return false
+		}
+		logger.Warn("Export file truncated, reading from the start", slog.String("file", fw.file.Path))
+		return fw.switchTo(fw.file.Path)
+	}
+
+	// Zabbix does not write to the file after renaming it, but lines may have been
+	// written between reaching its end and noticing the rotation
+	if !fw.readAll() {
+		return false
+	}
+	if len(fw.partial) > 0 {
+		logger.Warn("Incomplete line at the end of rotated export file", slog.String("file", fw.file.Path), slog.Int("bytes", len(fw.partial)))
+	}
+
+	next := fw.file.Path
+	rotated := fw.file.Path + ROTATED_SUFFIX
+	if old, err := os.Stat(rotated); err == nil && !os.SameFile(old, current) && !os.SameFile(old, info) {
+		// the file was rotated again before the previous rotation was followed
+		logger.Warn("Export file rotated more than once while reading, reading rotated file first", slog.String("file", rotated))
+		next = rotated
+	}
+	if !fw.switchTo(next) {
+		return false
+	}
+	fw.rotated.Inc()
+	return true
+}
+
+func (fw *follower) switchTo(path string) bool {
+	previous := fw.f
+	if err := fw.open(path, 0); err != nil {
+		// retried after the next poll
+		logger.Error("Failed to open export file", slog.String("file", path), slog.Any("error", err))
+		return false
+	}
+	previous.Close()
+	logger.Info("Following new export file", slog.String("file", path))
+	return true
+}
+
+func (fw *follower) open(path string, offset int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	fw.f = f
+	fw.r = bufio.NewReader(f)
+	fw.partial = nil
+	fw.num = 0
+	fw.pos = Position{File: fw.file.Path, Offset: offset, Inode: inode(info), Fingerprint: fingerprint(f)}
+	return nil
+}
+
+// startPosition finds where to continue reading an export file.
+// If the file was rotated since the position was saved, the rotated file
+// is returned to be read to the end before the new one.
+func startPosition(indexDB *badger.DB, path string) (string, int64) {
+	saved, ok, err := loadPosition(indexDB, path)
+	if err != nil {
+		logger.Error("Failed to load export file position", slog.String("file", path), slog.Any("error", err))
+	}
+	if !ok {
+		return path, 0
+	}
+
+	// saved by an older version, only the offset is known
+	if saved.Inode == 0 {
+		if info, err := os.Stat(path); err == nil && info.Size() >= saved.Offset {
+			return path, saved.Offset
+		}
+		return path, 0
+	}
+
+	rotated := path + ROTATED_SUFFIX
+	switch {
+	case matchesPosition(path, saved):
+		return path, saved.Offset
+	case matchesPosition(rotated, saved):
+		logger.Info("Export file rotated since last run, reading rotated file first", slog.String("file", rotated))
+		return rotated, saved.Offset
+	}
+
+	// The saved file is gone, it was rotated at least twice.
+	// The rotated file is newer then and was never read.
+	if _, err := os.Stat(rotated); err == nil {
+		logger.Warn("Export file rotated more than once since last run, reading rotated file first", slog.String("file", rotated))
+		return rotated, 0
+	}
+	logger.Warn("Export file does not match saved position, reading from the start", slog.String("file", path))
+	return path, 0
+}
+
+// matchesPosition checks if the file at path is the one the position was saved for.
+func matchesPosition(path string, pos Position) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || inode(info) != pos.Inode || info.Size() < pos.Offset {
+		return false
+	}
+	// inodes of deleted files are reused
+	return pos.Fingerprint == 0 || fingerprint(f) == pos.Fingerprint
+}
+
+func loadPosition(indexDB *badger.DB, path string) (pos Position, ok bool, err error) {
+	err = indexDB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(path))
+		if err != nil {
+			return err
+		}
+		return item.Value(pos.UnmarshalBinary)
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return pos, false, nil
+	}
+	return pos, err == nil, err
+}
+
+// fingerprint hashes the first line of the file, or its first FINGERPRINT_SIZE bytes.
+// It returns 0 until the first line is complete.
+func fingerprint(f *os.File) uint64 {
+	buf := make([]byte, FINGERPRINT_SIZE)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0
+	}
+	buf = buf[:n]
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i+1]
+	} else if n < FINGERPRINT_SIZE {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write(buf)
+	return h.Sum64()
+}
+
+func inode(info fs.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return 0
+}
@@ -0,0 +1,232 @@
+package zbx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+	"zms.szuro.net/internal/deadletter"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// appendHistory appends history lines with item IDs from first to last.
+func appendHistory(t *testing.T, path string, first, last int) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	defer f.Close()
+	for id := first; id <= last; id++ {
+		_, err := fmt.Fprintf(f, `{"itemid":%d,"clock":1,"ns":0,"value":1,"type":3}`+"\n", id)
+		require.NoError(t, err)
+	}
+}
+
+// rotate renames the file like Zabbix does when it reaches ExportFileSize.
+func rotate(t *testing.T, path string) {
+	t.Helper()
+	require.NoError(t, os.Rename(path, path+ROTATED_SUFFIX))
+}
+
+func savePosition(t *testing.T, db *badger.DB, pos Position) {
+	t.Helper()
+	val, err := pos.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(pos.File), val)
+	}))
+}
+
+// positionAfter returns the position right after the given number of lines of the file.
+func positionAfter(t *testing.T, path string, lines int) Position {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	require.NoError(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var offset int64
+	for i := 0; i < lines; i++ {
+		n := 0
+		for content[offset+int64(n)] != '\n' {
+			n++
+		}
+		offset += int64(n) + 1
+	}
+	return Position{File: path, Offset: offset, Inode: inode(info), Fingerprint: fingerprint(f)}
+}
+
+func historyFile(dir string) ExportFile {
+	return ExportFile{Path: filepath.Join(dir, "history-history-syncer-1.ndjson"), Export: zbxpkg.HISTORY, Process: "history-syncer", Index: 1}
+}
+
+func receiveIDs(t *testing.T, c chan any, n int) []int64 {
+	t.Helper()
+	ids := make([]int64, 0, n)
+	for range n {
+		ids = append(ids, receive(t, c).Value.(zbxpkg.History).ItemID)
+	}
+	return ids
+}
+
+func TestFollower_RotationUnderLoad(t *testing.T) {
+	db, cleanup := createTempBadgerDB(t)
+	defer cleanup()
+	file := historyFile(t.TempDir())
+	appendHistory(t, file.Path, 1, 1)
+
+	const perFile, files = 200, 25
+	c := make(chan any, 10)
+	fw, err := followExport(file, DEFAULT_EXPORT_FILE_SIZE, db, nil, c)
+	require.NoError(t, err)
+	defer fw.Stop()
+
+	var received atomic.Int64
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		appendHistory(t, file.Path, 2, perFile)
+		for gen := 2; gen <= files; gen++ {
+			// Rotating removes the previous .old file, it must have been opened already
+			for received.Load() <= int64((gen-2)*perFile) {
+				time.Sleep(time.Millisecond)
+			}
+			rotate(t, file.Path)
+			appendHistory(t, file.Path, (gen-1)*perFile+1, gen*perFile)
+		}
+	}()
+
+	inodes := make(map[uint64]bool)
+	for want := int64(1); want <= perFile*files; want++ {
+		rec := receive(t, c)
+		require.Equal(t, want, rec.Value.(zbxpkg.History).ItemID)
+		require.Equal(t, file.Path, rec.Position.File)
+		inodes[rec.Position.Inode] = true
+		received.Add(1)
+	}
+	<-writerDone
+	require.GreaterOrEqual(t, len(inodes), 2)
+
+	select {
+	case rec := <-c:
+		t.Fatalf("unexpected record %v", rec)
+	case <-time.After(3 * FOLLOW_POLL_INTERVAL):
+	}
+}
+
+func TestFollower_ResumeFromRotatedFile(t *testing.T) {
+	db, cleanup := createTempBadgerDB(t)
+	defer cleanup()
+	file := historyFile(t.TempDir())
+
+	appendHistory(t, file.Path, 1, 4)
+	savePosition(t, db, positionAfter(t, file.Path, 2))
+	// rotated while ZMS was not running, lines 3 and 4 were not read yet
+	rotate(t, file.Path)
+	appendHistory(t, file.Path, 5, 6)
+
+	c := make(chan any, 10)
+	fw, err := followExport(file, DEFAULT_EXPORT_FILE_SIZE, db, nil, c)
+	require.NoError(t, err)
+	defer fw.Stop()
+
+	require.Equal(t, []int64{3, 4, 5, 6}, receiveIDs(t, c, 4))
+}
+
+func TestFollower_Truncated(t *testing.T) {
+	db, cleanup := createTempBadgerDB(t)
+	defer cleanup()
+	file := historyFile(t.TempDir())
+	appendHistory(t, file.Path, 1, 3)
+
+	c := make(chan any, 10)
+	fw, err := followExport(file, DEFAULT_EXPORT_FILE_SIZE, db, nil, c)
+	require.NoError(t, err)
+	defer fw.Stop()
+	require.Equal(t, []int64{1, 2, 3}, receiveIDs(t, c, 3))
+
+	require.NoError(t, os.Truncate(file.Path, 0))
+	appendHistory(t, file.Path, 4, 4)
+	require.Equal(t, []int64{4}, receiveIDs(t, c, 1))
+}
+
+func TestFollower_DeadLetter(t *testing.T) {
+	db, cleanup := createTempBadgerDB(t)
+	defer cleanup()
+	file := historyFile(t.TempDir())
+	appendHistory(t, file.Path, 1, 1)
+	invalidAt := positionAfter(t, file.Path, 1).Offset
+	f, err := os.OpenFile(file.Path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"itemid":2,"clock":` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	appendHistory(t, file.Path, 3, 3)
+
+	dir := t.TempDir()
+	dead, err := deadletter.Open(config.DeadLetterConf{Dir: dir, MaxFileSize: 1 << 20, MaxSize: 1 << 20})
+	require.NoError(t, err)
+	defer dead.Close()
+
+	c := make(chan any, 10)
+	fw, err := followExport(file, DEFAULT_EXPORT_FILE_SIZE, db, dead, c)
+	require.NoError(t, err)
+	defer fw.Stop()
+	require.Equal(t, []int64{1, 3}, receiveIDs(t, c, 2))
+
+	var entries []deadletter.Entry
+	require.NoError(t, deadletter.ReadAll(dir, func(e deadletter.Entry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	require.Len(t, entries, 1)
+	require.Equal(t, `{"itemid":2,"clock":`, entries[0].Line)
+	require.Equal(t, file.Path, entries[0].File)
+	require.Equal(t, invalidAt, entries[0].Offset)
+	require.Equal(t, zbxpkg.HISTORY, entries[0].Export)
+	require.NotEmpty(t, entries[0].Error)
+}
+
+func TestStartPosition_Identity(t *testing.T) {
+	db, cleanup := createTempBadgerDB(t)
+	defer cleanup()
+	file := historyFile(t.TempDir())
+	rotated := file.Path + ROTATED_SUFFIX
+
+	appendHistory(t, file.Path, 1, 3)
+	saved := positionAfter(t, file.Path, 2)
+	savePosition(t, db, saved)
+
+	path, offset := startPosition(db, file.Path)
+	require.Equal(t, file.Path, path)
+	require.Equal(t, saved.Offset, offset)
+
+	// same inode, different content: the inode was reused by a new file
+	savePosition(t, db, Position{File: file.Path, Offset: saved.Offset, Inode: saved.Inode, Fingerprint: saved.Fingerprint + 1})
+	path, offset = startPosition(db, file.Path)
+	require.Equal(t, file.Path, path)
+	require.Equal(t, int64(0), offset)
+
+	// rotated since
+	savePosition(t, db, saved)
+	rotate(t, file.Path)
+	appendHistory(t, file.Path, 10, 20)
+	path, offset = startPosition(db, file.Path)
+	require.Equal(t, rotated, path)
+	require.Equal(t, saved.Offset, offset)
+
+	// rotated twice, the saved file is gone and .old was never read
+	rotate(t, file.Path)
+	appendHistory(t, file.Path, 30, 30)
+	path, offset = startPosition(db, file.Path)
+	require.Equal(t, rotated, path)
+	require.Equal(t, int64(0), offset)
+}
@@ -0,0 +1,189 @@
+package zbx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"zms.szuro.net/internal/config"
+)
+
+// Node statuses as reported by Zabbix
+const (
+	NODE_STANDALONE  = "standalone"
+	NODE_ACTIVE      = "active"
+	NODE_STANDBY     = "standby"
+	NODE_STOPPED     = "stopped"
+	NODE_UNAVAILABLE = "unavailable"
+)
+
+// ErrInitialSync is returned while Zabbix server loads its configuration cache
+// and cannot tell its HA status yet.
+var ErrInitialSync = errors.New("zabbix server is performing initial configuration cache sync")
+
+// NodeInfo describes a Zabbix server node.
+type NodeInfo struct {
+	ID      string
+	Name    string
+	Address string
+	Status  string
+}
+
+// HAStatus is the state of the Zabbix server node ZMS runs on.
+type HAStatus struct {
+	Node          NodeInfo
+	Nodes         []NodeInfo // all nodes of the cluster, if known
+	FailoverDelay time.Duration
+}
+
+// Active reports if the node writes export files.
+func (s HAStatus) Active() bool {
+	return s.Node.Status == NODE_ACTIVE || s.Node.Status == NODE_STANDALONE
+}
+
+// HADetector tells whether the Zabbix server node is active.
+type HADetector interface {
+	Status(ctx context.Context) (HAStatus, error)
+}
+
+// NewHADetector creates the detector selected in the config for the node named in the Zabbix config.
+func NewHADetector(conf config.HAConf, zbxConf ZabbixConf) (HADetector, error) {
+	switch conf.Detector {
+	case config.HA_STATIC:
+		return StaticDetector{NodeName: zbxConf.NodeName}, nil
+	case config.HA_DATABASE:
+		return NewDBDetector(conf.Database.Driver, conf.Database.DSN, zbxConf.NodeName)
+	case config.HA_RUNTIME_CONTROL, "":
+		return NewRuntimeControlDetector(zbxConf), nil
+	}
+	return nil, fmt.Errorf("unknown HA detector %q", conf.Detector)
+}
+
+// standalone returns the status of a node running without HA, it is always active.
+func standalone() HAStatus {
+	return HAStatus{Node: NodeInfo{Status: NODE_STANDALONE}}
+}
+
+// findNode returns status of the named node among nodes of the cluster.
+func findNode(nodes []NodeInfo, name string, delay time.Duration) (HAStatus, error) {
+	status := HAStatus{Nodes: nodes, FailoverDelay: delay}
+	for _, node := range nodes {
+		if node.Name == name {
+			status.Node = node
+			return status, nil
+		}
+	}
+	return status, fmt.Errorf("node %q not found in HA cluster", name)
+}
+
+// StaticDetector always reports the node as active.
+// It suits setups where HA is handled outside of Zabbix.
+type StaticDetector struct {
+	NodeName string
+}
+
+func (d StaticDetector) Status(ctx context.Context) (HAStatus, error) {
+	return HAStatus{Node: NodeInfo{Name: d.NodeName, Status: NODE_ACTIVE}}, nil
+}
+
+// haNodeStatuses maps values of ha_node.status to node statuses
+var haNodeStatuses = map[int]string{
+	0: NODE_STANDBY,
+	1: NODE_STOPPED,
+	2: NODE_UNAVAILABLE,
+	3: NODE_ACTIVE,
+}
+
+// DBDetector reads node status from the ha_node table of the Zabbix database.
+// It needs only read access to ha_node and config tables.
+type DBDetector struct {
+	db       *sql.DB
+	nodeName string
+}
+
+// NewDBDetector connects to the Zabbix database using a registered database/sql driver.
+func NewDBDetector(driver, dsn, nodeName string) (*DBDetector, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return &DBDetector{db: db, nodeName: nodeName}, nil
+}
+
+func (d *DBDetector) Status(ctx context.Context) (HAStatus, error) {
+	if d.nodeName == "" {
+		return standalone(), nil
+	}
+
+	var failoverDelay string
+	if err := d.db.QueryRowContext(ctx, "SELECT ha_failover_delay FROM config").Scan(&failoverDelay); err != nil {
+		return HAStatus{}, fmt.Errorf("failed to read failover delay: %w", err)
+	}
+	delay, err := parseZabbixDuration(failoverDelay)
+	if err != nil {
+		return HAStatus{}, fmt.Errorf("invalid failover delay %q: %w", failoverDelay, err)
+	}
+
+	rows, err := d.db.QueryContext(ctx, "SELECT ha_nodeid, name, address, port, status FROM ha_node ORDER BY name")
+	if err != nil {
+		return HAStatus{}, fmt.Errorf("failed to read HA nodes: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []NodeInfo
+	for rows.Next() {
+		var node NodeInfo
+		var port, status int
+		if err := rows.Scan(&node.ID, &node.Name, &node.Address, &port, &status); err != nil {
+			return HAStatus{}, fmt.Errorf("failed to read HA node: %w", err)
+		}
+		node.Address = net.JoinHostPort(node.Address, strconv.Itoa(port))
+		node.Status = haNodeStatus(status)
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return HAStatus{}, fmt.Errorf("failed to read HA nodes: %w", err)
+	}
+	return findNode(nodes, d.nodeName, delay)
+}
+
+// Close closes the database connection.
+func (d *DBDetector) Close() error {
+	return d.db.Close()
+}
+
+func haNodeStatus(status int) string {
+	if s, ok := haNodeStatuses[status]; ok {
+		return s
+	}
+	return "unknown (" + strconv.Itoa(status) + ")"
+}
+
+var durationSuffixes = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// parseZabbixDuration parses durations with an optional s, m, h, d or w suffix, like ha_failover_delay=1m.
+func parseZabbixDuration(value string) (time.Duration, error) {
+	unit := time.Second
+	if value != "" {
+		if u, ok := durationSuffixes[value[len(value)-1]]; ok {
+			unit = u
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n) * unit, nil
+}
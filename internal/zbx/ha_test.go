@@ -0,0 +1,64 @@
+package zbx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/internal/config"
+)
+
+func TestNewHADetector(t *testing.T) {
+	zbxConf := ZabbixConf{configPath: "/etc/zabbix/zabbix_server.conf", NodeName: "node1"}
+
+	d, err := NewHADetector(config.HAConf{Detector: config.HA_STATIC}, zbxConf)
+	require.NoError(t, err)
+	status, err := d.Status(context.Background())
+	require.NoError(t, err)
+	require.True(t, status.Active())
+	require.Equal(t, "node1", status.Node.Name)
+
+	d, err = NewHADetector(config.HAConf{Detector: config.HA_RUNTIME_CONTROL}, zbxConf)
+	require.NoError(t, err)
+	require.IsType(t, &RuntimeControlDetector{}, d)
+
+	_, err = NewHADetector(config.HAConf{Detector: config.HA_DATABASE, Database: config.HADatabaseConf{Driver: "unregistered", DSN: "dsn"}}, zbxConf)
+	require.ErrorContains(t, err, "unknown driver")
+
+	_, err = NewHADetector(config.HAConf{Detector: "magic"}, zbxConf)
+	require.Error(t, err)
+}
+
+func TestHANodeStatus(t *testing.T) {
+	require.Equal(t, NODE_STANDBY, haNodeStatus(0))
+	require.Equal(t, NODE_ACTIVE, haNodeStatus(3))
+	require.Equal(t, "unknown (7)", haNodeStatus(7))
+}
+
+func TestParseZabbixDuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		err      bool
+	}{
+		{value: "60", expected: time.Minute},
+		{value: "30s", expected: 30 * time.Second},
+		{value: "1m", expected: time.Minute},
+		{value: "15m", expected: 15 * time.Minute},
+		{value: "1h", expected: time.Hour},
+		{value: "1x", err: true},
+		{value: "", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			d, err := parseZabbixDuration(tt.value)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, d)
+		})
+	}
+}
@@ -3,14 +3,14 @@
 package zbx
 
 import (
-	"log/slog"
+	"context"
+	"errors"
+	"fmt"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
-
-	"zms.szuro.net/internal/logger"
 )
 
 const (
@@ -20,65 +20,89 @@ const (
 	DEFAULT_DELAY = 60 * time.Second
 )
 
-// Returns failover delay in seconds
-func GetFailoverDelay(input string) time.Duration {
-	re := regexp.MustCompile(`Failover delay: (?P<delay>\d+) seconds`)
-	match := re.FindStringSubmatch(input)
-	delay, _ := strconv.Atoi(match[1])
-	return time.Duration(delay) * time.Second
+var (
+	failoverDelayRegex = regexp.MustCompile(`Failover delay: (\d+) seconds`)
+	// #  ID  Name  Address  Status  Last Access
+	nodeLineRegex = regexp.MustCompile(`^\s*\d+\.\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)`)
+)
 
+// RuntimeControlDetector reads node status from the output of zabbix_server -R ha_status.
+// It needs the zabbix_server binary and permissions to run runtime control commands.
+type RuntimeControlDetector struct {
+	Command    string // zabbix_server binary
+	ConfigPath string
+	NodeName   string
 }
 
-// Extracts NodeName and node status from a single line of ha_status output
-func ExtractNameAndStatus(input string) (string, string) {
-	re := regexp.MustCompile(`\d+\.\s+\S+\s+(\S+)\s+\S+\s+(\S+)`)
-	match := re.FindStringSubmatch(input)
+func NewRuntimeControlDetector(conf ZabbixConf) *RuntimeControlDetector {
+	return &RuntimeControlDetector{Command: "zabbix_server", ConfigPath: conf.configPath, NodeName: conf.NodeName}
+}
 
-	if len(match) > 2 {
-		name := strings.TrimSpace(match[1])
-		status := strings.TrimSpace(match[2])
-		return name, status
+func (d *RuntimeControlDetector) Status(ctx context.Context) (HAStatus, error) {
+	out, err := exec.CommandContext(ctx, d.Command, "-c", d.ConfigPath, "-R", "ha_status").Output()
+	output := strings.TrimSpace(string(out))
+	// failed runtime control commands still explain why on standard output
+	if output == "" {
+		if err == nil {
+			err = errors.New("empty output")
+		}
+		return HAStatus{}, fmt.Errorf("failed to run ha_status: %w", err)
 	}
-
-	return "", ""
+	return parseHAStatus(output, d.NodeName)
 }
 
-// Returns failover delay in seconds and if the node is active based on zabbix_server command
-func GetHaStatus(config ZabbixConf) (delay time.Duration, nodeIsActive bool) {
-	cmd := exec.Command("zabbix_server", "-c", config.configPath, "-R", "ha_status")
-	var outString string
-	out, err := cmd.Output()
+// parseHAStatus parses ha_status output of zabbix_server.
+func parseHAStatus(output, nodeName string) (HAStatus, error) {
+	lines := strings.Split(output, "\n")
+	switch strings.TrimSpace(lines[0]) {
+	case INITIAL_SYNC:
+		return HAStatus{}, ErrInitialSync
+	case NON_ACTIVE:
+		// only the active node answers runtime control commands
+		return HAStatus{Node: NodeInfo{Name: nodeName, Status: NODE_STANDBY}, FailoverDelay: DEFAULT_DELAY}, nil
+	}
 
-	outString = strings.TrimRight(string(out[:]), "\n")
+	delay, err := parseFailoverDelay(lines[0])
 	if err != nil {
-		logger.Error("Failed to get HA status", slog.Any("error", err))
-	} else if outString == INITIAL_SYNC {
-		logger.Info("Waiting for initial sync to end...")
-		time.Sleep(DEFAULT_DELAY)
+		return HAStatus{}, err
 	}
-
-	lines := strings.Split(outString, "\n")
-
-	if len(lines) == HEADER_LEN {
-		logger.Info("Node running in standalone mode")
-		nodeIsActive = true
+	if nodeName == "" {
+		return standalone(), nil
 	}
-
-	if strings.TrimRight(lines[0], "\n") == NON_ACTIVE {
-		var d time.Duration = DEFAULT_DELAY
-		logger.Info("Node in non-active mode, waiting", slog.Duration("delay", d))
-		delay = time.Duration(d)
-		return
-	} else {
-		delay = GetFailoverDelay(lines[0])
+	if len(lines) < HEADER_LEN {
+		return HAStatus{}, fmt.Errorf("unexpected ha_status output: %q", output)
 	}
 
+	var nodes []NodeInfo
 	for _, line := range lines[HEADER_LEN:] {
-		name, status := ExtractNameAndStatus(line)
-		if name == config.NodeName && status == "active" {
-			nodeIsActive = true
+		if node, ok := parseNodeLine(line); ok {
+			nodes = append(nodes, node)
 		}
 	}
+	if len(nodes) == 0 {
+		return standalone(), nil
+	}
+	return findNode(nodes, nodeName, delay)
+}
+
+// parseFailoverDelay parses the first line of ha_status output.
+func parseFailoverDelay(input string) (time.Duration, error) {
+	match := failoverDelayRegex.FindStringSubmatch(input)
+	if match == nil {
+		return 0, fmt.Errorf("unexpected ha_status output: %q", input)
+	}
+	delay, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(delay) * time.Second, nil
+}
 
-	return
+// parseNodeLine parses a single node line of ha_status output.
+func parseNodeLine(input string) (node NodeInfo, ok bool) {
+	match := nodeLineRegex.FindStringSubmatch(input)
+	if match == nil {
+		return node, false
+	}
+	return NodeInfo{ID: match[1], Name: match[2], Address: match[3], Status: match[4]}, true
 }
@@ -1,29 +1,99 @@
+//go:build !debug
+
 package zbx
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
-func TestGetFailoverDelay(t *testing.T) {
+const haStatusOutput = `Failover delay: 30 seconds
+Cluster status:
+   #  ID                        Name                      Address                        Status      Last Access
+   1. clbtq9w8z0001yxr4uk3ygjcy node1                     192.168.1.10:10051             active      3s
+   2. clbtqa1h70001zhr4ojkbyk0o node2                     192.168.1.11:10051             standby     1s
+`
+
+func TestParseFailoverDelay(t *testing.T) {
 	tests := []struct {
 		name     string
 		input    string
 		expected time.Duration
+		err      bool
 	}{
 		{
 			name:     "Valid input with delay",
 			input:    "Some text here. Failover delay: 30 seconds",
 			expected: time.Duration(30) * time.Second,
 		},
+		{
+			name:  "Unexpected output",
+			input: "zabbix_server [1234]: cannot open config file",
+			err:   true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := GetFailoverDelay(tt.input)
-			if result != tt.expected {
-				t.Errorf("GetFailoverDelay() = %v, expected %v", result, tt.expected)
+			result, err := parseFailoverDelay(tt.input)
+			if tt.err {
+				require.Error(t, err)
+				return
 			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, result)
 		})
 	}
 }
+
+func TestParseHAStatus(t *testing.T) {
+	status, err := parseHAStatus(haStatusOutput, "node2")
+	require.NoError(t, err)
+	require.False(t, status.Active())
+	require.Equal(t, 30*time.Second, status.FailoverDelay)
+	require.Equal(t, NodeInfo{ID: "clbtqa1h70001zhr4ojkbyk0o", Name: "node2", Address: "192.168.1.11:10051", Status: NODE_STANDBY}, status.Node)
+	require.Len(t, status.Nodes, 2)
+
+	status, err = parseHAStatus(haStatusOutput, "node1")
+	require.NoError(t, err)
+	require.True(t, status.Active())
+
+	_, err = parseHAStatus(haStatusOutput, "node3")
+	require.ErrorContains(t, err, "not found")
+
+	status, err = parseHAStatus(haStatusOutput, "")
+	require.NoError(t, err)
+	require.Equal(t, NODE_STANDALONE, status.Node.Status)
+
+	status, err = parseHAStatus(NON_ACTIVE, "node2")
+	require.NoError(t, err)
+	require.False(t, status.Active())
+
+	_, err = parseHAStatus(INITIAL_SYNC, "node1")
+	require.ErrorIs(t, err, ErrInitialSync)
+
+	_, err = parseHAStatus("unexpected", "node1")
+	require.Error(t, err)
+}
+
+func TestRuntimeControlDetector(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output")
+	require.NoError(t, os.WriteFile(output, []byte(haStatusOutput), 0o600))
+	command := filepath.Join(dir, "zabbix_server")
+	require.NoError(t, os.WriteFile(command, []byte("#!/bin/sh\ncat "+output+"\n"), 0o700))
+
+	d := &RuntimeControlDetector{Command: command, ConfigPath: "/etc/zabbix/zabbix_server.conf", NodeName: "node1"}
+	status, err := d.Status(context.Background())
+	require.NoError(t, err)
+	require.True(t, status.Active())
+
+	d.Command = filepath.Join(dir, "missing")
+	_, err = d.Status(context.Background())
+	require.Error(t, err)
+}
@@ -32,39 +32,35 @@ func createTempBadgerDB(t *testing.T) (*badger.DB, func()) {
 	return db, func() { db.Close() }
 }
 
-func TestFindLastReadOffset_NoOffsetInDB(t *testing.T) {
+func TestStartPosition_NoOffsetInDB(t *testing.T) {
 	db, cleanup := createTempBadgerDB(t)
 	defer cleanup()
 	filename, remove := createTempFileWithSize(t, 100)
 	defer remove()
 
-	location, err := findLastReadOffset(db, filename)
-	require.NoError(t, err)
-	require.NotNil(t, location)
-	require.Equal(t, io.SeekStart, location.Whence)
-	require.Equal(t, int64(0), location.Offset)
+	path, offset := startPosition(db, filename)
+	require.Equal(t, filename, path)
+	require.Equal(t, int64(0), offset)
 }
 
-func TestFindLastReadOffset_OffsetInDBWithinFileSize(t *testing.T) {
+func TestStartPosition_OffsetInDBWithinFileSize(t *testing.T) {
 	db, cleanup := createTempBadgerDB(t)
 	defer cleanup()
 	filename, remove := createTempFileWithSize(t, 200)
 	defer remove()
 
-	// Write offset 100 to DB
+	// Write offset 100 to DB, as saved by versions without file identity
 	err := db.Update(func(txn *badger.Txn) error {
 		return txn.Set([]byte(filename), []byte{0, 0, 0, 0, 0, 0, 0, 100})
 	})
 	require.NoError(t, err)
 
-	location, err := findLastReadOffset(db, filename)
-	require.NoError(t, err)
-	require.NotNil(t, location)
-	require.Equal(t, io.SeekStart, location.Whence)
-	require.Equal(t, int64(100), location.Offset)
+	path, offset := startPosition(db, filename)
+	require.Equal(t, filename, path)
+	require.Equal(t, int64(100), offset)
 }
 
-func TestFindLastReadOffset_OffsetInDBGreaterThanFileSize(t *testing.T) {
+func TestStartPosition_OffsetInDBGreaterThanFileSize(t *testing.T) {
 	db, cleanup := createTempBadgerDB(t)
 	defer cleanup()
 	filename, remove := createTempFileWithSize(t, 50)
@@ -76,18 +72,17 @@ func TestFindLastReadOffset_OffsetInDBGreaterThanFileSize(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	location, err := findLastReadOffset(db, filename)
-	require.NoError(t, err)
-	require.NotNil(t, location)
-	require.Equal(t, io.SeekStart, location.Whence)
-	require.Equal(t, int64(0), location.Offset)
+	path, offset := startPosition(db, filename)
+	require.Equal(t, filename, path)
+	require.Equal(t, int64(0), offset)
 }
 
-func TestFindLastReadOffset_FileDoesNotExist(t *testing.T) {
+func TestStartPosition_FileDoesNotExist(t *testing.T) {
 	db, cleanup := createTempBadgerDB(t)
 	defer cleanup()
 	filename := "nonexistent_file_123456"
 
-	_, err := findLastReadOffset(db, filename)
-	require.Error(t, err)
+	path, offset := startPosition(db, filename)
+	require.Equal(t, filename, path)
+	require.Equal(t, int64(0), offset)
 }
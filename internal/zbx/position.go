@@ -0,0 +1,49 @@
+package zbx
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Position is the place in an export file right after a record.
+// Inode and Fingerprint identify the file that was read, so a position saved
+// before the file was rotated is not applied to the new file with the same path.
+type Position struct {
+	File        string
+	Offset      int64
+	Inode       uint64
+	Fingerprint uint64 // hash of the first line of the file, 0 if not known yet
+}
+
+// Record is a parsed export value along with the position it was read from.
+// It lets the pipeline commit file offsets only after values were delivered.
+type Record struct {
+	Value    any
+	Position Position
+}
+
+// MarshalBinary encodes the offset and identity of the file.
+// The path is not encoded, it is the key positions are stored under.
+func (p Position) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 24)
+	binary.BigEndian.PutUint64(b, uint64(p.Offset))
+	binary.BigEndian.PutUint64(b[8:], p.Inode)
+	binary.BigEndian.PutUint64(b[16:], p.Fingerprint)
+	return b, nil
+}
+
+// UnmarshalBinary decodes a position encoded by MarshalBinary.
+// Positions saved by older versions hold only the offset.
+func (p *Position) UnmarshalBinary(b []byte) error {
+	switch len(b) {
+	case 8:
+		p.Offset = int64(binary.BigEndian.Uint64(b))
+	case 24:
+		p.Offset = int64(binary.BigEndian.Uint64(b))
+		p.Inode = binary.BigEndian.Uint64(b[8:])
+		p.Fingerprint = binary.BigEndian.Uint64(b[16:])
+	default:
+		return errors.New("invalid position length")
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package zbx
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"zms.szuro.net/internal/deadletter"
+	"zms.szuro.net/internal/logger"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// exportFileRegex matches export files written by Zabbix processes, e.g.
+// history-history-syncer-1.ndjson, problems-main-process-0.ndjson or problems-task-manager-1.ndjson.
+// Rotated .old files do not match.
+var exportFileRegex = regexp.MustCompile(`^(history|trends|problems)-([a-z]+(?:-[a-z]+)*)-(\d+)\.ndjson$`)
+
+var exportFilePrefixes = map[string]string{
+	"history":  zbxpkg.HISTORY,
+	"trends":   zbxpkg.TREND,
+	"problems": zbxpkg.EVENT,
+}
+
+var tailedFilesGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "zms_export_files_tailed",
+	Help: "Number of export files currently followed",
+}, []string{"export_type"})
+
+// ExportFile is an export file recognized by its name.
+type ExportFile struct {
+	Path    string
+	Export  string // export type, e.g. history
+	Process string // Zabbix process writing the file, e.g. history-syncer
+	Index   int    // number of the process
+}
+
+// ParseExportFileName recognizes export files by name.
+func ParseExportFileName(path string) (file ExportFile, ok bool) {
+	m := exportFileRegex.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return file, false
+	}
+	index, err := strconv.Atoi(m[3])
+	if err != nil {
+		return file, false
+	}
+	return ExportFile{Path: path, Export: exportFilePrefixes[m[1]], Process: m[2], Index: index}, true
+}
+
+// ExportWatcher follows export files in the export directory.
+// Files are recognized by name, so files of processes started later
+// (e.g. after StartDBSyncers was raised) are picked up as soon as they appear,
+// and files that go away stop being followed.
+type ExportWatcher struct {
+	dir     string
+	maxSize int64 // ExportFileSize
+	indexDB *badger.DB
+	dead    *deadletter.Store
+	funnels map[string]chan any // by export type
+
+	mu        sync.Mutex
+	followers map[string]*follower // by file path
+
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// NewExportWatcher creates a watcher of the export directory of the Zabbix config,
+// sending values of every export type to its funnel. Export types without a funnel are ignored.
+// Lines that cannot be parsed are kept in dead, unless it is nil.
+func NewExportWatcher(conf ZabbixConf, indexDB *badger.DB, dead *deadletter.Store, funnels map[string]chan any) *ExportWatcher {
+	return &ExportWatcher{
+		dir:       conf.ExportDir,
+		maxSize:   conf.ExportFileSize,
+		indexDB:   indexDB,
+		dead:      dead,
+		funnels:   funnels,
+		followers: make(map[string]*follower),
+	}
+}
+
+// Start follows existing export files and watches the directory for new ones.
+func (w *ExportWatcher) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(w.dir); err != nil {
+		watcher.Close()
+		return err
+	}
+	w.watcher = watcher
+	w.done = make(chan struct{})
+
+	// Scan after the watch is added, so files created meanwhile are not missed
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+	for _, e := range entries {
+		w.add(filepath.Join(w.dir, e.Name()))
+	}
+
+	go w.watch()
+	return nil
+}
+
+// Stop stops watching the directory and following files.
+func (w *ExportWatcher) Stop() {
+	if w.watcher != nil {
+		w.watcher.Close()
+		<-w.done
+	}
+
+	w.mu.Lock()
+	for path := range w.followers {
+		w.stopFollowing(path)
+	}
+	w.mu.Unlock()
+}
+
+// Files returns paths of followed files.
+func (w *ExportWatcher) Files() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	files := make([]string, 0, len(w.followers))
+	for path := range w.followers {
+		files = append(files, path)
+	}
+	slices.Sort(files)
+	return files
+}
+
+func (w *ExportWatcher) watch() {
+	defer close(w.done)
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Error("Failed to watch export directory", slog.String("dir", w.dir), slog.Any("error", err))
+		}
+	}
+}
+
+func (w *ExportWatcher) handle(event fsnotify.Event) {
+	// Files renamed during rotation are followed until their end, the follower
+	// switches to the new file by itself
+	switch {
+	case event.Has(fsnotify.Create):
+		w.add(event.Name)
+	case event.Has(fsnotify.Remove):
+		if _, err := os.Stat(event.Name); errors.Is(err, fs.ErrNotExist) {
+			w.remove(event.Name)
+		}
+	}
+}
+
+// add starts following the file if it is an export file of an enabled type.
+func (w *ExportWatcher) add(path string) {
+	file, ok := ParseExportFileName(path)
+	if !ok {
+		return
+	}
+	funnel, ok := w.funnels[file.Export]
+	if !ok {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.followers[path]; ok {
+		return
+	}
+	fw, err := followExport(file, w.maxSize, w.indexDB, w.dead, funnel)
+	if err != nil {
+		logger.Error("Could not open export", slog.String("file", path), slog.Any("error", err))
+		return
+	}
+	w.followers[path] = fw
+	tailedFilesGauge.WithLabelValues(file.Export).Inc()
+}
+
+func (w *ExportWatcher) remove(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.followers[path]; ok {
+		logger.Info("Export file removed, stopped following", slog.String("file", path))
+		w.stopFollowing(path)
+	}
+}
+
+// stopFollowing must be called with the lock held.
+func (w *ExportWatcher) stopFollowing(path string) {
+	w.followers[path].Stop()
+	delete(w.followers, path)
+	if file, ok := ParseExportFileName(path); ok {
+		tailedFilesGauge.WithLabelValues(file.Export).Dec()
+	}
+}
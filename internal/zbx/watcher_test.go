@@ -0,0 +1,84 @@
+package zbx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func TestParseExportFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+		file ExportFile
+	}{
+		{"history-history-syncer-3.ndjson", true, ExportFile{Export: zbxpkg.HISTORY, Process: "history-syncer", Index: 3}},
+		{"trends-main-process-0.ndjson", true, ExportFile{Export: zbxpkg.TREND, Process: "main-process", Index: 0}},
+		{zbxpkg.PROBLEMS_TASK, true, ExportFile{Export: zbxpkg.EVENT, Process: "task-manager", Index: 1}},
+		{"history-history-syncer-3.ndjson.old", false, ExportFile{}},
+		{"history.ndjson", false, ExportFile{}},
+		{"audit-history-syncer-1.ndjson", false, ExportFile{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join("/export", tt.name)
+			file, ok := ParseExportFileName(path)
+			require.Equal(t, tt.ok, ok)
+			if tt.ok {
+				tt.file.Path = path
+				require.Equal(t, tt.file, file)
+			}
+		})
+	}
+}
+
+func receive(t *testing.T, c chan any) Record {
+	t.Helper()
+	select {
+	case v := <-c:
+		return v.(Record)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no record received")
+	}
+	return Record{}
+}
+
+func TestExportWatcher(t *testing.T) {
+	db, cleanup := createTempBadgerDB(t)
+	defer cleanup()
+	dir := t.TempDir()
+
+	history := filepath.Join(dir, "history-history-syncer-1.ndjson")
+	require.NoError(t, os.WriteFile(history, []byte(`{"itemid":1,"clock":1,"ns":0,"value":1,"type":3}`+"\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "trends-history-syncer-1.ndjson"), nil, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600))
+
+	historyC, eventsC := make(chan any, 10), make(chan any, 10)
+	w := NewExportWatcher(ZabbixConf{ExportDir: dir, ExportFileSize: DEFAULT_EXPORT_FILE_SIZE}, db, nil, map[string]chan any{zbxpkg.HISTORY: historyC, zbxpkg.EVENT: eventsC})
+	require.NoError(t, w.Start())
+	defer w.Stop()
+
+	// trends are not enabled
+	require.Equal(t, []string{history}, w.Files())
+	rec := receive(t, historyC)
+	require.Equal(t, history, rec.Position.File)
+	require.Equal(t, int64(1), rec.Value.(zbxpkg.History).ItemID)
+
+	// file of a process that was not running at startup
+	problems := filepath.Join(dir, zbxpkg.PROBLEMS_TASK)
+	require.NoError(t, os.WriteFile(problems, []byte(`{"clock":1,"ns":0,"value":1,"eventid":7}`+"\n"), 0o600))
+	rec = receive(t, eventsC)
+	require.Equal(t, problems, rec.Position.File)
+	require.Equal(t, int64(7), rec.Value.(zbxpkg.Event).EventID)
+	require.Equal(t, []string{history, problems}, w.Files())
+
+	require.NoError(t, os.Remove(history))
+	require.Eventually(t, func() bool {
+		return len(w.Files()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{problems}, w.Files())
+}
@@ -0,0 +1,62 @@
+package filter
+
+import zbxpkg "zms.szuro.net/pkg/zbx"
+
+// ChainFilter combines filters. With Any unset a value must be accepted by
+// every filter (AND), otherwise by at least one of them (OR).
+type ChainFilter struct {
+	Filters []Filter
+	Any     bool
+}
+
+func NewChainFilter(filters []Filter, or bool) *ChainFilter {
+	return &ChainFilter{Filters: filters, Any: or}
+}
+
+func (f *ChainFilter) AcceptHistory(h zbxpkg.History) bool {
+	return f.accept(func(filter Filter) bool { return filter.AcceptHistory(h) })
+}
+func (f *ChainFilter) AcceptTrend(t zbxpkg.Trend) bool {
+	return f.accept(func(filter Filter) bool { return filter.AcceptTrend(t) })
+}
+func (f *ChainFilter) AcceptEvent(e zbxpkg.Event) bool {
+	return f.accept(func(filter Filter) bool { return filter.AcceptEvent(e) })
+}
+
+func (f *ChainFilter) FilterHistory(h []zbxpkg.History) []zbxpkg.History {
+	accepted := make([]zbxpkg.History, 0, len(h))
+	for _, H := range h {
+		if f.AcceptHistory(H) {
+			accepted = append(accepted, H)
+		}
+	}
+	return accepted
+}
+func (f *ChainFilter) FilterTrends(t []zbxpkg.Trend) []zbxpkg.Trend {
+	accepted := make([]zbxpkg.Trend, 0, len(t))
+	for _, T := range t {
+		if f.AcceptTrend(T) {
+			accepted = append(accepted, T)
+		}
+	}
+	return accepted
+}
+func (f *ChainFilter) FilterEvents(e []zbxpkg.Event) []zbxpkg.Event {
+	accepted := make([]zbxpkg.Event, 0, len(e))
+	for _, E := range e {
+		if f.AcceptEvent(E) {
+			accepted = append(accepted, E)
+		}
+	}
+	return accepted
+}
+
+// accept stops at the first filter deciding the result.
+func (f *ChainFilter) accept(accepts func(Filter) bool) bool {
+	for _, filter := range f.Filters {
+		if accepts(filter) == f.Any {
+			return f.Any
+		}
+	}
+	return !f.Any || len(f.Filters) == 0
+}
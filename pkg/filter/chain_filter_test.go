@@ -0,0 +1,50 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func TestChainFilter(t *testing.T) {
+	prodLinux := zbxpkg.History{Groups: []string{"Linux"}, Tags: []zbxpkg.Tag{{Tag: "env", Value: "prod"}}}
+	prodWindows := zbxpkg.History{Groups: []string{"Windows"}, Tags: []zbxpkg.Tag{{Tag: "env", Value: "prod"}}}
+	devLinux := zbxpkg.History{Groups: []string{"Linux"}, Tags: []zbxpkg.Tag{{Tag: "env", Value: "dev"}}}
+	critical := zbxpkg.History{Groups: []string{"Windows"}, Tags: []zbxpkg.Tag{{Tag: "critical"}}}
+
+	tags := FilterConfig{Type: TAG_FILTER_TYPE, Accepted: []string{"env:prod"}}
+	groups := FilterConfig{Type: GROUP_FILTER_TYPE, Accepted: []string{"Linux"}}
+
+	and, err := NewFilter(FilterConfig{Filters: []FilterConfig{tags, groups}})
+	require.NoError(t, err)
+	require.Equal(t, []zbxpkg.History{prodLinux}, and.FilterHistory([]zbxpkg.History{prodLinux, prodWindows, devLinux, critical}))
+
+	or, err := NewFilter(FilterConfig{Mode: OR_MODE, Filters: []FilterConfig{tags, groups}})
+	require.NoError(t, err)
+	require.Equal(t, []zbxpkg.History{prodLinux, prodWindows, devLinux}, or.FilterHistory([]zbxpkg.History{prodLinux, prodWindows, devLinux, critical}))
+
+	// chains nest: (env:prod AND Linux) OR has critical tag
+	nested, err := NewFilter(FilterConfig{Mode: OR_MODE, Filters: []FilterConfig{
+		{Filters: []FilterConfig{tags, groups}},
+		{Type: EXPRESSION_FILTER_TYPE, Expression: `"critical" in tags`},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []zbxpkg.History{prodLinux, critical}, nested.FilterHistory([]zbxpkg.History{prodLinux, prodWindows, devLinux, critical}))
+	require.True(t, nested.AcceptEvent(zbxpkg.Event{Tags: []zbxpkg.Tag{{Tag: "critical"}}}))
+	require.False(t, nested.AcceptTrend(zbxpkg.Trend{Groups: []string{"Windows"}}))
+}
+
+func TestNewFilter_Chain_Invalid(t *testing.T) {
+	tests := map[string]FilterConfig{
+		"unknown filter mode":      {Mode: "xor", Filters: []FilterConfig{{}}},
+		"filters cannot be":        {Type: TAG_FILTER_TYPE, Filters: []FilterConfig{{}}},
+		"mode is only used":        {Mode: AND_MODE},
+		"filters[1]: expression":   {Filters: []FilterConfig{{}, {Type: EXPRESSION_FILTER_TYPE, Expression: "host =="}}},
+		"filters[0]: filters[0]: ": {Filters: []FilterConfig{{Filters: []FilterConfig{{Type: "lua"}}}}},
+	}
+	for msg, c := range tests {
+		_, err := NewFilter(c)
+		require.ErrorContains(t, err, msg)
+	}
+}
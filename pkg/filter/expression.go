@@ -0,0 +1,253 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// ExpressionFilter accepts values for which the expression evaluates to true.
+// The expression is compiled once, e.g.
+//
+//	export == "history" && type == FLOAT && host like "db-*" && !(name like "*temperature*")
+//	severity >= 4 && "Linux" in groups
+//
+// Fields are export, host, hosts, groups, name, type, value, severity, tags, clock, itemid and eventid.
+// Fields a record does not have are null, so comparisons with them are false.
+type ExpressionFilter struct {
+	Expression string
+	root       node
+}
+
+// NewExpressionFilter compiles the expression.
+func NewExpressionFilter(expression string) (*ExpressionFilter, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, fmt.Errorf("expression: %w", err)
+	}
+	p := parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("expression: %w", err)
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("expression: unexpected %s at %d", t, t.pos)
+	}
+	if root.kind != kindBool && root.kind != kindAny {
+		return nil, fmt.Errorf("expression: result is not a boolean")
+	}
+	return &ExpressionFilter{Expression: expression, root: root}, nil
+}
+
+func (f *ExpressionFilter) AcceptHistory(h zbxpkg.History) bool {
+	return f.root.eval(record{history: &h}) == true
+}
+func (f *ExpressionFilter) AcceptTrend(t zbxpkg.Trend) bool {
+	return f.root.eval(record{trend: &t}) == true
+}
+func (f *ExpressionFilter) AcceptEvent(e zbxpkg.Event) bool {
+	return f.root.eval(record{event: &e}) == true
+}
+
+func (f *ExpressionFilter) FilterHistory(h []zbxpkg.History) []zbxpkg.History {
+	accepted := make([]zbxpkg.History, 0, len(h))
+	for _, H := range h {
+		if f.AcceptHistory(H) {
+			accepted = append(accepted, H)
+		}
+	}
+	return accepted
+}
+func (f *ExpressionFilter) FilterTrends(t []zbxpkg.Trend) []zbxpkg.Trend {
+	accepted := make([]zbxpkg.Trend, 0, len(t))
+	for _, T := range t {
+		if f.AcceptTrend(T) {
+			accepted = append(accepted, T)
+		}
+	}
+	return accepted
+}
+func (f *ExpressionFilter) FilterEvents(e []zbxpkg.Event) []zbxpkg.Event {
+	accepted := make([]zbxpkg.Event, 0, len(e))
+	for _, E := range e {
+		if f.AcceptEvent(E) {
+			accepted = append(accepted, E)
+		}
+	}
+	return accepted
+}
+
+// record is the value an expression is evaluated against. Exactly one of the fields is set.
+type record struct {
+	history *zbxpkg.History
+	trend   *zbxpkg.Trend
+	event   *zbxpkg.Event
+}
+
+func (r record) tags() []zbxpkg.Tag {
+	switch {
+	case r.history != nil:
+		return r.history.Tags
+	case r.trend != nil:
+		return r.trend.Tags
+	default:
+		return r.event.Tags
+	}
+}
+
+func (r record) hosts() []string {
+	switch {
+	case r.history != nil && r.history.Host != nil:
+		return []string{r.history.Host.Host}
+	case r.trend != nil && r.trend.Host != nil:
+		return []string{r.trend.Host.Host}
+	case r.event != nil:
+		hosts := make([]string, 0, len(r.event.Hosts))
+		for _, h := range r.event.Hosts {
+			hosts = append(hosts, h.Host)
+		}
+		return hosts
+	}
+	return nil
+}
+
+// tagValues gives access to tags by name.
+type tagValues []zbxpkg.Tag
+
+// get returns the value of the first tag with the name, or nil if there is none.
+func (t tagValues) get(name string) any {
+	for _, tag := range t {
+		if tag.Tag == name {
+			return tag.Value
+		}
+	}
+	return nil
+}
+
+type field struct {
+	kind kind
+	get  func(r record) any
+}
+
+var fields = map[string]field{
+	"export": {kindString, func(r record) any {
+		switch {
+		case r.history != nil:
+			return zbxpkg.HISTORY
+		case r.trend != nil:
+			return zbxpkg.TREND
+		default:
+			return zbxpkg.EVENT
+		}
+	}},
+	// host is the technical name of the host, or of the first host of the trigger for events
+	"host": {kindString, func(r record) any {
+		if hosts := r.hosts(); len(hosts) > 0 {
+			return hosts[0]
+		}
+		return nil
+	}},
+	"hosts": {kindList, func(r record) any { return r.hosts() }},
+	"groups": {kindList, func(r record) any {
+		switch {
+		case r.history != nil:
+			return r.history.Groups
+		case r.trend != nil:
+			return r.trend.Groups
+		default:
+			return r.event.Groups
+		}
+	}},
+	"name": {kindString, func(r record) any {
+		switch {
+		case r.history != nil:
+			return r.history.Name
+		case r.trend != nil:
+			return r.trend.Name
+		default:
+			return r.event.Name
+		}
+	}},
+	"type": {kindNumber, func(r record) any {
+		switch {
+		case r.history != nil:
+			return float64(r.history.Type)
+		case r.trend != nil:
+			return float64(r.trend.Type)
+		}
+		return nil
+	}},
+	// value is the collected value, the average of trends, or 1 for problems and 0 for recoveries
+	"value": {kindAny, func(r record) any {
+		switch {
+		case r.history != nil:
+			return historyValue(r.history.Value)
+		case r.trend != nil:
+			return r.trend.Avg
+		default:
+			return float64(r.event.Value)
+		}
+	}},
+	// severity of problems, or of log entries
+	"severity": {kindNumber, func(r record) any {
+		switch {
+		case r.history != nil:
+			return float64(r.history.Severity)
+		case r.event != nil:
+			return float64(r.event.Severity)
+		}
+		return nil
+	}},
+	"tags": {kindTags, func(r record) any { return tagValues(r.tags()) }},
+	"clock": {kindNumber, func(r record) any {
+		switch {
+		case r.history != nil:
+			return float64(r.history.Clock)
+		case r.trend != nil:
+			return float64(r.trend.Clock)
+		default:
+			return float64(r.event.Clock)
+		}
+	}},
+	"itemid": {kindNumber, func(r record) any {
+		switch {
+		case r.history != nil:
+			return float64(r.history.ItemID)
+		case r.trend != nil:
+			return float64(r.trend.ItemID)
+		}
+		return nil
+	}},
+	"eventid": {kindNumber, func(r record) any {
+		if r.event != nil {
+			return float64(r.event.EventID)
+		}
+		return nil
+	}},
+}
+
+// constants name value types for comparisons with type
+var constants = map[string]float64{
+	"FLOAT":     zbxpkg.FLOAT,
+	"CHARACTER": zbxpkg.CHARACTER,
+	"LOG":       zbxpkg.LOG,
+	"UNSIGNED":  zbxpkg.UNSIGNED,
+	"TEXT":      zbxpkg.TEXT,
+}
+
+// historyValue returns numbers as float64 and text as string.
+func historyValue(v json.Token) any {
+	switch v := v.(type) {
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case float64, string, bool:
+		return v
+	case int64:
+		return float64(v)
+	}
+	return nil
+}
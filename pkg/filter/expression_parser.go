@@ -0,0 +1,559 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string // identifier, unquoted string or operator
+	num  float64
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return "'" + t.text + "'"
+	}
+}
+
+// operators longest first, so "<=" is not read as "<"
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "-", "(", ")", "[", "]", ",", "."}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+scan:
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			s, n, err := unquote(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%w at %d", err, i)
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, pos: i})
+			i += n
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.' || src[i] == 'e' || src[i] == 'E' ||
+				(src[i] == '-' || src[i] == '+') && (src[i-1] == 'e' || src[i-1] == 'E')) {
+				i++
+			}
+			num, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", src[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], num: num, pos: start})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+		default:
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+					i += len(op)
+					continue scan
+				}
+			}
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// unquote reads a string literal at the start of s and returns it with the length it took.
+func unquote(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(s) {
+				break
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// kind is the type of an expression known at compile time.
+type kind int
+
+const (
+	kindAny kind = iota // depends on the record, e.g. value
+	kindBool
+	kindNumber
+	kindString
+	kindList
+	kindTags
+)
+
+// node is a compiled part of an expression.
+type node struct {
+	kind kind
+	eval func(r record) any
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *parser) expect(op string) error {
+	if t := p.next(); t.kind != tokOp || t.text != op {
+		return fmt.Errorf("expected '%s', got %s at %d", op, t, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+	for p.isOp("||") {
+		t := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return right, err
+		}
+		if err := requireBool(t, left, right); err != nil {
+			return node{}, err
+		}
+		l, r := left.eval, right.eval
+		left = node{kind: kindBool, eval: func(rec record) any { return l(rec) == true || r(rec) == true }}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return left, err
+	}
+	for p.isOp("&&") {
+		t := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return right, err
+		}
+		if err := requireBool(t, left, right); err != nil {
+			return node{}, err
+		}
+		l, r := left.eval, right.eval
+		left = node{kind: kindBool, eval: func(rec record) any { return l(rec) == true && r(rec) == true }}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("!") {
+		t := p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return operand, err
+		}
+		if err := requireBool(t, operand); err != nil {
+			return node{}, err
+		}
+		e := operand.eval
+		return node{kind: kindBool, eval: func(rec record) any { return e(rec) != true }}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return left, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == tokOp && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return right, err
+		}
+		return compare(t, left, right)
+	case t.kind == tokOp && t.text == "=~", t.kind == tokIdent && t.text == "like":
+		p.next()
+		pattern := p.next()
+		if pattern.kind != tokString {
+			return node{}, fmt.Errorf("'%s' needs a string literal, got %s at %d", t.text, pattern, pattern.pos)
+		}
+		return match(t, left, pattern)
+	case t.kind == tokIdent && t.text == "in":
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return right, err
+		}
+		return contains(t, left, right)
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return n, err
+	}
+	for p.isOp("[") || p.isOp(".") {
+		t := p.next()
+		var key string
+		if t.text == "[" {
+			k := p.next()
+			if k.kind != tokString {
+				return node{}, fmt.Errorf("tag name must be a string literal, got %s at %d", k, k.pos)
+			}
+			if err := p.expect("]"); err != nil {
+				return node{}, err
+			}
+			key = k.text
+		} else {
+			k := p.next()
+			if k.kind != tokIdent {
+				return node{}, fmt.Errorf("expected tag name, got %s at %d", k, k.pos)
+			}
+			key = k.text
+		}
+		if n.kind != kindTags {
+			return node{}, fmt.Errorf("only tags can be indexed at %d", t.pos)
+		}
+		e := n.eval
+		n = node{kind: kindString, eval: func(rec record) any {
+			tags, _ := e(rec).(tagValues)
+			return tags.get(key)
+		}}
+	}
+	return n, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return constant(kindNumber, t.num), nil
+	case tokString:
+		return constant(kindString, t.text), nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return constant(kindBool, true), nil
+		case "false":
+			return constant(kindBool, false), nil
+		case "null":
+			return constant(kindAny, nil), nil
+		}
+		if p.isOp("(") {
+			return p.parseCall(t)
+		}
+		if v, ok := constants[t.text]; ok {
+			return constant(kindNumber, v), nil
+		}
+		f, ok := fields[t.text]
+		if !ok {
+			return node{}, fmt.Errorf("unknown field '%s' at %d", t.text, t.pos)
+		}
+		return node{kind: f.kind, eval: f.get}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			n, err := p.parseOr()
+			if err != nil {
+				return n, err
+			}
+			return n, p.expect(")")
+		case "[":
+			return p.parseList()
+		case "-":
+			if n := p.next(); n.kind == tokNumber {
+				return constant(kindNumber, -n.num), nil
+			}
+		}
+	}
+	return node{}, fmt.Errorf("unexpected %s at %d", t, t.pos)
+}
+
+// parseList reads a list of literals after the opening bracket.
+func (p *parser) parseList() (node, error) {
+	var list []any
+	for !p.isOp("]") {
+		if len(list) > 0 {
+			if err := p.expect(","); err != nil {
+				return node{}, err
+			}
+		}
+		t := p.next()
+		if t.kind == tokOp && t.text == "-" && p.peek().kind == tokNumber {
+			t = p.next()
+			t.num = -t.num
+		}
+		switch t.kind {
+		case tokString:
+			list = append(list, t.text)
+		case tokNumber:
+			list = append(list, t.num)
+		case tokIdent:
+			v, ok := constants[t.text]
+			if !ok {
+				return node{}, fmt.Errorf("lists may only contain literals, got %s at %d", t, t.pos)
+			}
+			list = append(list, v)
+		default:
+			return node{}, fmt.Errorf("lists may only contain literals, got %s at %d", t, t.pos)
+		}
+	}
+	p.next()
+	return constant(kindList, list), nil
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	p.next()
+	switch name.text {
+	case "has_tag":
+		arg := p.next()
+		if arg.kind != tokString {
+			return node{}, fmt.Errorf("has_tag needs a string literal, got %s at %d", arg, arg.pos)
+		}
+		m, err := ParseTagMatcher(arg.text)
+		if err != nil {
+			return node{}, fmt.Errorf("has_tag at %d: %w", arg.pos, err)
+		}
+		if err := p.expect(")"); err != nil {
+			return node{}, err
+		}
+		return node{kind: kindBool, eval: func(rec record) any {
+			for _, tag := range rec.tags() {
+				if m.Match(tag) {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	}
+	return node{}, fmt.Errorf("unknown function '%s' at %d", name.text, name.pos)
+}
+
+func constant(k kind, v any) node {
+	return node{kind: k, eval: func(record) any { return v }}
+}
+
+func requireBool(op token, nodes ...node) error {
+	for _, n := range nodes {
+		if n.kind != kindBool && n.kind != kindAny {
+			return fmt.Errorf("'%s' needs boolean operands at %d", op.text, op.pos)
+		}
+	}
+	return nil
+}
+
+func compare(op token, left, right node) (node, error) {
+	for _, n := range []node{left, right} {
+		if n.kind == kindList || n.kind == kindTags {
+			return node{}, fmt.Errorf("'%s' cannot compare lists or tags at %d, use 'in' or 'like'", op.text, op.pos)
+		}
+	}
+	l, r := left.eval, right.eval
+	var cmp func(c int, ok bool) bool
+	switch op.text {
+	case "==":
+		return node{kind: kindBool, eval: func(rec record) any { return equal(l(rec), r(rec)) }}, nil
+	case "!=":
+		return node{kind: kindBool, eval: func(rec record) any { return !equal(l(rec), r(rec)) }}, nil
+	case "<":
+		cmp = func(c int, ok bool) bool { return ok && c < 0 }
+	case "<=":
+		cmp = func(c int, ok bool) bool { return ok && c <= 0 }
+	case ">":
+		cmp = func(c int, ok bool) bool { return ok && c > 0 }
+	case ">=":
+		cmp = func(c int, ok bool) bool { return ok && c >= 0 }
+	}
+	return node{kind: kindBool, eval: func(rec record) any { return cmp(order(l(rec), r(rec))) }}, nil
+}
+
+// match compiles a glob for like or a regex for =~. Lists match if any element does.
+func match(op token, left node, pattern token) (node, error) {
+	var re *regexp.Regexp
+	if op.text == "like" {
+		m, err := compileMatcher(pattern.text)
+		if err != nil {
+			return node{}, fmt.Errorf("pattern at %d: %w", pattern.pos, err)
+		}
+		re = m.re
+		if re == nil {
+			expr := "^" + regexp.QuoteMeta(m.exact) + "$"
+			if m.any {
+				expr = "(?s).*"
+			}
+			// patterns are not validated as UTF-8 before, so compiling may fail
+			if re, err = regexp.Compile(expr); err != nil {
+				return node{}, fmt.Errorf("pattern at %d: %w", pattern.pos, err)
+			}
+		}
+	} else {
+		var err error
+		if re, err = regexp.Compile(pattern.text); err != nil {
+			return node{}, fmt.Errorf("regex at %d: %w", pattern.pos, err)
+		}
+	}
+	switch left.kind {
+	case kindBool, kindNumber, kindTags:
+		return node{}, fmt.Errorf("'%s' needs a string or a list at %d", op.text, op.pos)
+	}
+	l := left.eval
+	return node{kind: kindBool, eval: func(rec record) any {
+		switch v := l(rec).(type) {
+		case string:
+			return re.MatchString(v)
+		case []string:
+			for _, s := range v {
+				if re.MatchString(s) {
+					return true
+				}
+			}
+		}
+		return false
+	}}, nil
+}
+
+// contains checks a value is an element of a list, or a tag name is set.
+func contains(op token, left, right node) (node, error) {
+	switch right.kind {
+	case kindList, kindTags, kindAny:
+	default:
+		return node{}, fmt.Errorf("'in' needs a list or tags at %d", op.pos)
+	}
+	l, r := left.eval, right.eval
+	return node{kind: kindBool, eval: func(rec record) any {
+		v := l(rec)
+		switch list := r(rec).(type) {
+		case []any:
+			for _, e := range list {
+				if equal(v, e) {
+					return true
+				}
+			}
+		case []string:
+			for _, e := range list {
+				if equal(v, e) {
+					return true
+				}
+			}
+		case tagValues:
+			name, ok := v.(string)
+			return ok && list.get(name) != nil
+		}
+		return false
+	}}, nil
+}
+
+// equal compares values of the same type. Numbers and numeric strings are compared as numbers.
+func equal(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if a, ok := a.(bool); ok {
+		b, ok := b.(bool)
+		return ok && a == b
+	}
+	c, ok := order(a, b)
+	return ok && c == 0
+}
+
+// order compares numbers or strings. It reports false for values that cannot be ordered.
+func order(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := toNumber(b); ok {
+			return cmpFloat(a, b), true
+		}
+	case string:
+		switch b := b.(type) {
+		case string:
+			return strings.Compare(a, b), true
+		case float64:
+			if a, ok := toNumber(a); ok {
+				return cmpFloat(a, b), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func toNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
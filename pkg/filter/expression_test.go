@@ -0,0 +1,140 @@
+package filter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func TestExpressionFilter(t *testing.T) {
+	dbFloat := zbxpkg.History{
+		Host:   &zbxpkg.Host{Host: "db-1"},
+		Name:   "CPU load",
+		Type:   zbxpkg.FLOAT,
+		Value:  json.Number("0.75"),
+		Groups: []string{"Linux", "Databases"},
+		Tags:   []zbxpkg.Tag{{Tag: "env", Value: "prod:eu"}},
+		Clock:  1700000000,
+	}
+	dbTemperature := dbFloat
+	dbTemperature.Name = "CPU temperature"
+	webText := zbxpkg.History{Host: &zbxpkg.Host{Host: "web-1"}, Type: zbxpkg.TEXT, Value: "ok"}
+	problem := zbxpkg.Event{
+		Value:    1,
+		Name:     "Disk full",
+		Severity: 4,
+		Hosts:    []zbxpkg.Host{{Host: "web-1"}, {Host: "db-1"}},
+		Groups:   []string{"Linux"},
+	}
+	trend := zbxpkg.Trend{Host: &zbxpkg.Host{Host: "db-1"}, Type: zbxpkg.UNSIGNED, Avg: 12}
+
+	tests := []struct {
+		expression string
+		accepted   []any
+		rejected   []any
+	}{
+		{
+			expression: `type == FLOAT && host like "db-*" && !(name like "*temperature*")`,
+			accepted:   []any{dbFloat},
+			rejected:   []any{dbTemperature, webText, problem, trend},
+		},
+		{
+			expression: `export == "events" && severity >= 4 && "Linux" in groups`,
+			accepted:   []any{problem},
+			rejected:   []any{dbFloat, trend},
+		},
+		{
+			expression: `value > 0.5 && value <= 12`,
+			accepted:   []any{dbFloat, trend, problem},
+			rejected:   []any{webText},
+		},
+		{
+			expression: `value == "ok" || hosts like "db-?"`,
+			accepted:   []any{webText, problem, dbFloat, trend},
+		},
+		{
+			expression: `tags.env == "prod:eu" && "env" in tags && has_tag("env:prod*")`,
+			accepted:   []any{dbFloat},
+			rejected:   []any{webText, problem},
+		},
+		{
+			expression: `tags["team"] == null && type in [FLOAT, UNSIGNED] && groups =~ "^Data"`,
+			accepted:   []any{dbFloat},
+			rejected:   []any{trend, webText},
+		},
+		{
+			expression: `clock >= 1700000000 && itemid != -1 && eventid == null`,
+			accepted:   []any{dbFloat},
+			rejected:   []any{problem},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			f, err := NewExpressionFilter(tt.expression)
+			require.NoError(t, err)
+			for _, v := range tt.accepted {
+				require.True(t, accept(f, v), "%+v", v)
+			}
+			for _, v := range tt.rejected {
+				require.False(t, accept(f, v), "%+v", v)
+			}
+		})
+	}
+}
+
+func accept(f Filter, v any) bool {
+	switch v := v.(type) {
+	case zbxpkg.History:
+		return f.AcceptHistory(v) && len(f.FilterHistory([]zbxpkg.History{v})) == 1
+	case zbxpkg.Trend:
+		return f.AcceptTrend(v) && len(f.FilterTrends([]zbxpkg.Trend{v})) == 1
+	case zbxpkg.Event:
+		return f.AcceptEvent(v) && len(f.FilterEvents([]zbxpkg.Event{v})) == 1
+	}
+	return false
+}
+
+func TestExpressionFilter_Invalid(t *testing.T) {
+	tests := map[string]string{
+		``:                      "unexpected end of expression",
+		`host == `:              "unexpected end of expression",
+		`hostname == "a"`:       "unknown field 'hostname'",
+		`host == "a" &&`:        "unexpected end of expression",
+		`(host == "a"`:          "expected ')'",
+		`host == "a`:            "unterminated string",
+		`host =~ "("`:           "regex",
+		`host like name`:        "needs a string literal",
+		`groups == "Linux"`:     "cannot compare lists",
+		`host`:                  "not a boolean",
+		`severity && true`:      "needs boolean operands",
+		`host in "a"`:           "needs a list or tags",
+		`lower(host) == "a"`:    "unknown function 'lower'",
+		`has_tag("/env")`:       "has_tag",
+		`host == "a" host`:      "unexpected 'host'",
+		`name.first == "a"`:     "only tags can be indexed",
+		`host # "a"`:            "unexpected '#'",
+		`type in [FLOAT, host]`: "lists may only contain literals",
+		"host like \"\x9a\"":    "invalid UTF-8",
+	}
+	for expression, msg := range tests {
+		_, err := NewExpressionFilter(expression)
+		require.ErrorContains(t, err, msg, expression)
+	}
+}
+
+func TestNewFilter(t *testing.T) {
+	f, err := NewFilter(FilterConfig{Accepted: []string{"env:prod"}})
+	require.NoError(t, err)
+	require.IsType(t, &TagFilter{}, f)
+
+	f, err = NewFilter(FilterConfig{Type: EXPRESSION_FILTER_TYPE, Expression: `severity >= 4`})
+	require.NoError(t, err)
+	require.IsType(t, &ExpressionFilter{}, f)
+
+	_, err = NewFilter(FilterConfig{Type: EXPRESSION_FILTER_TYPE})
+	require.Error(t, err)
+	_, err = NewFilter(FilterConfig{Type: "lua"})
+	require.ErrorContains(t, err, "unknown filter type")
+}
@@ -1,20 +1,101 @@
 package filter
 
 import (
-	"golang.org/x/exp/slices"
+	"fmt"
+
 	zbxpkg "zms.szuro.net/pkg/zbx"
 )
 
 const (
-	TAG_FILTER    = 0
-	GROUP_FILTER  = 1
-	CUSTOM_FILTER = 69
+	TAG_FILTER        = 0
+	GROUP_FILTER      = 1
+	EXPRESSION_FILTER = 2
+	CHAIN_FILTER      = 3
+	CUSTOM_FILTER     = 69
+)
+
+// Filter types selected by FilterConfig.Type. Tags are filtered if no type is set.
+const (
+	TAG_FILTER_TYPE        = "tag"
+	GROUP_FILTER_TYPE      = "group"
+	EXPRESSION_FILTER_TYPE = "expression"
 )
 
+// Modes of combining filters of a chain.
+const (
+	AND_MODE = "and"
+	OR_MODE  = "or"
+)
+
+// FilterConfig defines a filter of Type, or a chain of Filters combined by Mode.
 type FilterConfig struct {
-	Type     string
-	Accepted []string
-	Rejected []string
+	Type       string
+	Accepted   []string
+	Rejected   []string
+	Expression string
+	Filters    []FilterConfig
+	Mode       string
+}
+
+// IsChain reports whether the configuration combines other filters.
+func (c FilterConfig) IsChain() bool {
+	return len(c.Filters) > 0
+}
+
+// NewFilter creates the filter defined by the configuration. Chains are built recursively.
+func NewFilter(c FilterConfig) (Filter, error) {
+	if c.IsChain() {
+		return newChain(c)
+	}
+	if c.Mode != "" {
+		return nil, fmt.Errorf("mode is only used with filters")
+	}
+	switch c.Type {
+	case "", TAG_FILTER_TYPE:
+		f, err := ParseTagFilter(c)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case GROUP_FILTER_TYPE:
+		return NewGroupFilter(c), nil
+	case EXPRESSION_FILTER_TYPE:
+		f, err := NewExpressionFilter(c.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("unknown filter type %q", c.Type)
+}
+
+func newChain(c FilterConfig) (Filter, error) {
+	if c.Type != "" || len(c.Accepted) > 0 || len(c.Rejected) > 0 || c.Expression != "" {
+		return nil, fmt.Errorf("filters cannot be combined with type, accepted, rejected or expression")
+	}
+	var or bool
+	switch c.Mode {
+	case "", AND_MODE:
+	case OR_MODE:
+		or = true
+	default:
+		return nil, fmt.Errorf("unknown filter mode %q", c.Mode)
+	}
+	filters := make([]Filter, 0, len(c.Filters))
+	for i, fc := range c.Filters {
+		f, err := NewFilter(fc)
+		if err != nil {
+			return nil, fmt.Errorf("filters[%d]: %w", i, err)
+		}
+		filters = append(filters, f)
+	}
+	return NewChainFilter(filters, or), nil
+}
+
+// Validate reports the first entry or expression that cannot be compiled.
+func (c FilterConfig) Validate() error {
+	_, err := NewFilter(c)
+	return err
 }
 
 type Filter interface {
@@ -26,19 +107,31 @@ type Filter interface {
 	FilterEvents(e []zbxpkg.Event) []zbxpkg.Event
 }
 
+// DefaultFilter filters tags the same way as TagFilter. Entries may use patterns,
+// see TagMatcher, entries without patterns are kept as exact tags too.
 type DefaultFilter struct {
 	AcceptedTags []zbxpkg.Tag `yaml:"accepted"`
 	RejectedTags []zbxpkg.Tag `yaml:"rejected"`
+	Accepted     []TagMatcher `yaml:"-"`
+	Rejected     []TagMatcher `yaml:"-"`
 	active       bool
 }
 
+// NewDefaultFilter creates a filter from accepted and rejected entries and panics on invalid ones.
 func NewDefaultFilter(rawFilter map[string]any) *DefaultFilter {
-	var f DefaultFilter
+	var (
+		f   DefaultFilter
+		err error
+	)
 
-	f.RejectedTags = parseTags(rawFilter["rejected"].([]string))
-	f.AcceptedTags = parseTags(rawFilter["accepted"].([]string))
+	if f.Rejected, f.RejectedTags, err = parseTagMatchers(rawFilter["rejected"].([]string)); err != nil {
+		panic(fmt.Errorf("rejected: %w", err))
+	}
+	if f.Accepted, f.AcceptedTags, err = parseTagMatchers(rawFilter["accepted"].([]string)); err != nil {
+		panic(fmt.Errorf("accepted: %w", err))
+	}
 
-	if len(f.AcceptedTags) != 0 || len(f.RejectedTags) != 0 {
+	if len(f.Accepted) != 0 || len(f.Rejected) != 0 {
 		f.active = true
 	}
 	return &f
@@ -82,29 +175,10 @@ func (f *DefaultFilter) FilterEvents(e []zbxpkg.Event) []zbxpkg.Event {
 	return accepted
 }
 
-// Check if value should be accepted or not
-// No tags specified -> everything is acceted
-// only AcceptedTags ar eprovided -> only matching tags are allowed
-// only RejectedTags are specified -> everything is allowed expect for matching tags
-// both AcceptedTags and RejectedTags are provided -> only accepted tags that were not rejected later are accepted
-func (f *DefaultFilter) tagFilter(tags []zbxpkg.Tag) (accepted bool) {
+// tagFilter accepts tags the same way as TagFilter, see acceptTags.
+func (f *DefaultFilter) tagFilter(tags []zbxpkg.Tag) bool {
 	if !f.active {
 		return true
 	}
-	for _, tag := range tags {
-		if len(f.AcceptedTags) == 0 {
-			accepted = true
-			break
-		}
-		if slices.Contains(f.AcceptedTags, tag) {
-			accepted = true
-		}
-	}
-
-	for _, tag := range tags {
-		if slices.Contains(f.RejectedTags, tag) {
-			accepted = false
-		}
-	}
-	return
+	return acceptTags(tags, f.Accepted, f.AcceptedTags, f.Rejected, f.RejectedTags)
 }
@@ -1,40 +1,67 @@
 package filter
 
 import (
-	"strings"
+	"fmt"
 
 	"golang.org/x/exp/slices"
 	zbxpkg "zms.szuro.net/pkg/zbx"
 )
 
+// TagFilter accepts values by their tags. See TagMatcher for the format of entries.
+// AcceptedTags and RejectedTags hold entries without patterns as exact tags, they are
+// matched along with Accepted and Rejected, so filters built from tags keep working.
 type TagFilter struct {
 	AcceptedTags []zbxpkg.Tag `yaml:"accepted"`
 	RejectedTags []zbxpkg.Tag `yaml:"rejected"`
+	Accepted     []TagMatcher `yaml:"-"`
+	Rejected     []TagMatcher `yaml:"-"`
 	active       bool
 }
 
+// NewTagFilter creates a tag filter and panics on invalid entries.
+// Entries are validated when the configuration is loaded, see ParseTagFilter.
 func NewTagFilter(rawFilter FilterConfig) *TagFilter {
-	var f TagFilter
+	f, err := ParseTagFilter(rawFilter)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
 
-	f.RejectedTags = parseTags(rawFilter.Rejected)
-	f.AcceptedTags = parseTags(rawFilter.Accepted)
+// ParseTagFilter creates a tag filter with precompiled entries.
+func ParseTagFilter(rawFilter FilterConfig) (*TagFilter, error) {
+	var (
+		f   TagFilter
+		err error
+	)
+	if f.Accepted, f.AcceptedTags, err = parseTagMatchers(rawFilter.Accepted); err != nil {
+		return nil, fmt.Errorf("accepted: %w", err)
+	}
+	if f.Rejected, f.RejectedTags, err = parseTagMatchers(rawFilter.Rejected); err != nil {
+		return nil, fmt.Errorf("rejected: %w", err)
+	}
 
-	if len(f.AcceptedTags) != 0 || len(f.RejectedTags) != 0 {
+	if len(f.Accepted) != 0 || len(f.Rejected) != 0 {
 		f.active = true
 	}
-	return &f
+	return &f, nil
 }
 
-func parseTags(rawTags []string) []zbxpkg.Tag {
+// parseTagMatchers compiles entries and returns the ones without patterns as exact tags too.
+func parseTagMatchers(entries []string) ([]TagMatcher, []zbxpkg.Tag, error) {
+	matchers := make([]TagMatcher, 0, len(entries))
 	var tags []zbxpkg.Tag
-	for _, tag := range rawTags {
-		splitTag := strings.Split(tag, ":")
-		tags = append(tags, zbxpkg.Tag{
-			Tag:   splitTag[0],
-			Value: splitTag[1],
-		})
+	for _, entry := range entries {
+		m, err := ParseTagMatcher(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		matchers = append(matchers, m)
+		if tag, ok := m.exactTag(); ok {
+			tags = append(tags, tag)
+		}
 	}
-	return tags
+	return matchers, tags, nil
 }
 
 func (f *TagFilter) AcceptHistory(h zbxpkg.History) bool {
@@ -75,29 +102,42 @@ func (f *TagFilter) FilterEvents(e []zbxpkg.Event) []zbxpkg.Event {
 	return accepted
 }
 
-// Check if value should be accepted or not
-// No tags specified -> everything is acceted
-// only AcceptedTags ar eprovided -> only matching tags are allowed
-// only RejectedTags are specified -> everything is allowed expect for matching tags
-// both AcceptedTags and RejectedTags are provided -> only accepted tags that were not rejected later are accepted
-func (f *TagFilter) tagFilter(tags []zbxpkg.Tag) (accepted bool) {
+func (f *TagFilter) tagFilter(tags []zbxpkg.Tag) bool {
 	if !f.active {
 		return true
 	}
+	return acceptTags(tags, f.Accepted, f.AcceptedTags, f.Rejected, f.RejectedTags)
+}
+
+// Check if value should be accepted or not
+// No tags specified -> everything is acceted
+// only accepted are provided -> only matching tags are allowed
+// only rejected are specified -> everything is allowed expect for matching tags
+// both accepted and rejected are provided -> only accepted tags that were not rejected later are accepted
+func acceptTags(tags []zbxpkg.Tag, accepted []TagMatcher, acceptedTags []zbxpkg.Tag, rejected []TagMatcher, rejectedTags []zbxpkg.Tag) (ok bool) {
 	for _, tag := range tags {
-		if len(f.AcceptedTags) == 0 {
-			accepted = true
+		if len(accepted) == 0 && len(acceptedTags) == 0 {
+			ok = true
 			break
 		}
-		if slices.Contains(f.AcceptedTags, tag) {
-			accepted = true
+		if matchAny(accepted, tag) || slices.Contains(acceptedTags, tag) {
+			ok = true
 		}
 	}
 
 	for _, tag := range tags {
-		if slices.Contains(f.RejectedTags, tag) {
-			accepted = false
+		if matchAny(rejected, tag) || slices.Contains(rejectedTags, tag) {
+			ok = false
 		}
 	}
 	return
 }
+
+func matchAny(matchers []TagMatcher, tag zbxpkg.Tag) bool {
+	for _, m := range matchers {
+		if m.Match(tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+// TagMatcher matches tags against a filter entry. Entries have the form
+// "name:value" and are split on the first unescaped colon, so values may contain colons.
+// Name and value each are either:
+//   - exact, e.g. "env:prod"
+//   - a glob with * (any characters) and ? (one character), e.g. "env:prod*"
+//   - an anchored regex between slashes, e.g. "env:/prod-[0-9]+/"
+//
+// An entry without a value, e.g. "env", matches the tag with any value.
+// A backslash escapes the next character, e.g. "url:http\://*" or "note:\*".
+type TagMatcher struct {
+	raw   string
+	name  matcher
+	value matcher
+}
+
+// ParseTagMatcher compiles a filter entry.
+func ParseTagMatcher(entry string) (TagMatcher, error) {
+	m := TagMatcher{raw: entry}
+	if entry == "" {
+		return m, fmt.Errorf("empty tag filter entry")
+	}
+
+	name, rest, hasValue, err := splitName(entry)
+	if err != nil {
+		return m, fmt.Errorf("tag filter entry %q: %w", entry, err)
+	}
+	if m.name, err = compileMatcher(name); err != nil {
+		return m, fmt.Errorf("tag filter entry %q: name: %w", entry, err)
+	}
+	if !hasValue {
+		m.value = matcher{any: true}
+		return m, nil
+	}
+	if m.value, err = compileMatcher(rest); err != nil {
+		return m, fmt.Errorf("tag filter entry %q: value: %w", entry, err)
+	}
+	return m, nil
+}
+
+// Match reports whether the tag matches the entry.
+func (m TagMatcher) Match(tag zbxpkg.Tag) bool {
+	return m.name.match(tag.Tag) && m.value.match(tag.Value)
+}
+
+func (m TagMatcher) String() string {
+	return m.raw
+}
+
+// exactTag returns the tag matched by an entry without patterns.
+func (m TagMatcher) exactTag() (zbxpkg.Tag, bool) {
+	if m.name.re != nil || m.name.any || m.value.re != nil || m.value.any {
+		return zbxpkg.Tag{}, false
+	}
+	return zbxpkg.Tag{Tag: m.name.exact, Value: m.value.exact}, true
+}
+
+// splitName splits the entry into the name and the value. A name given as
+// a regex ends with its closing slash, otherwise with the first unescaped colon.
+func splitName(entry string) (name, value string, hasValue bool, err error) {
+	i := 0
+	if entry[0] == '/' {
+		end := closingSlash(entry)
+		if end < 0 {
+			return "", "", false, fmt.Errorf("regex is not closed with /")
+		}
+		i = end + 1
+		if i < len(entry) && entry[i] != ':' {
+			return "", "", false, fmt.Errorf("unexpected %q after regex of the name", entry[i:])
+		}
+	} else {
+		for ; i < len(entry) && entry[i] != ':'; i++ {
+			if entry[i] == '\\' {
+				i++
+			}
+		}
+	}
+	if i >= len(entry) {
+		return entry, "", false, nil
+	}
+	return entry[:i], entry[i+1:], true, nil
+}
+
+// closingSlash returns the index of the first unescaped slash after the opening one.
+func closingSlash(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '/':
+			return i
+		}
+	}
+	return -1
+}
+
+// matcher matches a tag name or value. Exact strings are compared,
+// globs and regexes are compiled to re.
+type matcher struct {
+	exact string
+	re    *regexp.Regexp
+	any   bool
+}
+
+func (m matcher) match(s string) bool {
+	switch {
+	case m.any:
+		return true
+	case m.re != nil:
+		return m.re.MatchString(s)
+	default:
+		return m.exact == s
+	}
+}
+
+func compileMatcher(pattern string) (matcher, error) {
+	if len(pattern) >= 2 && pattern[0] == '/' && closingSlash(pattern) == len(pattern)-1 {
+		expr := strings.ReplaceAll(pattern[1:len(pattern)-1], `\/`, "/")
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return matcher{}, err
+		}
+		return matcher{re: re}, nil
+	}
+	if pattern == "*" {
+		return matcher{any: true}, nil
+	}
+
+	var (
+		exact strings.Builder
+		expr  strings.Builder
+		glob  bool
+	)
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '\\':
+			if i+1 == len(pattern) {
+				return matcher{}, fmt.Errorf("trailing backslash")
+			}
+			i++
+			exact.WriteByte(pattern[i])
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '*':
+			glob = true
+			expr.WriteString(".*")
+		case '?':
+			glob = true
+			expr.WriteString(".")
+		default:
+			exact.WriteByte(c)
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	if !glob {
+		return matcher{exact: exact.String()}, nil
+	}
+	// quoted bytes of invalid UTF-8 do not compile
+	re, err := regexp.Compile("^(?s:" + expr.String() + ")$")
+	if err != nil {
+		return matcher{}, err
+	}
+	return matcher{re: re}, nil
+}
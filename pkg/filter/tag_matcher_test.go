@@ -0,0 +1,126 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	zbxpkg "zms.szuro.net/pkg/zbx"
+)
+
+func TestTagMatcher(t *testing.T) {
+	tests := []struct {
+		entry   string
+		match   []zbxpkg.Tag
+		noMatch []zbxpkg.Tag
+	}{
+		{
+			entry:   "env:prod",
+			match:   []zbxpkg.Tag{{Tag: "env", Value: "prod"}},
+			noMatch: []zbxpkg.Tag{{Tag: "env", Value: "prod-1"}, {Tag: "environment", Value: "prod"}},
+		},
+		{
+			entry:   "url:http://example.com:8080",
+			match:   []zbxpkg.Tag{{Tag: "url", Value: "http://example.com:8080"}},
+			noMatch: []zbxpkg.Tag{{Tag: "url", Value: "http"}},
+		},
+		{
+			entry:   "env",
+			match:   []zbxpkg.Tag{{Tag: "env", Value: "prod"}, {Tag: "env", Value: ""}},
+			noMatch: []zbxpkg.Tag{{Tag: "envs", Value: "prod"}},
+		},
+		{
+			entry:   "env:*",
+			match:   []zbxpkg.Tag{{Tag: "env", Value: "prod"}, {Tag: "env", Value: ""}},
+			noMatch: []zbxpkg.Tag{{Tag: "team", Value: "prod"}},
+		},
+		{
+			entry:   "env:prod*",
+			match:   []zbxpkg.Tag{{Tag: "env", Value: "prod"}, {Tag: "env", Value: "production"}},
+			noMatch: []zbxpkg.Tag{{Tag: "env", Value: "preprod"}},
+		},
+		{
+			entry:   "app-?:web.*",
+			match:   []zbxpkg.Tag{{Tag: "app-1", Value: "web.eu"}},
+			noMatch: []zbxpkg.Tag{{Tag: "app-10", Value: "web.eu"}, {Tag: "app-1", Value: "webeu"}},
+		},
+		{
+			entry:   `note:\*`,
+			match:   []zbxpkg.Tag{{Tag: "note", Value: "*"}},
+			noMatch: []zbxpkg.Tag{{Tag: "note", Value: "anything"}},
+		},
+		{
+			entry:   `a\:b:c`,
+			match:   []zbxpkg.Tag{{Tag: "a:b", Value: "c"}},
+			noMatch: []zbxpkg.Tag{{Tag: "a", Value: "b:c"}},
+		},
+		{
+			entry:   "env:/prod-[0-9]+/",
+			match:   []zbxpkg.Tag{{Tag: "env", Value: "prod-12"}},
+			noMatch: []zbxpkg.Tag{{Tag: "env", Value: "prod-12a"}, {Tag: "env", Value: "xprod-1"}},
+		},
+		{
+			entry:   "/(env|stage)/:/a:b|c/",
+			match:   []zbxpkg.Tag{{Tag: "env", Value: "a:b"}, {Tag: "stage", Value: "c"}},
+			noMatch: []zbxpkg.Tag{{Tag: "environment", Value: "c"}},
+		},
+		{
+			entry: `path:/\/var\/.*/`,
+			match: []zbxpkg.Tag{{Tag: "path", Value: "/var/log"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			m, err := ParseTagMatcher(tt.entry)
+			require.NoError(t, err)
+			for _, tag := range tt.match {
+				require.True(t, m.Match(tag), "%v", tag)
+			}
+			for _, tag := range tt.noMatch {
+				require.False(t, m.Match(tag), "%v", tag)
+			}
+		})
+	}
+}
+
+func TestParseTagMatcher_Invalid(t *testing.T) {
+	for _, entry := range []string{"", "/env", "/env/x:prod", "env:/prod(/", `env:prod\`, "env:\x9a*"} {
+		_, err := ParseTagMatcher(entry)
+		require.Error(t, err, entry)
+	}
+}
+
+func TestParseTagFilter(t *testing.T) {
+	f, err := ParseTagFilter(FilterConfig{Accepted: []string{"env:prod*"}, Rejected: []string{"debug"}})
+	require.NoError(t, err)
+	require.True(t, f.AcceptHistory(zbxpkg.History{Tags: []zbxpkg.Tag{{Tag: "env", Value: "prod-eu"}}}))
+	require.False(t, f.AcceptHistory(zbxpkg.History{Tags: []zbxpkg.Tag{{Tag: "env", Value: "prod-eu"}, {Tag: "debug", Value: "1"}}}))
+	require.False(t, f.AcceptHistory(zbxpkg.History{Tags: []zbxpkg.Tag{{Tag: "env", Value: "dev"}}}))
+
+	_, err = ParseTagFilter(FilterConfig{Rejected: []string{"env:/[/"}})
+	require.ErrorContains(t, err, "rejected")
+	require.Panics(t, func() { NewTagFilter(FilterConfig{Accepted: []string{"/env"}}) })
+}
+
+func TestParseTagFilter_ExactTags(t *testing.T) {
+	// entries without patterns are exposed as tags like before patterns were supported
+	f, err := ParseTagFilter(FilterConfig{Accepted: []string{"env:prod", "team:*"}, Rejected: []string{"role:test"}})
+	require.NoError(t, err)
+	require.Equal(t, []zbxpkg.Tag{{Tag: "env", Value: "prod"}}, f.AcceptedTags)
+	require.Equal(t, []zbxpkg.Tag{{Tag: "role", Value: "test"}}, f.RejectedTags)
+	require.Len(t, f.Accepted, 2)
+
+	// tags added to a parsed filter are matched along with entries
+	f.AcceptedTags = append(f.AcceptedTags, zbxpkg.Tag{Tag: "env", Value: "dev"})
+	require.True(t, f.AcceptHistory(zbxpkg.History{Tags: []zbxpkg.Tag{{Tag: "env", Value: "dev"}}}))
+	require.True(t, f.AcceptHistory(zbxpkg.History{Tags: []zbxpkg.Tag{{Tag: "team", Value: "db"}}}))
+	require.False(t, f.AcceptHistory(zbxpkg.History{Tags: []zbxpkg.Tag{{Tag: "env", Value: "qa"}}}))
+}
+
+func TestNewDefaultFilter_Patterns(t *testing.T) {
+	f := NewDefaultFilter(map[string]any{"accepted": []string{"env:prod*"}, "rejected": []string{"debug"}})
+	require.True(t, f.AcceptHistory(zbxpkg.History{Tags: []zbxpkg.Tag{{Tag: "env", Value: "prod-eu"}}}))
+	require.False(t, f.AcceptHistory(zbxpkg.History{Tags: []zbxpkg.Tag{{Tag: "env", Value: "prod-eu"}, {Tag: "debug", Value: "1"}}}))
+	require.False(t, f.AcceptHistory(zbxpkg.History{Tags: []zbxpkg.Tag{{Tag: "env", Value: "dev"}}}))
+
+	require.Panics(t, func() { NewDefaultFilter(map[string]any{"accepted": []string{"env:/[/"}, "rejected": []string{}}) })
+}
@@ -2,10 +2,12 @@ package plugin
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
+	"io"
 	"log/slog"
-	"path"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v4"
@@ -19,6 +21,7 @@ import (
 //
 // The buffer uses BadgerDB for local persistence and supports TTL-based expiration
 // to prevent unbounded storage growth. Data is serialized using gob encoding.
+// Records are fetched in the order they were buffered.
 type ZMSBuffer interface {
 	// History buffer operations
 
@@ -75,8 +78,17 @@ type ZMSBuffer interface {
 
 // ZMSDefaultBuffer is the default implementation of ZMSBuffer using BadgerDB.
 // It provides persistent, transactional storage with configurable TTL for automatic cleanup.
-// Data is stored using gob encoding and indexed by hash keys generated from export records.
+// Data is stored using gob encoding. Keys consist of the export name, an insertion
+// sequence number and the record hash, so records are kept in FIFO order per export type.
+// Records stored by earlier versions under the record hash alone are moved to this layout
+// when the buffer is opened.
+//
+// InitBuffer has a pointer receiver, as it stores the opened database in the buffer.
 type ZMSDefaultBuffer struct {
+	// OnExpire is called with the export name and number of records
+	// that were removed from the buffer because their TTL has passed.
+	OnExpire func(exportName string, count int)
+
 	// offlineBufferTTL defines how long records are kept in the buffer before expiration
 	offlineBufferTTL time.Duration
 
@@ -85,144 +97,371 @@ type ZMSDefaultBuffer struct {
 
 	// buffer is the BadgerDB instance used for persistent storage
 	buffer *badger.DB
+
+	// sequence provides monotonic insertion numbers that survive restarts
+	sequence *badger.Sequence
+}
+
+var sequenceKey = []byte("!sequence")
+
+func init() {
+	// History.Value holds json.Number for numeric items
+	gob.Register(json.Number(""))
 }
 
 // InitBuffer initializes the BadgerDB buffer with the specified path and TTL.
 // If TTL is 0, buffering is disabled. Otherwise, a BadgerDB instance is created
-// at the specified path with automatic TTL-based cleanup.
-func (b ZMSDefaultBuffer) InitBuffer(bufferPath string, ttl int64) {
+// at the specified path and records older than TTL are dropped when fetched.
+func (b *ZMSDefaultBuffer) InitBuffer(bufferPath string, ttl int64) {
+	b.bufferPath = bufferPath
 	b.offlineBufferTTL = time.Duration(ttl) * time.Hour
 	if b.offlineBufferTTL > 0 {
-		db, err := badger.Open(badger.DefaultOptions(
-			path.Join(b.bufferPath),
-		).WithLogger(logger.Default()))
-		logger.Debug("Initialized BadgerDB for offline buffering", slog.String("path", path.Join(b.bufferPath)))
+		db, err := badger.Open(badger.DefaultOptions(b.bufferPath).WithLogger(logger.Default()))
+		logger.Debug("Initialized BadgerDB for offline buffering", slog.String("path", b.bufferPath))
 		if err != nil {
 			logger.Error("Failed to open BadgerDB for offline buffering", slog.Any("error", err))
+			return
+		}
+		seq, err := db.GetSequence(sequenceKey, 1000)
+		if err != nil {
+			logger.Error("Failed to get offline buffer sequence", slog.Any("error", err))
+			db.Close()
+			return
 		}
 		b.buffer = db
+		b.sequence = seq
+		if migrated, err := b.migrateLegacy(); err != nil {
+			logger.Error("Failed to migrate offline buffer records of earlier versions", slog.String("path", b.bufferPath), slog.Any("error", err))
+		} else if migrated > 0 {
+			logger.Info("Migrated offline buffer records of earlier versions", slog.String("path", b.bufferPath), slog.Int("records", migrated))
+		}
 	}
 }
 
-// Cleanup releases resources held by the baseObserver.
+// Enabled returns true if the buffer was initialized successfully.
+func (b ZMSDefaultBuffer) Enabled() bool {
+	return b.buffer != nil
+}
+
+// Cleanup releases resources held by the buffer.
 // If offlineBufferTTL is greater than zero, it closes the buffer to free associated resources.
 func (b ZMSDefaultBuffer) Cleanup() {
+	if b.sequence != nil {
+		b.sequence.Release()
+	}
 	if b.buffer != nil {
 		b.buffer.Close()
 	}
 }
 
+// Count returns the number of records of the given export type stored in the buffer.
+func (b ZMSDefaultBuffer) Count(exportName string) (count int, err error) {
+	if b.buffer == nil {
+		return 0, errors.New("cannot count nil buffer")
+	}
+	prefix := bufferPrefix(exportName)
+	err = b.buffer.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			count++
+		}
+		return nil
+	})
+	return
+}
+
 func (b ZMSDefaultBuffer) BufferHistory(history []zbx.History) (err error) {
-	return saveToBuffer[zbx.History](b.buffer, history, b.offlineBufferTTL)
+	return saveToBuffer(b, history)
 }
 func (b ZMSDefaultBuffer) FetchHistory(number int) (history []zbx.History, err error) {
-	return fetchfromBuffer[zbx.History](b.buffer, number)
+	return fetchfromBuffer[zbx.History](b, number)
 }
 func (b ZMSDefaultBuffer) DeleteHistory(history []zbx.History) (err error) {
-	return deleteFromBuffer[zbx.History](b.buffer, history)
+	return deleteFromBuffer(b, history)
 }
 func (b ZMSDefaultBuffer) BufferTrends(trends []zbx.Trend) (err error) {
-	return saveToBuffer(b.buffer, trends, b.offlineBufferTTL)
+	return saveToBuffer(b, trends)
 }
 func (b ZMSDefaultBuffer) FetchTrends(number int) (trends []zbx.Trend, err error) {
-	return fetchfromBuffer[zbx.Trend](b.buffer, number)
+	return fetchfromBuffer[zbx.Trend](b, number)
 }
 func (b ZMSDefaultBuffer) DeleteTrends(trends []zbx.Trend) (err error) {
-	return deleteFromBuffer[zbx.Trend](b.buffer, trends)
+	return deleteFromBuffer(b, trends)
 }
 func (b ZMSDefaultBuffer) BufferEvents(events []zbx.Event) (err error) {
-	return saveToBuffer(b.buffer, events, b.offlineBufferTTL)
+	return saveToBuffer(b, events)
 }
 func (b ZMSDefaultBuffer) FetchEvents(number int) (events []zbx.Event, err error) {
-	return fetchfromBuffer[zbx.Event](b.buffer, number)
+	return fetchfromBuffer[zbx.Event](b, number)
 }
 func (b ZMSDefaultBuffer) DeleteEvents(events []zbx.Event) (err error) {
-	return deleteFromBuffer[zbx.Event](b.buffer, events)
+	return deleteFromBuffer(b, events)
+}
+
+func bufferPrefix(exportName string) []byte {
+	return []byte(exportName + "/")
+}
+
+// bufferKey builds a key ordered by insertion sequence within the export type.
+func bufferKey(exportName string, seq uint64, hash []byte) []byte {
+	key := bufferPrefix(exportName)
+	key = binary.BigEndian.AppendUint64(key, seq)
+	return append(key, hash...)
+}
+
+// keyHash extracts the record hash from a buffer key.
+func keyHash(key []byte, prefixLen int) []byte {
+	return key[prefixLen+8:]
 }
 
-func saveToBuffer[T zbx.Export](buffer *badger.DB, toBuffer []T, offlineBufferTTL time.Duration) (err error) {
-	if buffer == nil {
+func saveToBuffer[T zbx.Export](b ZMSDefaultBuffer, toBuffer []T) (err error) {
+	if b.buffer == nil {
 		return errors.New("cannot write to nil buffer")
 	}
-	var value bytes.Buffer
-	enc := gob.NewEncoder(&value)
-	txn := buffer.NewTransaction(true)
+	expiresAt := time.Now().Add(b.offlineBufferTTL).UnixNano()
+
+	wb := b.buffer.NewWriteBatch()
+	defer wb.Cancel()
 	for _, item := range toBuffer {
-		key := item.Hash()
-		enc.Encode(item)
-		e := badger.NewEntry(key, value.Bytes()).WithTTL(offlineBufferTTL)
-		if err := txn.SetEntry(e); err == badger.ErrTxnTooBig {
-			err = txn.Commit()
+		seq, err := b.sequence.Next()
+		if err != nil {
+			return err
+		}
+		value, err := encodeRecord(item, expiresAt)
+		if err != nil {
+			return err
+		}
+		if err := wb.Set(bufferKey(item.GetExportName(), seq, item.Hash()), value); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// encodeRecord prefixes the gob encoded record with the time it expires at, in nanoseconds.
+func encodeRecord[T zbx.Export](item T, expiresAt int64) ([]byte, error) {
+	var value bytes.Buffer
+	value.Write(binary.BigEndian.AppendUint64(nil, uint64(expiresAt)))
+	if err := gob.NewEncoder(&value).Encode(item); err != nil {
+		return nil, err
+	}
+	return value.Bytes(), nil
+}
+
+func fetchfromBuffer[T zbx.Export](b ZMSDefaultBuffer, batchSize int) (buffered []T, err error) {
+	if b.buffer == nil {
+		return buffered, errors.New("cannot read from nil buffer")
+	}
+	var zero T
+	exportName := zero.GetExportName()
+	now := time.Now().UnixNano()
+	var expired [][]byte
+
+	err = b.buffer.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchSize = batchSize
+		opts.Prefix = bufferPrefix(exportName)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid() && len(buffered) < batchSize; it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
 			if err != nil {
-				logger.Error("commit error")
+				logger.Error("Failed to copy value from buffer", slog.String("observer", b.bufferPath), slog.Any("error", err))
+				continue
+			}
+			if len(val) < 8 || int64(binary.BigEndian.Uint64(val[:8])) < now {
+				expired = append(expired, item.KeyCopy(nil))
+				continue
 			}
-			txn := buffer.NewTransaction(true)
-			txn.SetEntry(e)
+			var decoded T
+			if err := gob.NewDecoder(bytes.NewReader(val[8:])).Decode(&decoded); err != nil {
+				logger.Error("Failed to decode from buffer", slog.String("observer", b.bufferPath), slog.Any("error", err))
+				expired = append(expired, item.KeyCopy(nil))
+				continue
+			}
+			buffered = append(buffered, decoded)
+		}
+		return nil
+	})
+
+	if len(expired) > 0 {
+		if err := deleteKeys(b.buffer, expired); err != nil {
+			logger.Error("Failed to remove expired records from buffer", slog.String("observer", b.bufferPath), slog.Any("error", err))
+		} else if b.OnExpire != nil {
+			b.OnExpire(exportName, len(expired))
 		}
 	}
-	err = txn.Commit()
+
 	return
 }
 
-func fetchfromBuffer[T zbx.Export](buffer *badger.DB, batchSize int) (buffered []T, err error) {
-	if buffer == nil {
-		return buffered, errors.New("cannot read from nil buffer")
+// deleteFromBuffer removes the given records. Since records are fetched from the head
+// of the buffer, the scan stops as soon as all of them are found.
+func deleteFromBuffer[T zbx.Export](b ZMSDefaultBuffer, buffered []T) (err error) {
+	if b.buffer == nil {
+		return errors.New("cannot delete from nil buffer")
+	}
+	if len(buffered) == 0 {
+		return nil
 	}
-	opts := badger.DefaultIteratorOptions
-	opts.PrefetchSize = batchSize
-	txn := buffer.NewTransaction(false)
-	defer txn.Discard()
-	it := txn.NewIterator(opts)
-	defer it.Close()
+	exportName := buffered[0].GetExportName()
+	prefix := bufferPrefix(exportName)
 
-	decodeFunc := func(val []byte) (T, error) {
-		var t T
-		dec := gob.NewDecoder(bytes.NewReader(val))
-		err := dec.Decode(&t)
-		return t, err
+	pending := make(map[string]int, len(buffered))
+	for _, item := range buffered {
+		pending[string(item.Hash())]++
 	}
 
-	for it.Rewind(); it.Valid(); it.Next() {
-		item := it.Item()
-		val, err := item.ValueCopy(nil)
-		if err != nil {
-			logger.Error("Failed to copy value from buffer", slog.String("observer", buffer.Opts().Dir), slog.Any("error", err))
-			continue
-		}
-		decoded, err := decodeFunc(val)
-		if err != nil {
-			logger.Error("Failed to decode from buffer", slog.String("observer", buffer.Opts().Dir), slog.Any("error", err))
-			continue
-		}
-		var zero T
-		if decoded.GetExportName() == zero.GetExportName() {
-			buffered = append(buffered, decoded)
-			if len(buffered) >= batchSize {
-				break
+	var keys [][]byte
+	err = b.buffer.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid() && len(pending) > 0; it.Next() {
+			key := it.Item().KeyCopy(nil)
+			hash := string(keyHash(key, len(prefix)))
+			if n, ok := pending[hash]; ok {
+				keys = append(keys, key)
+				if n <= 1 {
+					delete(pending, hash)
+				} else {
+					pending[hash] = n - 1
+				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return
 	}
 
+	err = deleteKeys(b.buffer, keys)
+	if err != nil {
+		logger.Error("Failed to delete from buffer", slog.String("observer", b.bufferPath), slog.Any("error", err))
+	}
 	return
 }
 
-func deleteFromBuffer[T zbx.Export](buffer *badger.DB, buffered []T) (err error) {
-	if buffer == nil {
-		return errors.New("cannot delete from nil buffer")
+func deleteKeys(db *badger.DB, keys [][]byte) error {
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return err
+		}
 	}
-	txn := buffer.NewTransaction(true)
-	defer txn.Discard()
-	for _, item := range buffered {
-		key := item.Hash()
-		err = txn.Delete(key)
+	return wb.Flush()
+}
+
+// legacyRecord is a record stored by earlier versions, keyed by its hash alone.
+type legacyRecord struct {
+	key       []byte
+	value     []byte
+	expiresAt uint64 // Badger TTL, in seconds
+}
+
+// migrateLegacy moves records stored by earlier versions to the current key layout,
+// keeping the time they expire at. Earlier versions kept no insertion order,
+// so the records are moved in key order. Records that cannot be decoded are removed.
+func (b *ZMSDefaultBuffer) migrateLegacy() (migrated int, err error) {
+	var legacy []legacyRecord
+	err = b.buffer.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if legacyExport(item.Key()) == "" {
+				continue
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			legacy = append(legacy, legacyRecord{key: item.KeyCopy(nil), value: value, expiresAt: item.ExpiresAt()})
+		}
+		return nil
+	})
+	if err != nil || len(legacy) == 0 {
+		return 0, err
+	}
+
+	wb := b.buffer.NewWriteBatch()
+	defer wb.Cancel()
+	for _, r := range legacy {
+		expiresAt := time.Now().Add(b.offlineBufferTTL).UnixNano()
+		if r.expiresAt > 0 {
+			expiresAt = time.Unix(int64(r.expiresAt), 0).UnixNano()
+		}
+		var value []byte
+		var err error
+		switch export := legacyExport(r.key); export {
+		case zbx.HISTORY:
+			value, err = migrateRecord[zbx.History](r, expiresAt)
+		case zbx.TREND:
+			value, err = migrateRecord[zbx.Trend](r, expiresAt)
+		case zbx.EVENT:
+			value, err = migrateRecord[zbx.Event](r, expiresAt)
+		}
 		if err != nil {
-			logger.Error("Failed to delete from buffer", slog.String("observer", buffer.Opts().Dir), slog.Any("error", err))
+			logger.Error("Dropping offline buffer record of earlier version", slog.String("key", string(r.key)), slog.Any("error", err))
+		} else {
+			seq, err := b.sequence.Next()
+			if err != nil {
+				return migrated, err
+			}
+			if err := wb.Set(bufferKey(legacyExport(r.key), seq, r.key), value); err != nil {
+				return migrated, err
+			}
+			migrated++
+		}
+		if err := wb.Delete(r.key); err != nil {
+			return migrated, err
 		}
 	}
-	err = txn.Commit()
-	if err != nil {
-		logger.Error("Failed to commit", slog.String("observer", buffer.Opts().Dir), slog.Any("error", err))
+	return migrated, wb.Flush()
+}
+
+// legacyExport returns the export type of a key of earlier versions, or "" for other keys.
+// Such keys are record hashes, which start with the export type and an underscore.
+func legacyExport(key []byte) string {
+	switch {
+	case bytes.HasPrefix(key, []byte("history_")):
+		return zbx.HISTORY
+	case bytes.HasPrefix(key, []byte("trend_")):
+		return zbx.TREND
+	case bytes.HasPrefix(key, []byte("event_")):
+		return zbx.EVENT
 	}
+	return ""
+}
 
-	return
+// migrateRecord decodes a record of earlier versions and encodes it in the current format.
+// Earlier versions reused the gob stream of a batch, so a value holds all records
+// of the batch stored before it and the record itself is the last one.
+func migrateRecord[T zbx.Export](r legacyRecord, expiresAt int64) ([]byte, error) {
+	dec := gob.NewDecoder(bytes.NewReader(r.value))
+	var last T
+	var found bool
+	for {
+		var item T
+		if err := dec.Decode(&item); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		last, found = item, true
+	}
+	if !found || !bytes.Equal(last.Hash(), r.key) {
+		return nil, errors.New("record does not match its key")
+	}
+	return encodeRecord(last, expiresAt)
 }
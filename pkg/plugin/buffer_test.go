@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/pkg/zbx"
+)
+
+func newTestBuffer(t *testing.T, ttl int64) *ZMSDefaultBuffer {
+	t.Helper()
+	b := &ZMSDefaultBuffer{}
+	b.InitBuffer(t.TempDir(), ttl)
+	require.True(t, b.Enabled())
+	t.Cleanup(b.Cleanup)
+	return b
+}
+
+func TestZMSDefaultBuffer_Disabled(t *testing.T) {
+	b := &ZMSDefaultBuffer{}
+	b.InitBuffer(t.TempDir(), 0)
+	require.False(t, b.Enabled())
+	require.Error(t, b.BufferHistory([]zbx.History{{ItemID: 1}}))
+}
+
+func TestZMSDefaultBuffer_FIFO(t *testing.T) {
+	b := newTestBuffer(t, 1)
+
+	// Item IDs are chosen so that hash order differs from insertion order
+	first := []zbx.History{{ItemID: 30, Clock: 1, Value: json.Number("1.5")}, {ItemID: 2, Clock: 1, Value: "text"}}
+	second := []zbx.History{{ItemID: 100, Clock: 2, Value: json.Number("3")}}
+	require.NoError(t, b.BufferHistory(first))
+	require.NoError(t, b.BufferHistory(second))
+	require.NoError(t, b.BufferEvents([]zbx.Event{{EventID: 1}}))
+
+	count, err := b.Count(zbx.HISTORY)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	fetched, err := b.FetchHistory(2)
+	require.NoError(t, err)
+	require.Equal(t, first, fetched)
+
+	require.NoError(t, b.DeleteHistory(fetched))
+	fetched, err = b.FetchHistory(10)
+	require.NoError(t, err)
+	require.Equal(t, second, fetched)
+
+	events, err := b.FetchEvents(10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+}
+
+func TestZMSDefaultBuffer_Expire(t *testing.T) {
+	b := newTestBuffer(t, 1)
+	b.offlineBufferTTL = -1
+
+	var expired int
+	b.OnExpire = func(exportName string, count int) {
+		require.Equal(t, zbx.TREND, exportName)
+		expired += count
+	}
+
+	require.NoError(t, b.BufferTrends([]zbx.Trend{{ItemID: 1}, {ItemID: 2}}))
+	fetched, err := b.FetchTrends(10)
+	require.NoError(t, err)
+	require.Empty(t, fetched)
+	require.Equal(t, 2, expired)
+
+	count, err := b.Count(zbx.TREND)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+// writeLegacy stores records the way earlier versions did, keyed by hash and sharing a gob stream.
+func writeLegacy[T zbx.Export](t *testing.T, db *badger.DB, records []T) {
+	t.Helper()
+	var value bytes.Buffer
+	enc := gob.NewEncoder(&value)
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		for _, r := range records {
+			require.NoError(t, enc.Encode(r))
+			if err := txn.SetEntry(badger.NewEntry(r.Hash(), bytes.Clone(value.Bytes())).WithTTL(time.Hour)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func TestZMSDefaultBuffer_MigratesLegacyRecords(t *testing.T) {
+	dir := t.TempDir()
+	history := []zbx.History{{ItemID: 1, Clock: 1, Value: json.Number("1")}, {ItemID: 2, Clock: 1, Value: "text"}}
+	events := []zbx.Event{{EventID: 5, Name: "problem"}}
+
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	require.NoError(t, err)
+	writeLegacy(t, db, history)
+	writeLegacy(t, db, events)
+	require.NoError(t, db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("trend_1:0"), []byte("garbage"))
+	}))
+	require.NoError(t, db.Close())
+
+	b := &ZMSDefaultBuffer{}
+	b.InitBuffer(dir, 1)
+	require.True(t, b.Enabled())
+	t.Cleanup(b.Cleanup)
+
+	fetched, err := b.FetchHistory(10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, history, fetched)
+	fetchedEvents, err := b.FetchEvents(10)
+	require.NoError(t, err)
+	require.Equal(t, events, fetchedEvents)
+	count, err := b.Count(zbx.TREND)
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	// migrated records are deleted like any other
+	require.NoError(t, b.DeleteHistory(fetched))
+	count, err = b.Count(zbx.HISTORY)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestZMSDefaultBuffer_ValueMethods(t *testing.T) {
+	b := &ZMSDefaultBuffer{}
+	b.InitBuffer(t.TempDir(), 1)
+	t.Cleanup(b.Cleanup)
+
+	// methods other than InitBuffer work on copies, as they did before
+	copied := *b
+	require.NoError(t, copied.BufferTrends([]zbx.Trend{{ItemID: 1}}))
+	count, err := b.Count(zbx.TREND)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
@@ -56,7 +56,11 @@ func (b *BaseObserverGRPC) Initialize(ctx context.Context, req *proto.Initialize
 	b.enabledExports = req.Exports
 
 	// Initialize filter
-	b.Filter = b.createFilterFromProto(req.Filter)
+	f, err := b.createFilterFromProto(req.Filter)
+	if err != nil {
+		return &proto.InitializeResponse{Success: false, Error: err.Error()}, nil
+	}
+	b.Filter = f
 
 	return &proto.InitializeResponse{Success: true}, nil
 }
@@ -83,12 +87,10 @@ func (b *BaseObserverGRPC) FilterEvents(events []*proto.Event) []zbx.Event {
 }
 
 // createFilterFromProto converts a proto.FilterConfig to a filter.Filter instance.
-func (b *BaseObserverGRPC) createFilterFromProto(filter_ *proto.Filter) filter.Filter {
+// Filters are built by the same factory as on the host.
+func (b *BaseObserverGRPC) createFilterFromProto(filter_ *proto.Filter) (filter.Filter, error) {
 	if filter_ == nil {
-		return &filter.DefaultFilter{}
+		return &filter.DefaultFilter{}, nil
 	}
-
-	filterConfig := filter.FilterConfig{Accepted: filter_.Accepted, Rejected: filter_.Rejected}
-	//TODO: handle filter_.Type if custom filters are implemented in the future
-	return filter.NewTagFilter(filterConfig)
+	return filter.NewFilter(FilterConfigFromProto(filter_))
 }
@@ -3,6 +3,7 @@ package plugin
 import (
 	"encoding/json"
 
+	"zms.szuro.net/pkg/filter"
 	"zms.szuro.net/pkg/proto"
 	"zms.szuro.net/pkg/zbx"
 )
@@ -293,3 +294,53 @@ func StringToExportType(s string) proto.ExportType {
 		return proto.ExportType_HISTORY
 	}
 }
+
+// FilterConfigToProto converts a filter configuration, including filters of chains, to proto.Filter.
+func FilterConfigToProto(c filter.FilterConfig) *proto.Filter {
+	f := &proto.Filter{
+		Accepted:   c.Accepted,
+		Rejected:   c.Rejected,
+		Expression: c.Expression,
+	}
+	switch c.Type {
+	case filter.GROUP_FILTER_TYPE:
+		f.Type = proto.FilterType_GROUP
+	case filter.EXPRESSION_FILTER_TYPE:
+		f.Type = proto.FilterType_EXPRESSION
+	default:
+		f.Type = proto.FilterType_TAG
+	}
+	if c.IsChain() {
+		f.Type = proto.FilterType_CHAIN
+		if c.Mode == filter.OR_MODE {
+			f.Mode = proto.FilterMode_OR
+		}
+		for _, fc := range c.Filters {
+			f.Filters = append(f.Filters, FilterConfigToProto(fc))
+		}
+	}
+	return f
+}
+
+// FilterConfigFromProto converts proto.Filter back to a filter configuration.
+func FilterConfigFromProto(f *proto.Filter) filter.FilterConfig {
+	c := filter.FilterConfig{
+		Accepted:   f.GetAccepted(),
+		Rejected:   f.GetRejected(),
+		Expression: f.GetExpression(),
+	}
+	switch f.GetType() {
+	case proto.FilterType_GROUP:
+		c.Type = filter.GROUP_FILTER_TYPE
+	case proto.FilterType_EXPRESSION:
+		c.Type = filter.EXPRESSION_FILTER_TYPE
+	case proto.FilterType_CHAIN:
+		if f.GetMode() == proto.FilterMode_OR {
+			c.Mode = filter.OR_MODE
+		}
+		for _, sub := range f.GetFilters() {
+			c.Filters = append(c.Filters, FilterConfigFromProto(sub))
+		}
+	}
+	return c
+}
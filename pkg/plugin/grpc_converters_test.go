@@ -0,0 +1,43 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"zms.szuro.net/pkg/filter"
+	"zms.szuro.net/pkg/proto"
+)
+
+func TestFilterConfigToProto(t *testing.T) {
+	c := filter.FilterConfig{Mode: filter.OR_MODE, Filters: []filter.FilterConfig{
+		{Type: filter.GROUP_FILTER_TYPE, Accepted: []string{"Linux"}},
+		{Filters: []filter.FilterConfig{
+			{Accepted: []string{"env:prod*"}},
+			{Type: filter.EXPRESSION_FILTER_TYPE, Expression: "severity >= 4"},
+		}},
+	}}
+
+	p := FilterConfigToProto(c)
+	require.Equal(t, proto.FilterType_CHAIN, p.Type)
+	require.Equal(t, proto.FilterMode_OR, p.Mode)
+	require.Equal(t, proto.FilterType_GROUP, p.Filters[0].Type)
+	require.Equal(t, proto.FilterMode_AND, p.Filters[1].Mode)
+	require.Equal(t, proto.FilterType_EXPRESSION, p.Filters[1].Filters[1].Type)
+
+	back := FilterConfigFromProto(p)
+	require.Equal(t, filter.OR_MODE, back.Mode)
+	require.Equal(t, filter.GROUP_FILTER_TYPE, back.Filters[0].Type)
+	require.Equal(t, []string{"env:prod*"}, back.Filters[1].Filters[0].Accepted)
+	require.Equal(t, "severity >= 4", back.Filters[1].Filters[1].Expression)
+	require.NoError(t, back.Validate())
+}
+
+func TestCreateFilterFromProto(t *testing.T) {
+	b := NewBaseObserverGRPC()
+	f, err := b.createFilterFromProto(&proto.Filter{Type: proto.FilterType_GROUP, Accepted: []string{"Linux"}})
+	require.NoError(t, err)
+	require.IsType(t, &filter.GroupFilter{}, f)
+
+	_, err = b.createFilterFromProto(&proto.Filter{Type: proto.FilterType_EXPRESSION, Expression: "host =="})
+	require.Error(t, err)
+}
@@ -257,6 +257,10 @@ const (
 	FilterType_TAG FilterType = 0
 	// GROUP filters based on host groups.
 	FilterType_GROUP FilterType = 1
+	// EXPRESSION filters with an expression over fields of values.
+	FilterType_EXPRESSION FilterType = 2
+	// CHAIN combines filters.
+	FilterType_CHAIN FilterType = 3
 	// CUSTOM filters based on custom criteria (not implemented).
 	FilterType_CUSTOM FilterType = 69
 )
@@ -266,12 +270,16 @@ var (
 	FilterType_name = map[int32]string{
 		0:  "TAG",
 		1:  "GROUP",
+		2:  "EXPRESSION",
+		3:  "CHAIN",
 		69: "CUSTOM",
 	}
 	FilterType_value = map[string]int32{
-		"TAG":    0,
-		"GROUP":  1,
-		"CUSTOM": 69,
+		"TAG":        0,
+		"GROUP":      1,
+		"EXPRESSION": 2,
+		"CHAIN":      3,
+		"CUSTOM":     69,
 	}
 )
 
@@ -302,6 +310,55 @@ func (FilterType) EnumDescriptor() ([]byte, []int) {
 	return file_pkg_proto_zbx_exports_proto_rawDescGZIP(), []int{4}
 }
 
+// FilterMode represents how filters of a chain are combined.
+type FilterMode int32
+
+const (
+	// AND accepts values accepted by all filters.
+	FilterMode_AND FilterMode = 0
+	// OR accepts values accepted by any filter.
+	FilterMode_OR FilterMode = 1
+)
+
+// Enum value maps for FilterMode.
+var (
+	FilterMode_name = map[int32]string{
+		0: "AND",
+		1: "OR",
+	}
+	FilterMode_value = map[string]int32{
+		"AND": 0,
+		"OR":  1,
+	}
+)
+
+func (x FilterMode) Enum() *FilterMode {
+	p := new(FilterMode)
+	*p = x
+	return p
+}
+
+func (x FilterMode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (FilterMode) Descriptor() protoreflect.EnumDescriptor {
+	return file_pkg_proto_zbx_exports_proto_enumTypes[5].Descriptor()
+}
+
+func (FilterMode) Type() protoreflect.EnumType {
+	return &file_pkg_proto_zbx_exports_proto_enumTypes[5]
+}
+
+func (x FilterMode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use FilterMode.Descriptor instead.
+func (FilterMode) EnumDescriptor() ([]byte, []int) {
+	return file_pkg_proto_zbx_exports_proto_rawDescGZIP(), []int{5}
+}
+
 // Host represents a Zabbix host with its technical name and display name.
 type Host struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
@@ -1328,10 +1385,11 @@ func (x *InitializeResponse) GetPluginInfo() *PluginInfo {
 	return nil
 }
 
-// Filter represents a single filter configuration with accept/reject patterns.
+// Filter represents a single filter configuration with accept/reject patterns,
+// or a chain of filters.
 type Filter struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// type indicates the filter type (tag, group or custom).
+	// type indicates the filter type (tag, group, expression, chain or custom).
 	Type FilterType `protobuf:"varint,1,opt,name=type,proto3,enum=proto.FilterType" json:"type,omitempty"`
 	// accepted contains patterns that should be accepted.
 	// For TAG filters: "tag_name:tag_value_pattern"
@@ -1340,7 +1398,14 @@ type Filter struct {
 	// rejected contains patterns that should be rejected.
 	// For TAG filters: "tag_name:tag_value_pattern"
 	// For GROUP filters: "group_name_pattern"
-	Rejected      []string `protobuf:"bytes,3,rep,name=rejected,proto3" json:"rejected,omitempty"`
+	Rejected []string `protobuf:"bytes,3,rep,name=rejected,proto3" json:"rejected,omitempty"`
+	// expression is used by EXPRESSION filters, e.g.
+	// severity >= 4 && "Linux" in groups
+	Expression string `protobuf:"bytes,4,opt,name=expression,proto3" json:"expression,omitempty"`
+	// filters contains the filters combined by CHAIN filters.
+	Filters []*Filter `protobuf:"bytes,5,rep,name=filters,proto3" json:"filters,omitempty"`
+	// mode indicates how filters of CHAIN filters are combined.
+	Mode          FilterMode `protobuf:"varint,6,opt,name=mode,proto3,enum=proto.FilterMode" json:"mode,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -1396,6 +1461,27 @@ func (x *Filter) GetRejected() []string {
 	return nil
 }
 
+func (x *Filter) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
+func (x *Filter) GetFilters() []*Filter {
+	if x != nil {
+		return x.Filters
+	}
+	return nil
+}
+
+func (x *Filter) GetMode() FilterMode {
+	if x != nil {
+		return x.Mode
+	}
+	return FilterMode_AND
+}
+
 // CleanupRequest is sent to request cleanup of observer resources.
 type CleanupRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -1578,11 +1664,16 @@ const file_pkg_proto_zbx_exports_proto_rawDesc = "" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
 	"\x05error\x18\x02 \x01(\tR\x05error\x122\n" +
 	"\vplugin_info\x18\x03 \x01(\v2\x11.proto.PluginInfoR\n" +
-	"pluginInfo\"g\n" +
+	"pluginInfo\"\xd7\x01\n" +
 	"\x06Filter\x12%\n" +
 	"\x04type\x18\x01 \x01(\x0e2\x11.proto.FilterTypeR\x04type\x12\x1a\n" +
 	"\baccepted\x18\x02 \x03(\tR\baccepted\x12\x1a\n" +
-	"\brejected\x18\x03 \x03(\tR\brejected\"\x10\n" +
+	"\brejected\x18\x03 \x03(\tR\brejected\x12\x1e\n" +
+	"\n" +
+	"expression\x18\x04 \x01(\tR\n" +
+	"expression\x12'\n" +
+	"\afilters\x18\x05 \x03(\v2\r.proto.FilterR\afilters\x12%\n" +
+	"\x04mode\x18\x06 \x01(\x0e2\x11.proto.FilterModeR\x04mode\"\x10\n" +
 	"\x0eCleanupRequest\"A\n" +
 	"\x0fCleanupResponse\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
@@ -1610,13 +1701,20 @@ const file_pkg_proto_zbx_exports_proto_rawDesc = "" +
 	"\aWARNING\x10\x02\x12\v\n" +
 	"\aAVERAGE\x10\x03\x12\b\n" +
 	"\x04HIGH\x10\x04\x12\f\n" +
-	"\bDISASTER\x10\x05*,\n" +
+	"\bDISASTER\x10\x05*G\n" +
 	"\n" +
 	"FilterType\x12\a\n" +
 	"\x03TAG\x10\x00\x12\t\n" +
-	"\x05GROUP\x10\x01\x12\n" +
+	"\x05GROUP\x10\x01\x12\x0e\n" +
+	"\n" +
+	"EXPRESSION\x10\x02\x12\t\n" +
+	"\x05CHAIN\x10\x03\x12\n" +
+	"\n" +
+	"\x06CUSTOM\x10E*\x1d\n" +
 	"\n" +
-	"\x06CUSTOM\x10E2\xc7\x02\n" +
+	"FilterMode\x12\a\n" +
+	"\x03AND\x10\x00\x12\x06\n" +
+	"\x02OR\x10\x012\xc7\x02\n" +
 	"\x0fObserverService\x12A\n" +
 	"\n" +
 	"Initialize\x12\x18.proto.InitializeRequest\x1a\x19.proto.InitializeResponse\x12=\n" +
@@ -1639,7 +1737,7 @@ func file_pkg_proto_zbx_exports_proto_rawDescGZIP() []byte {
 	return file_pkg_proto_zbx_exports_proto_rawDescData
 }
 
-var file_pkg_proto_zbx_exports_proto_enumTypes = make([]protoimpl.EnumInfo, 5)
+var file_pkg_proto_zbx_exports_proto_enumTypes = make([]protoimpl.EnumInfo, 6)
 var file_pkg_proto_zbx_exports_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
 var file_pkg_proto_zbx_exports_proto_goTypes = []any{
 	(ValueType)(0),             // 0: proto.ValueType
@@ -1647,58 +1745,61 @@ var file_pkg_proto_zbx_exports_proto_goTypes = []any{
 	(EventValue)(0),            // 2: proto.EventValue
 	(Severity)(0),              // 3: proto.Severity
 	(FilterType)(0),            // 4: proto.FilterType
-	(*Host)(nil),               // 5: proto.Host
-	(*Tag)(nil),                // 6: proto.Tag
-	(*History)(nil),            // 7: proto.History
-	(*Trend)(nil),              // 8: proto.Trend
-	(*Event)(nil),              // 9: proto.Event
-	(*SaveHistoryRequest)(nil), // 10: proto.SaveHistoryRequest
-	(*SaveTrendsRequest)(nil),  // 11: proto.SaveTrendsRequest
-	(*SaveEventsRequest)(nil),  // 12: proto.SaveEventsRequest
-	(*SaveResponse)(nil),       // 13: proto.SaveResponse
-	(*InitializeRequest)(nil),  // 14: proto.InitializeRequest
-	(*PluginInfo)(nil),         // 15: proto.PluginInfo
-	(*InitializeResponse)(nil), // 16: proto.InitializeResponse
-	(*Filter)(nil),             // 17: proto.Filter
-	(*CleanupRequest)(nil),     // 18: proto.CleanupRequest
-	(*CleanupResponse)(nil),    // 19: proto.CleanupResponse
-	nil,                        // 20: proto.InitializeRequest.OptionsEntry
+	(FilterMode)(0),            // 5: proto.FilterMode
+	(*Host)(nil),               // 6: proto.Host
+	(*Tag)(nil),                // 7: proto.Tag
+	(*History)(nil),            // 8: proto.History
+	(*Trend)(nil),              // 9: proto.Trend
+	(*Event)(nil),              // 10: proto.Event
+	(*SaveHistoryRequest)(nil), // 11: proto.SaveHistoryRequest
+	(*SaveTrendsRequest)(nil),  // 12: proto.SaveTrendsRequest
+	(*SaveEventsRequest)(nil),  // 13: proto.SaveEventsRequest
+	(*SaveResponse)(nil),       // 14: proto.SaveResponse
+	(*InitializeRequest)(nil),  // 15: proto.InitializeRequest
+	(*PluginInfo)(nil),         // 16: proto.PluginInfo
+	(*InitializeResponse)(nil), // 17: proto.InitializeResponse
+	(*Filter)(nil),             // 18: proto.Filter
+	(*CleanupRequest)(nil),     // 19: proto.CleanupRequest
+	(*CleanupResponse)(nil),    // 20: proto.CleanupResponse
+	nil,                        // 21: proto.InitializeRequest.OptionsEntry
 }
 var file_pkg_proto_zbx_exports_proto_depIdxs = []int32{
-	5,  // 0: proto.History.host:type_name -> proto.Host
-	6,  // 1: proto.History.tags:type_name -> proto.Tag
+	6,  // 0: proto.History.host:type_name -> proto.Host
+	7,  // 1: proto.History.tags:type_name -> proto.Tag
 	0,  // 2: proto.History.value_type:type_name -> proto.ValueType
 	3,  // 3: proto.History.severity:type_name -> proto.Severity
-	5,  // 4: proto.Trend.host:type_name -> proto.Host
-	6,  // 5: proto.Trend.tags:type_name -> proto.Tag
+	6,  // 4: proto.Trend.host:type_name -> proto.Host
+	7,  // 5: proto.Trend.tags:type_name -> proto.Tag
 	0,  // 6: proto.Trend.value_type:type_name -> proto.ValueType
 	2,  // 7: proto.Event.value:type_name -> proto.EventValue
 	3,  // 8: proto.Event.severity:type_name -> proto.Severity
-	5,  // 9: proto.Event.hosts:type_name -> proto.Host
-	6,  // 10: proto.Event.tags:type_name -> proto.Tag
-	7,  // 11: proto.SaveHistoryRequest.history:type_name -> proto.History
-	8,  // 12: proto.SaveTrendsRequest.trends:type_name -> proto.Trend
-	9,  // 13: proto.SaveEventsRequest.events:type_name -> proto.Event
-	20, // 14: proto.InitializeRequest.options:type_name -> proto.InitializeRequest.OptionsEntry
+	6,  // 9: proto.Event.hosts:type_name -> proto.Host
+	7,  // 10: proto.Event.tags:type_name -> proto.Tag
+	8,  // 11: proto.SaveHistoryRequest.history:type_name -> proto.History
+	9,  // 12: proto.SaveTrendsRequest.trends:type_name -> proto.Trend
+	10, // 13: proto.SaveEventsRequest.events:type_name -> proto.Event
+	21, // 14: proto.InitializeRequest.options:type_name -> proto.InitializeRequest.OptionsEntry
 	1,  // 15: proto.InitializeRequest.exports:type_name -> proto.ExportType
-	17, // 16: proto.InitializeRequest.filter:type_name -> proto.Filter
-	15, // 17: proto.InitializeResponse.plugin_info:type_name -> proto.PluginInfo
+	18, // 16: proto.InitializeRequest.filter:type_name -> proto.Filter
+	16, // 17: proto.InitializeResponse.plugin_info:type_name -> proto.PluginInfo
 	4,  // 18: proto.Filter.type:type_name -> proto.FilterType
-	14, // 19: proto.ObserverService.Initialize:input_type -> proto.InitializeRequest
-	10, // 20: proto.ObserverService.SaveHistory:input_type -> proto.SaveHistoryRequest
-	11, // 21: proto.ObserverService.SaveTrends:input_type -> proto.SaveTrendsRequest
-	12, // 22: proto.ObserverService.SaveEvents:input_type -> proto.SaveEventsRequest
-	18, // 23: proto.ObserverService.Cleanup:input_type -> proto.CleanupRequest
-	16, // 24: proto.ObserverService.Initialize:output_type -> proto.InitializeResponse
-	13, // 25: proto.ObserverService.SaveHistory:output_type -> proto.SaveResponse
-	13, // 26: proto.ObserverService.SaveTrends:output_type -> proto.SaveResponse
-	13, // 27: proto.ObserverService.SaveEvents:output_type -> proto.SaveResponse
-	19, // 28: proto.ObserverService.Cleanup:output_type -> proto.CleanupResponse
-	24, // [24:29] is the sub-list for method output_type
-	19, // [19:24] is the sub-list for method input_type
-	19, // [19:19] is the sub-list for extension type_name
-	19, // [19:19] is the sub-list for extension extendee
-	0,  // [0:19] is the sub-list for field type_name
+	18, // 19: proto.Filter.filters:type_name -> proto.Filter
+	5,  // 20: proto.Filter.mode:type_name -> proto.FilterMode
+	15, // 21: proto.ObserverService.Initialize:input_type -> proto.InitializeRequest
+	11, // 22: proto.ObserverService.SaveHistory:input_type -> proto.SaveHistoryRequest
+	12, // 23: proto.ObserverService.SaveTrends:input_type -> proto.SaveTrendsRequest
+	13, // 24: proto.ObserverService.SaveEvents:input_type -> proto.SaveEventsRequest
+	19, // 25: proto.ObserverService.Cleanup:input_type -> proto.CleanupRequest
+	17, // 26: proto.ObserverService.Initialize:output_type -> proto.InitializeResponse
+	14, // 27: proto.ObserverService.SaveHistory:output_type -> proto.SaveResponse
+	14, // 28: proto.ObserverService.SaveTrends:output_type -> proto.SaveResponse
+	14, // 29: proto.ObserverService.SaveEvents:output_type -> proto.SaveResponse
+	20, // 30: proto.ObserverService.Cleanup:output_type -> proto.CleanupResponse
+	26, // [26:31] is the sub-list for method output_type
+	21, // [21:26] is the sub-list for method input_type
+	21, // [21:21] is the sub-list for extension type_name
+	21, // [21:21] is the sub-list for extension extendee
+	0,  // [0:21] is the sub-list for field type_name
 }
 
 func init() { file_pkg_proto_zbx_exports_proto_init() }
@@ -1715,7 +1816,7 @@ func file_pkg_proto_zbx_exports_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pkg_proto_zbx_exports_proto_rawDesc), len(file_pkg_proto_zbx_exports_proto_rawDesc)),
-			NumEnums:      5,
+			NumEnums:      6,
 			NumMessages:   16,
 			NumExtensions: 0,
 			NumServices:   1,